@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	maxSummitAccuracy = flag.Float64("max_summit_accuracy", 30, "Warn when the estimated horizontal accuracy around the summit is worse than this many meters")
+	interactive       = flag.Bool("interactive", false, "Ask on the terminal before uploading ascents with low match confidence")
+)
+
+// Meters of horizontal error per unit of HDOP, a typical consumer GPS user
+// equivalent range error.
+const metersPerHDOP = 5
+
+// Points within this long of the summit moment are used to estimate accuracy.
+const accuracyWindow = 5 * time.Minute
+
+// Estimated horizontal accuracy of the track around the summit, in meters.
+type SummitAccuracy struct {
+	// RMS distance of points on the summit from their centroid. While
+	// stationary on the summit this is mostly GPS noise.
+	Scatter float64
+	// Median HDOP near the summit, zero when the track doesn't record it.
+	HDOP float64
+	// The worse of the scatter and HDOP based estimates.
+	Estimate float64
+}
+
+func (a *SummitAccuracy) String() string {
+	s := fmt.Sprintf("±%.0fm (scatter %.0fm", a.Estimate, a.Scatter)
+	if a.HDOP > 0 {
+		s += fmt.Sprintf(", HDOP %.1f", a.HDOP)
+	}
+	return s + ")"
+}
+
+// Estimates horizontal accuracy from the points near the summit in time and
+// within -summit_radius, or nil when there are too few.
+func EstimateSummitAccuracy(points []gpx.GPXPoint, summit *gpx.GPXPoint) *SummitAccuracy {
+	var near []*gpx.GPXPoint
+	var hdops []float64
+	for i := range points {
+		p := &points[i]
+		dt := p.Timestamp.Sub(summit.Timestamp)
+		if dt < -accuracyWindow || dt > accuracyWindow {
+			continue
+		}
+		if p.HorizontalDilution.NotNull() {
+			hdops = append(hdops, p.HorizontalDilution.Value())
+		}
+		if pointDistance(p, summit) <= *summitRadius {
+			near = append(near, p)
+		}
+	}
+	if len(near) < 3 {
+		return nil
+	}
+
+	var lat, lng float64
+	for _, p := range near {
+		lat += p.Latitude
+		lng += p.Longitude
+	}
+	lat /= float64(len(near))
+	lng /= float64(len(near))
+	sum := 0.0
+	for _, p := range near {
+		d := Distance(p.Latitude, p.Longitude, lat, lng)
+		sum += d * d
+	}
+
+	a := &SummitAccuracy{Scatter: math.Sqrt(sum / float64(len(near)))}
+	if len(hdops) > 0 {
+		sort.Float64s(hdops)
+		a.HDOP = hdops[len(hdops)/2]
+	}
+	a.Estimate = math.Max(a.Scatter, a.HDOP*metersPerHDOP)
+	return a
+}
+
+// Whether the summit accuracy is too poor to trust the peak match.
+func (a *SummitAccuracy) Low() bool {
+	return a != nil && a.Estimate > *maxSummitAccuracy
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	approvalAddr = flag.String("approval_addr", "", "In daemon mode, hold new ascents for approval on a web page served at this address, e.g. :8080. The page has no authentication, only serve it on a trusted network")
+)
+
+// A prepared ascent waiting for approval.
+type pendingAscent struct {
+	ID     int
+	Queued time.Time
+	// Input directory the track was found in, whose history records it.
+	Directory string
+
+	Prep  *PreparedAscent
+	Entry *ReportEntry
+}
+
+// Ascents found by daemon scans that are held until approved or skipped on
+// the approval page.
+type ApprovalQueue struct {
+	mu      sync.Mutex
+	nextID  int
+	pending []*pendingAscent
+	// Files with at least one skipped ascent.
+	skipped map[string]bool
+}
+
+func NewApprovalQueue() *ApprovalQueue {
+	return &ApprovalQueue{skipped: make(map[string]bool)}
+}
+
+func (q *ApprovalQueue) Add(dir string, prep *PreparedAscent, entry *ReportEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	q.pending = append(q.pending, &pendingAscent{ID: q.nextID, Queued: outputNow(), Directory: dir, Prep: prep, Entry: entry})
+}
+
+// Number of ascents ever queued. Safe on a nil queue.
+func (q *ApprovalQueue) added() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.nextID
+}
+
+// Whether any ascent from the file in an input directory is awaiting
+// approval. Safe on a nil queue.
+func (q *ApprovalQueue) HasFile(dir, file string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range q.pending {
+		if p.Entry.File == file && p.Directory == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the report entry is awaiting approval. Safe on a nil queue.
+func (q *ApprovalQueue) HasEntry(e *ReportEntry) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range q.pending {
+		if p.Entry == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *ApprovalQueue) remove(id int) *pendingAscent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+func (q *ApprovalQueue) list() []*pendingAscent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*pendingAscent(nil), q.pending...)
+}
+
+// Uploads or discards a pending ascent. Once every ascent from a file is
+// resolved the file is recorded in the history so it isn't scanned again.
+func (u *Uploader) resolvePending(id int, approve bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	p := u.approvals.remove(id)
+	if p == nil {
+		return fmt.Errorf("no pending ascent %d", id)
+	}
+	file := p.Entry.File
+	u.dir = p.Directory
+
+	if approve {
+		if err := u.addAscent(p.Prep, p.Entry); err != nil {
+			// Put it back so it can be retried from the page.
+			u.approvals.mu.Lock()
+			u.approvals.pending = append(u.approvals.pending, p)
+			u.approvals.mu.Unlock()
+			return err
+		}
+	} else {
+		log.Infof("Skipped ascent of %q from %q", p.Prep.PeakName, file)
+		p.Entry.Error = "skipped on approval page"
+		u.approvals.mu.Lock()
+		u.approvals.skipped[file] = true
+		u.approvals.mu.Unlock()
+	}
+
+	if u.approvals.HasFile(p.Directory, file) {
+		return nil
+	}
+	if err := u.LoadHistory(); err != nil {
+		return err
+	}
+	h := &History{Added: outputNow()}
+	u.approvals.mu.Lock()
+	if u.approvals.skipped[file] {
+		h.Error, h.Category = "skipped on approval page", "skipped"
+		delete(u.approvals.skipped, file)
+	}
+	u.approvals.mu.Unlock()
+	u.FilenameHistory[file] = h
+	if err := u.SaveHistory(); err != nil {
+		return err
+	}
+	return u.WriteReport()
+}
+
+var approvalPage = template.Must(template.New("approval").Funcs(template.FuncMap(tripReportFuncs)).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Pending ascents ({{len .Pending}})</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 40em; padding: 0.5em; }
+.ascent { border-bottom: 1px solid #ccc; padding: 0.75em 0; }
+.ascent h2 { font-size: 1.2em; margin: 0 0 0.25em; }
+.meta { color: #555; font-size: 0.9em; }
+form { display: inline; }
+button { font-size: 1.1em; margin: 0.5em 0.5em 0 0; padding: 0.5em 1.5em; }
+</style>
+</head>
+<body>
+<h1>Pending ascents</h1>
+{{range .Pending}}
+<div class="ascent">
+<h2>{{.Prep.PeakName}}</h2>
+<div class="meta">{{.Prep.Ascent.Date.Local.Format "Mon Jan 2 2006 15:04"}} &middot; {{feet .Entry.Gain}} ft gain &middot; up {{.Prep.Ascent.TimeUp}}</div>
+<div class="meta">{{.Entry.File}}{{with .Entry.Track}} &middot; {{.}}{{end}}</div>
+<form method="post" action="approve"><input type="hidden" name="id" value="{{.ID}}"><input type="hidden" name="token" value="{{$.Token}}"><button>Approve</button></form>
+<form method="post" action="skip"><input type="hidden" name="id" value="{{.ID}}"><input type="hidden" name="token" value="{{$.Token}}"><button>Skip</button></form>
+</div>
+{{else}}
+<p>Nothing awaiting approval.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// Serves the approval page. Its forms carry token, which posts must echo so
+// other sites open in the browser can't approve ascents.
+func (u *Uploader) approvalHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			Pending []*pendingAscent
+			Token   string
+		}{u.approvals.list(), token}
+		if err := approvalPage.Execute(w, data); err != nil {
+			log.Errorf("Failed to render approval page: %v", err)
+		}
+	})
+	resolve := func(approve bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(token)) != 1 {
+				http.Error(w, "bad token, reload the page", http.StatusForbidden)
+				return
+			}
+			id, err := strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				http.Error(w, "bad id", http.StatusBadRequest)
+				return
+			}
+			if err := u.resolvePending(id, approve); err != nil {
+				log.Errorf("%v", err)
+				http.Error(w, Redact(err.Error()), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "./", http.StatusSeeOther)
+		}
+	}
+	mux.HandleFunc("/approve", resolve(true))
+	mux.HandleFunc("/skip", resolve(false))
+	return mux
+}
+
+// Starts serving the approval page and enables holding ascents for approval.
+func (u *Uploader) StartApprovalServer() error {
+	token, err := randomString()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", *approvalAddr)
+	if err != nil {
+		return fmt.Errorf("approval server %w", err)
+	}
+	u.approvals = NewApprovalQueue()
+	log.Infof("Serving approval page on http://%v/", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, u.approvalHandler(token)); err != nil {
+			log.Errorf("Approval server stopped: %v", err)
+		}
+	}()
+	return nil
+}
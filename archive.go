@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lists zip archives in an input directory, such as Strava and Garmin bulk
+// exports.
+func ListInputArchives(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(longPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var archives []os.FileInfo
+	for _, fi := range files {
+		if !fi.IsDir() && strings.ToLower(filepath.Ext(fi.Name())) == ".zip" {
+			archives = append(archives, fi)
+		}
+	}
+	return archives, nil
+}
+
+// Whether an archive entry is a track file, skipping folders and the
+// metadata macOS adds to archives.
+func archiveEntrySupported(f *zip.File) bool {
+	if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(path.Base(f.Name), "._") {
+		return false
+	}
+	return trackFileSupported(f.Name)
+}
+
+// Uploads each track file in a zip archive. Entries are recorded in the
+// history individually as "archive.zip/path/in/archive.gpx", so an archive
+// that grows between exports only has its new entries processed.
+func (u *Uploader) RunArchive(fi os.FileInfo) error {
+	zr, err := zip.OpenReader(longPath(filepath.Join(u.dir, fi.Name())))
+	if err != nil {
+		return u.processInput(fi.Name(), nil, func() error {
+			return fmt.Errorf("open archive %w", err)
+		})
+	}
+	defer zr.Close()
+
+	return forEachArchiveEntry(&zr.Reader, fi.Name(), func(name string, extract func() (string, error)) error {
+		return u.processInput(name, nil, func() error {
+			filename, err := extract()
+			if err != nil {
+				return err
+			}
+			defer os.Remove(filename)
+			return u.uploadRecovering(filename, name)
+		})
+	})
+}
+
+// Calls visit for each track file in a zip archive with its history name
+// and a function extracting it to a temporary file, which the caller
+// removes. Entries are only extracted when visit asks for them.
+func forEachArchiveEntry(zr *zip.Reader, archive string, visit func(name string, extract func() (string, error)) error) error {
+	tmp, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, f := range zr.File {
+		if !archiveEntrySupported(f) {
+			continue
+		}
+		f := f
+		extract := func() (string, error) {
+			return extractArchiveEntry(f, tmp)
+		}
+		if err := visit(archive+"/"+f.Name, extract); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extracts an entry into dir under its base name, which keeps the extension
+// that selects the decoder.
+func extractArchiveEntry(f *zip.File, dir string) (string, error) {
+	log.Infof("Extracting %q", f.Name)
+	r, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("open archive entry %w", err)
+	}
+	defer r.Close()
+
+	filename := filepath.Join(dir, path.Base(f.Name))
+	w, err := os.Create(longPath(filename))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(filename)
+		return "", fmt.Errorf("extract archive entry %w", err)
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(filename)
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	assistedMode      = flag.String("assisted", "annotate", "How to log ascents gaining elevation by vehicle, tram or lift: annotate the trip report, flag the ascent on drivers that support it (annotating otherwise), skip drive-ups, or off")
+	assistedClimbRate = flag.Float64("assisted_climb_rate", 2000, "Climb rate in meters per hour above which elevation gain is taken as motorized")
+	assistedSpeed     = flag.Float64("assisted_speed", 5, "Ground speed in meters per second above which a climb is taken as motorized")
+	minOwnGain        = flag.Float64("min_own_gain", 100, "Gain in meters under your own power below which an ascent counts as a drive-up")
+)
+
+var ErrDriveUp = errors.New("drive-up")
+
+// Rates are measured over this long, so a lift or road shows as a sustained
+// stretch rather than GPS noise.
+const assistedWindow = 2 * time.Minute
+
+// Implemented by drivers that can flag ascents as motorized or lift served,
+// given the gain in meters by machine and under your own power.
+type AscentAssistance interface {
+	SetAscentAssisted(id peakbagger.AscentID, assistedGain, ownGain float64) error
+}
+
+// Elevation gained by vehicle, tram or lift.
+type AssistedStats struct {
+	// Gain in meters by machine and under your own power.
+	AssistedGain, OwnGain float64
+	// Set when the own power gain is under -min_own_gain.
+	DriveUp bool
+
+	// Number of points in motorized windows before each point index, so
+	// checks can tell whether a stretch of the track was motorized.
+	markedBefore []int
+}
+
+// Whether any point from i to j, inclusive, is in a motorized window.
+func (s *AssistedStats) covers(i, j int) bool {
+	if s == nil || s.markedBefore == nil {
+		return false
+	}
+	return s.markedBefore[j+1]-s.markedBefore[i] > 0
+}
+
+// Splits gain into motorized and own power, or returns nil when the track
+// was climbed entirely under its own power or -assisted is off.
+func ComputeAssisted(points []gpx.GPXPoint, gain float64) *AssistedStats {
+	if *assistedMode == "off" {
+		return nil
+	}
+	// Mark points in windows climbing too fast or moving too fast to be on
+	// foot, then sum the climbing between marked points.
+	marked := make([]bool, len(points))
+	for i := range points {
+		j := i
+		for j+1 < len(points) && points[j].Timestamp.Sub(points[i].Timestamp) < assistedWindow {
+			j++
+		}
+		dt := points[j].Timestamp.Sub(points[i].Timestamp)
+		if dt < assistedWindow {
+			break
+		}
+		if points[i].Elevation.Null() || points[j].Elevation.Null() {
+			continue
+		}
+		climb := points[j].Elevation.Value() - points[i].Elevation.Value()
+		speed := pointDistance(&points[i], &points[j]) / dt.Seconds()
+		if climb > 0 && (climb/dt.Hours() > *assistedClimbRate || speed > *assistedSpeed) {
+			for k := i; k <= j; k++ {
+				marked[k] = true
+			}
+		}
+	}
+	assisted := 0.0
+	for k := 1; k < len(points); k++ {
+		if !marked[k-1] || !marked[k] || points[k-1].Elevation.Null() || points[k].Elevation.Null() {
+			continue
+		}
+		if d := points[k].Elevation.Value() - points[k-1].Elevation.Value(); d > 0 {
+			assisted += d
+		}
+	}
+	if assisted == 0 {
+		return nil
+	}
+	s := &AssistedStats{AssistedGain: assisted, OwnGain: gain - assisted, markedBefore: make([]int, len(points)+1)}
+	for k, m := range marked {
+		s.markedBefore[k+1] = s.markedBefore[k]
+		if m {
+			s.markedBefore[k+1]++
+		}
+	}
+	if s.OwnGain < 0 {
+		s.OwnGain = 0
+	}
+	s.DriveUp = s.OwnGain < *minOwnGain
+	return s
+}
+
+// Checks -assisted for an ascent with motorized gain, rejecting drive-ups
+// when set to skip.
+func CheckAssisted(s *AssistedStats, peakName string) error {
+	switch *assistedMode {
+	case "annotate", "flag", "off":
+	case "skip":
+		if s != nil && s.DriveUp {
+			return fmt.Errorf("%w of %q, only %.0fm of gain under own power", ErrDriveUp, peakName, s.OwnGain)
+		}
+	default:
+		return fmt.Errorf("unknown -assisted mode %q", *assistedMode)
+	}
+	return nil
+}
+
+// Flags an added ascent as motorized when -assisted is flag. The trip report
+// notes it either way.
+func (u *Uploader) applyAssisted(id peakbagger.AscentID, s *AssistedStats) error {
+	if s == nil || *assistedMode != "flag" {
+		return nil
+	}
+	a, ok := u.client.(AscentAssistance)
+	if !ok {
+		return fmt.Errorf("driver %q does not support flagging motorized ascents, it is only noted in the trip report", *driver)
+	}
+	return a.SetAscentAssisted(id, s.AssistedGain, s.OwnGain)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Implemented by drivers that can edit an existing ascent in place.
+type AscentUpdater interface {
+	UpdateAscent(existing *peakbagger.Ascent, a peakbagger.Ascent) error
+}
+
+var (
+	// Manual logs are often a day off due to timezone confusion.
+	dateTolerance = flag.Int("date_tolerance", 1, "Days a logged ascent's date may differ from the track and still be considered the same ascent (asks before correcting it)")
+)
+
+// Returns the logged ascent of a peak with no track attached, preferring one
+// on the same local date over one within -date_tolerance days. Returns nil if
+// there is none.
+func tracklessAscent(ascents peakbagger.AscentList, a *peakbagger.Ascent) (existing *peakbagger.Ascent, exact bool) {
+	day := ascentDay(a.Date.Local())
+	best := -1
+	for _, l := range ascents {
+		if l.Date == nil || l.Gpx != nil || fmt.Sprint(l.PeakID) != fmt.Sprint(a.PeakID) {
+			continue
+		}
+		d := daysApart(ascentDay(l.Date.Local()), day)
+		if d <= *dateTolerance && (best < 0 || d < best) {
+			existing, best = l, d
+		}
+	}
+	return existing, best == 0
+}
+
+// Attaches a track to an existing track-less ascent of the same peak and day.
+func (u *Uploader) AttachTrack(src *Source, t gpx.GPXTrack) (err error) {
+	entry := u.report.NewEntry(src, &t)
+	defer func() {
+		entry.Finish(err)
+	}()
+
+	prep, err := u.PrepareAscent(src, t, entry)
+	if err != nil {
+		return err
+	}
+	ascents, err := u.Ascents()
+	if err != nil {
+		return fmt.Errorf("list ascents %w", err)
+	}
+	day := ascentDay(prep.Ascent.Date.Local())
+	existing, exact := tracklessAscent(ascents, &prep.Ascent)
+	if existing == nil {
+		log.Infof("No track-less ascent of %q on %s, skipping", prep.PeakName, day)
+		return nil
+	}
+	if !exact {
+		logged := ascentDay(existing.Date.Local())
+		if !confirm("%s was logged on %s but the track shows %s. Correct the date and attach the track?", prep.PeakName, logged, day) {
+			log.Infof("Leaving ascent of %q on %s unchanged", prep.PeakName, logged)
+			return nil
+		}
+	}
+
+	log.Infof("Attaching track to ascent of %q on %s", prep.PeakName, ascentDay(existing.Date.Local()))
+
+	if *dryRun {
+		log.Infof("DRY RUN, skipping ascent update")
+		return nil
+	}
+
+	// Checked by the attach commands before they run.
+	updater := u.client.(AscentUpdater)
+	if err := updater.UpdateAscent(existing, prep.Ascent); err != nil {
+		return fmt.Errorf("failed to update ascent %w", err)
+	}
+	u.ascents = nil
+	entry.Uploaded = true
+
+	log.Infof("Attached track to ascent of %q", prep.PeakName)
+	return nil
+}
+
+// Matches every track in the input to logged ascents without tracks and
+// attaches the GPX and computed stats to them. Tracks with no matching
+// track-less ascent are left alone; use the default mode to add those.
+func (u *Uploader) Attach() error {
+	var files []string
+	if *inputFile != "" {
+		files = append(files, *inputFile)
+	} else {
+		fis, err := ListInputFiles(u.dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if !FileSelected(u.dir, fi) {
+				continue
+			}
+			files = append(files, filepath.Join(u.dir, fi.Name()))
+		}
+	}
+
+	for _, filename := range files {
+		if err := u.attachFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attaches the tracks in a file, see Attach. Only returns errors that should
+// stop the run, others are logged.
+func (u *Uploader) attachFile(filename string) error {
+	log.Infof("Attaching tracks from %q", filename)
+	g, err := LoadGPX(filename)
+	if err != nil {
+		log.Warnf("Skipping %q: %v", filename, err)
+		return nil
+	}
+	src := NewSource(filename)
+	for _, gt := range g.Tracks {
+		if !TrackSelected(&gt) {
+			continue
+		}
+		for _, outing := range SplitTrack(gt, *splitGap) {
+			tracks, legs, err := u.SplitAtPeaks(outing)
+			if err != nil {
+				return err
+			}
+			for i, t := range tracks {
+				legSrc := src
+				if legs[i] != nil {
+					legSrc = src.ForLeg(legs[i])
+				}
+				err := u.AttachTrack(legSrc, t)
+				if IsUnavailable(err) {
+					return err
+				}
+				if err != nil {
+					log.Warnf("Failed to attach track %q from %q: %v", t.Name, filename, err)
+				}
+			}
+		}
+	}
+	releaseMemory()
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	recordAttempts = flag.Bool("attempts", false, "Record tracks whose high point is near but not on a peak as attempts in the attempts ledger, instead of failing them")
+	attemptRadius  = flag.Float64("attempt_radius", 2000, "Distance in meters from the high point within which a peak is taken as the objective of an attempt")
+)
+
+const AttemptsFilename = "attempts.json"
+
+var ErrAttempt = errors.New("attempt")
+
+// Implemented by drivers that can log unsuccessful ascents, filling in the
+// elevation reached and distance short of the summit in meters and the
+// reason for turning around.
+type AttemptLogger interface {
+	AddAttempt(a peakbagger.Ascent, elevationReached, distanceShort float64, reason string) (peakbagger.AscentID, error)
+}
+
+// A climb that turned around below the summit.
+type Attempt struct {
+	File  string
+	Track string `json:",omitempty"`
+
+	// The peak taken as the objective: the closest to the high point.
+	PeakID   string
+	PeakName string
+	// When the high point was reached.
+	Date time.Time
+	// Elevation in meters at the closest approach to the summit, and how far
+	// short of the summit that was. These fill in Peakbagger's elevation
+	// reached and distance short fields.
+	ElevationReached float64
+	DistanceShort    float64 `json:",omitempty"`
+	// Free text, e.g. "avalanche conditions", set with attempts reason.
+	Reason string `json:",omitempty"`
+
+	Added time.Time
+	// Set once logged on Peakbagger as an unsuccessful ascent.
+	AscentID peakbagger.AscentID `json:",omitempty"`
+}
+
+// Attempts found in the input directory.
+type AttemptLedger struct {
+	Attempts []*Attempt
+
+	dir string
+}
+
+func LoadAttempts(dir string) (*AttemptLedger, error) {
+	l := &AttemptLedger{dir: dir}
+	b, err := os.ReadFile(longPath(filepath.Join(dir, AttemptsFilename)))
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("parse %s %w", AttemptsFilename, err)
+	}
+	return l, nil
+}
+
+func (l *AttemptLedger) Save() error {
+	if *offlineSimulate {
+		return nil
+	}
+	b, err := json.MarshalIndent(l, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(longPath(filepath.Join(l.dir, AttemptsFilename)), b, 0644)
+}
+
+// Returns the attempt with the given 1-based number as listed.
+func (l *AttemptLedger) Get(arg string) (*Attempt, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(l.Attempts) {
+		return nil, fmt.Errorf("no attempt %q, see attempts list", arg)
+	}
+	return l.Attempts[n-1], nil
+}
+
+// Records a track that didn't reach a peak as an attempt of the closest peak
+// within -attempt_radius. Returns an ErrAttempt error describing it, so the
+// file is recorded in the history and reports as an attempt, or nil if no
+// peak is close enough or, interactively, it wasn't an attempt.
+func (u *Uploader) recordAttempt(src *Source, t *gpx.GPXTrack, highest *gpx.GPXPoint, entry *ReportEntry) error {
+	peaks, err := u.peaksWithin(highest.Latitude, highest.Longitude, *attemptRadius)
+	if err != nil {
+		return err
+	}
+	if len(peaks) == 0 {
+		return nil
+	}
+	peak := peaks[0]
+	a := &Attempt{
+		File:     src.Filename,
+		Track:    t.Name,
+		PeakID:   fmt.Sprint(peak.PeakID),
+		PeakName: peak.Name,
+		Date:     highest.Timestamp,
+		Added:    outputNow(),
+	}
+	a.setClosestApproach(trackPoints(t), highest, peak.Latitude, peak.Longitude)
+	if *interactive {
+		fmt.Println(u.peakFactSheet(peak, highest.Latitude, highest.Longitude))
+		if !confirm("Record an attempt of %s?", peak.Name) {
+			return nil
+		}
+		a.Reason = ask("Reason for turning around", "")
+	}
+	entry.PeakID, entry.PeakName = a.PeakID, a.PeakName
+
+	l, err := LoadAttempts(u.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range l.Attempts {
+		if e.PeakID == a.PeakID && e.Date.Equal(a.Date) {
+			return fmt.Errorf("%w of %q already recorded", ErrAttempt, a.PeakName)
+		}
+	}
+	l.Attempts = append(l.Attempts, a)
+	if *dryRun {
+		log.Infof("DRY RUN, not saving attempt to %s", AttemptsFilename)
+	} else if err := l.Save(); err != nil {
+		return err
+	}
+	log.Infof("Recorded attempt of %q reaching %s, %s short", a.PeakName, formatElevation(a.ElevationReached), formatDistance(a.DistanceShort))
+	return fmt.Errorf("%w of %q reaching %s, see attempts list", ErrAttempt, a.PeakName, formatElevation(a.ElevationReached))
+}
+
+// Sets the elevation reached and distance short from the point closest to
+// the summit. Falls back to the high point's elevation if that point has none.
+func (a *Attempt) setClosestApproach(points []gpx.GPXPoint, highest *gpx.GPXPoint, lat, lng float64) {
+	a.ElevationReached = highest.Elevation.Value()
+	closest := -1
+	for i := range points {
+		d := Distance(points[i].Latitude, points[i].Longitude, lat, lng)
+		if closest < 0 || d < a.DistanceShort {
+			closest, a.DistanceShort = i, d
+		}
+	}
+	if closest >= 0 && !points[closest].Elevation.Null() {
+		a.ElevationReached = points[closest].Elevation.Value()
+	}
+}
+
+// Trip report of the unsuccessful ascent. It repeats the elevation reached
+// and distance short, which drivers may not have fields for.
+func (a *Attempt) tripReport() string {
+	report := fmt.Sprintf("Reached %s, %s short of the summit.", formatElevation(a.ElevationReached), formatDistance(a.DistanceShort))
+	if a.Reason != "" {
+		report += " Turned around: " + a.Reason
+	}
+	return report
+}
+
+// Lists attempts or sets a reason: attempts [list | reason N text].
+func RunAttemptsCommand(args []string) error {
+	l, err := LoadAttempts(*inputDirectory)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "list" {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for i, a := range l.Attempts {
+			status := "local"
+			if a.AscentID != 0 {
+				status = "logged"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s short\t%s\t%s\t%s\n", i+1, a.Date.Local().Format("2006-01-02"), a.PeakName,
+				formatElevation(a.ElevationReached), formatDistance(a.DistanceShort), status, a.File, a.Reason)
+		}
+		return tw.Flush()
+	}
+	if args[0] == "reason" && len(args) >= 3 {
+		a, err := l.Get(args[1])
+		if err != nil {
+			return err
+		}
+		a.Reason = strings.Join(args[2:], " ")
+		return l.Save()
+	}
+	return fmt.Errorf("usage: attempts [list | reason N text]")
+}
+
+// Logs an attempt from the ledger as an unsuccessful ascent: log-attempt N.
+// The track is reprocessed to build the ascent.
+func (u *Uploader) LogAttempt(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: log-attempt N")
+	}
+	// Checked by the log-attempt command before it runs.
+	logger := u.client.(AttemptLogger)
+	l, err := LoadAttempts(u.dir)
+	if err != nil {
+		return err
+	}
+	a, err := l.Get(args[0])
+	if err != nil {
+		return err
+	}
+	if a.AscentID != 0 {
+		return fmt.Errorf("attempt already logged as %s", ascentURL(a.AscentID))
+	}
+
+	g, err := LoadGPX(filepath.Join(l.dir, a.File))
+	if err != nil {
+		return err
+	}
+	var track *gpx.GPXTrack
+	for _, gt := range g.Tracks {
+		for _, t := range SplitTrack(gt, *splitGap) {
+			t, _ = orientTrack(t)
+			t, _ = repairTimestamps(t)
+			times := t.TimeBounds()
+			if !a.Date.Before(times.StartTime) && !a.Date.After(times.EndTime) {
+				track = &t
+			}
+		}
+	}
+	if track == nil {
+		return fmt.Errorf("no track in %q at %v", a.File, a.Date)
+	}
+	times := track.TimeBounds()
+	id, _ := strconv.Atoi(a.PeakID)
+	ascent := peakbagger.Ascent{
+		PeakID:   peakbagger.PeakID(id),
+		Date:     &a.Date,
+		Gpx:      &gpx.GPX{Tracks: []gpx.GPXTrack{stripExtensions(*track)}},
+		TimeUp:   a.Date.Sub(times.StartTime),
+		TimeDown: times.EndTime.Sub(a.Date),
+	}
+	if HideGPX(track) {
+		ascent.Gpx = nil
+	}
+	if a.DistanceShort == 0 {
+		// Recorded before closest approach was tracked.
+		_, lat, lng, err := u.locatePeak(a.PeakID)
+		if err != nil {
+			return fmt.Errorf("locate peak %s %w", a.PeakID, err)
+		}
+		tb, err := ToTrackBounds(*track)
+		if err != nil {
+			return err
+		}
+		a.setClosestApproach(trackPoints(track), tb.Highest, lat, lng)
+	}
+	ascent.TripReport = a.tripReport()
+
+	if *dryRun {
+		log.Infof("DRY RUN, skipping logging attempt of %q", a.PeakName)
+		return nil
+	}
+	aid, err := logger.AddAttempt(ascent, a.ElevationReached, a.DistanceShort, a.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to log attempt %w", err)
+	}
+	a.AscentID = aid
+	log.Infof("Logged unsuccessful ascent of %q", a.PeakName)
+	return l.Save()
+}
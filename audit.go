@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// A track in the local archive and the peak its high point matched.
+type auditTrack struct {
+	File     string
+	Track    string
+	PeakID   string
+	PeakName string
+	Date     time.Time
+}
+
+// Calendar date of an ascent in the time's own location. Track times should
+// be converted to local time first, since that's the date a climber would log.
+func ascentDay(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// Returns the number of whole days between two dates.
+func daysApart(a, b string) int {
+	ta, _ := time.Parse("2006-01-02", a)
+	tb, _ := time.Parse("2006-01-02", b)
+	d := int(ta.Sub(tb).Hours() / 24)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Matches every track in the input directory and its zip archives to a
+// peak without uploading, enumerating tracks and multi-peak legs as an
+// upload would.
+func (u *Uploader) scanArchive() ([]*auditTrack, error) {
+	files, err := ListInputFiles(u.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []*auditTrack
+	for _, fi := range files {
+		if !FileSelected(u.dir, fi) {
+			continue
+		}
+		if tracks, err = u.scanFile(tracks, fi.Name(), filepath.Join(u.dir, fi.Name())); err != nil {
+			return nil, err
+		}
+	}
+
+	archives, err := ListInputArchives(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range archives {
+		zr, err := zip.OpenReader(longPath(filepath.Join(u.dir, fi.Name())))
+		if err != nil {
+			log.Warnf("Skipping %q: %v", fi.Name(), err)
+			continue
+		}
+		err = forEachArchiveEntry(&zr.Reader, fi.Name(), func(name string, extract func() (string, error)) error {
+			filename, err := extract()
+			if err != nil {
+				log.Warnf("Skipping %q: %v", name, err)
+				return nil
+			}
+			defer os.Remove(filename)
+			tracks, err = u.scanFile(tracks, name, filename)
+			return err
+		})
+		zr.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tracks, nil
+}
+
+// Appends the tracks of one file, named as in the upload history, matched
+// to the peak an upload would pick.
+func (u *Uploader) scanFile(tracks []*auditTrack, name, filename string) ([]*auditTrack, error) {
+	defer releaseMemory()
+	g, err := LoadGPX(filename)
+	if err != nil {
+		log.Warnf("Skipping %q: %v", name, err)
+		return tracks, nil
+	}
+	err = u.forEachLeg(g, func(t gpx.GPXTrack, _ *Leg) error {
+		tb, err := ToTrackBounds(t)
+		if err != nil {
+			log.Warnf("Skipping track %q in %q: %v", t.Name, name, err)
+			return nil
+		}
+		peaks, err := u.CandidatePeaks(tb.Highest.Latitude, tb.Highest.Longitude)
+		if err != nil {
+			return err
+		}
+		peaks = preferTitledPeak(peaks, t.Name)
+		at := &auditTrack{File: name, Track: t.Name, Date: tb.Highest.Timestamp.Local()}
+		if len(peaks) > 0 {
+			at.PeakID = fmt.Sprint(peaks[0].PeakID)
+			at.PeakName = peaks[0].Name
+		}
+		tracks = append(tracks, at)
+		return nil
+	})
+	return tracks, err
+}
+
+// Cross-references the local archive with the online ascent list, reporting
+// tracks with no logged ascent, ascents with no local track, and ascents
+// logged within -date_tolerance days of the date the track shows.
+func (u *Uploader) Audit() error {
+	tracks, err := u.scanArchive()
+	if err != nil {
+		return err
+	}
+	ascents, err := u.Ascents()
+	if err != nil {
+		return fmt.Errorf("list ascents %w", err)
+	}
+
+	type logged struct {
+		peakID  string
+		day     string
+		matched bool
+	}
+	var loggedAscents []*logged
+	for _, a := range ascents {
+		if a.Date == nil {
+			continue
+		}
+		loggedAscents = append(loggedAscents, &logged{peakID: fmt.Sprint(a.PeakID), day: ascentDay(*a.Date)})
+	}
+
+	var unlogged, conflicts, unmatched []string
+	for _, t := range tracks {
+		if t.PeakID == "" {
+			unmatched = append(unmatched, fmt.Sprintf("%s %q: no peak near high point", t.File, t.Track))
+			continue
+		}
+		day := ascentDay(t.Date)
+		var exact, near *logged
+		for _, l := range loggedAscents {
+			if l.peakID != t.PeakID {
+				continue
+			}
+			if l.day == day {
+				exact = l
+			} else if daysApart(l.day, day) <= *dateTolerance {
+				near = l
+			}
+		}
+		switch {
+		case exact != nil:
+			exact.matched = true
+		case near != nil:
+			near.matched = true
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s track on %s, logged on %s", t.File, t.PeakName, day, near.day))
+		default:
+			unlogged = append(unlogged, fmt.Sprintf("%s: %s on %s", t.File, t.PeakName, day))
+		}
+	}
+
+	var trackless []string
+	for _, l := range loggedAscents {
+		if !l.matched {
+			trackless = append(trackless, fmt.Sprintf("peak %s on %s", l.peakID, l.day))
+		}
+	}
+
+	printSection := func(title string, lines []string) {
+		sort.Strings(lines)
+		fmt.Printf("%s (%d)\n", title, len(lines))
+		for _, l := range lines {
+			fmt.Printf("  %s\n", l)
+		}
+		fmt.Println()
+	}
+	printSection("Tracks with no logged ascent", unlogged)
+	printSection("Date conflicts", conflicts)
+	printSection("Logged ascents with no local track", trackless)
+	printSection("Tracks with no matching peak", unmatched)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Summit of the synthetic mountain.
+const benchLat, benchLng = 46.85, -121.76
+
+// Generates an out-and-back climb of a noisy cone shaped mountain.
+func syntheticTrack(r *rand.Rand, points int) gpx.GPXTrack {
+	start := time.Date(2020, 1, 1, 6, 0, 0, 0, time.UTC)
+	seg := gpx.GPXTrackSegment{}
+	for i := 0; i < points; i++ {
+		// Progress to the summit and back, 0..1..0.
+		f := 1 - math.Abs(1-2*float64(i)/float64(points-1))
+		p := gpx.GPXPoint{Timestamp: start.Add(time.Duration(i) * 3 * time.Second)}
+		p.Latitude = benchLat - (1-f)*0.05 + r.NormFloat64()*0.00002
+		p.Longitude = benchLng - (1-f)*0.05 + r.NormFloat64()*0.00002
+		p.Elevation = *gpx.NewNullableFloat64(500 + f*1500 + r.NormFloat64()*3)
+		seg.Points = append(seg.Points, p)
+	}
+	return gpx.GPXTrack{Name: "synthetic", Segments: []gpx.GPXTrackSegment{seg}}
+}
+
+// Peaks scattered within about 5km of the synthetic summit, so each search
+// finds a realistic handful.
+func syntheticPeaks(r *rand.Rand, n int) peakbagger.PeakList {
+	peaks := make(peakbagger.PeakList, n)
+	for i := range peaks {
+		peaks[i] = &peakbagger.Peak{
+			Name:      fmt.Sprintf("Peak %d", i),
+			PeakID:    peakbagger.PeakID(i + 1),
+			Latitude:  benchLat + (r.Float64()-0.5)*0.1,
+			Longitude: benchLng + (r.Float64()-0.5)*0.1,
+		}
+	}
+	return peaks
+}
+
+func BenchmarkParseGPX(b *testing.B) {
+	t := syntheticTrack(rand.New(rand.NewSource(1)), 20000)
+	xml, err := (&gpx.GPX{Tracks: []gpx.GPXTrack{t}}).ToXml(gpx.ToXmlParams{Version: "1.1"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(xml)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gpx.ParseBytes(xml); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReducePoints(b *testing.B) {
+	points := syntheticTrack(rand.New(rand.NewSource(1)), 20000).Segments[0].Points
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reducePoints(points, maxTrackPoints)
+	}
+}
+
+func BenchmarkTrackBounds(b *testing.B) {
+	t := syntheticTrack(rand.New(rand.NewSource(1)), 20000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ToTrackBounds(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGain(b *testing.B) {
+	t := syntheticTrack(rand.New(rand.NewSource(1)), 20000)
+	points := trackPoints(&t)
+	for name, f := range gainAlgorithms {
+		b.Run(name, func(b *testing.B) {
+			// Algorithms needing data that isn't installed, like a DEM.
+			if _, err := f(points); err != nil {
+				b.Skip(err)
+			}
+			for i := 0; i < b.N; i++ {
+				f(points)
+			}
+		})
+	}
+}
+
+func BenchmarkPlausibility(b *testing.B) {
+	t := syntheticTrack(rand.New(rand.NewSource(1)), 20000)
+	points := trackPoints(&t)
+	gain, err := hysteresisGain(points)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CheckPlausibility(points, gain, ComputeAssisted(points, gain))
+	}
+}
+
+func BenchmarkCandidatePeaks(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	u := &Uploader{client: &simulatedClient{peaks: syntheticPeaks(r, 10000)}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := u.CandidatePeaks(benchLat, benchLng); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreferTitledPeak(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	u := &Uploader{client: &simulatedClient{peaks: syntheticPeaks(r, 10000)}}
+	peaks, err := u.CandidatePeaks(benchLat, benchLng)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(peaks) < 2 {
+		b.Fatalf("%d candidate peaks, want at least 2", len(peaks))
+	}
+	// Naming the farthest candidate makes every closer one be checked.
+	title := "Climb of " + peaks[len(peaks)-1].Name
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		preferTitledPeak(peaks, title)
+	}
+}
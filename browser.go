@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	chromePath = flag.String("chrome_path", "", "Chrome or Chromium binary for -driver=browser (default: the first of google-chrome, chromium, chromium-browser or chrome on the PATH)")
+)
+
+const (
+	// Origin of DevTools connections, which Chrome must be told to allow.
+	devtoolsOrigin = "http://127.0.0.1"
+	// How long to wait for Chrome to start or a page to load.
+	browserTimeout = time.Minute
+)
+
+var ascentIDRe = regexp.MustCompile(`(?i)[?&]aid=(\d+)`)
+
+// Finds the Chrome binary to drive.
+func findChrome() (string, error) {
+	if *chromePath != "" {
+		return exec.LookPath(*chromePath)
+	}
+	for _, name := range []string{"google-chrome", "chromium", "chromium-browser", "chrome"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("-driver=browser needs Chrome or Chromium, install it or set -chrome_path")
+}
+
+// A headless Chrome driven over the DevTools protocol, with one page open.
+type chrome struct {
+	cmd *exec.Cmd
+	// Profile directory, removed on close.
+	dir    string
+	ws     *websocket.Conn
+	lastID int
+	// Events received while waiting for command results, by method.
+	fired map[string]bool
+}
+
+type cdpMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params interface{}     `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Starts Chrome with a fresh profile and connects to its first page.
+func startChrome() (*chrome, error) {
+	path, err := findChrome()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	c := &chrome{dir: dir, fired: make(map[string]bool)}
+	c.cmd = exec.Command(path, "--headless=new", "--disable-gpu", "--no-first-run", "--no-default-browser-check",
+		"--remote-debugging-port=0", "--remote-allow-origins="+devtoolsOrigin, "--user-data-dir="+dir, "about:blank")
+	if err := c.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("start %s %w", path, err)
+	}
+	if err := c.connect(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// Inspector reports the page crashing, which would otherwise leave
+	// commands waiting out browserTimeout.
+	for _, domain := range []string{"Page", "Inspector"} {
+		if _, err := c.call(domain+".enable", nil); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Connects to the page Chrome opened, once it writes the port it listens on
+// to the profile.
+func (c *chrome) connect() error {
+	var port string
+	for deadline := time.Now().Add(browserTimeout); port == ""; time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for chrome to start")
+		}
+		f, err := os.Open(filepath.Join(c.dir, "DevToolsActivePort"))
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		if s.Scan() {
+			port = strings.TrimSpace(s.Text())
+		}
+		f.Close()
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s/json/list", port))
+	if err != nil {
+		return fmt.Errorf("list chrome pages %w", err)
+	}
+	defer resp.Body.Close()
+	var targets []struct {
+		Type                 string `json:"type"`
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return fmt.Errorf("parse chrome pages %w", err)
+	}
+	for _, t := range targets {
+		if t.Type == "page" {
+			c.ws, err = websocket.Dial(t.WebSocketDebuggerURL, "", devtoolsOrigin)
+			if err != nil {
+				return fmt.Errorf("connect to chrome %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("chrome has no page open")
+}
+
+// Reads the next message from Chrome, noting events. Fails once the page
+// has crashed or been closed, since no more replies will come.
+func (c *chrome) receive() (*cdpMessage, error) {
+	c.ws.SetReadDeadline(time.Now().Add(browserTimeout))
+	var m cdpMessage
+	if err := websocket.JSON.Receive(c.ws, &m); err != nil {
+		return nil, fmt.Errorf("chrome %w", err)
+	}
+	switch m.Method {
+	case "":
+	case "Inspector.targetCrashed":
+		return nil, fmt.Errorf("chrome page crashed")
+	case "Inspector.detached":
+		return nil, fmt.Errorf("chrome page closed")
+	default:
+		c.fired[m.Method] = true
+	}
+	return &m, nil
+}
+
+// Runs a DevTools command, returning its result.
+func (c *chrome) call(method string, params interface{}) (json.RawMessage, error) {
+	c.lastID++
+	id := c.lastID
+	if err := websocket.JSON.Send(c.ws, cdpMessage{ID: id, Method: method, Params: params}); err != nil {
+		return nil, fmt.Errorf("chrome %s %w", method, err)
+	}
+	for {
+		m, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		if m.ID != id {
+			continue
+		}
+		if m.Error != nil {
+			return nil, fmt.Errorf("chrome %s: %s", method, m.Error.Message)
+		}
+		return m.Result, nil
+	}
+}
+
+// Runs action, which navigates the page, and waits for the new page to
+// load.
+func (c *chrome) load(action func() error) error {
+	delete(c.fired, "Page.loadEventFired")
+	if err := action(); err != nil {
+		return err
+	}
+	for !c.fired["Page.loadEventFired"] {
+		if _, err := c.receive(); err != nil {
+			return fmt.Errorf("wait for page load %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *chrome) navigate(url string) error {
+	return c.load(func() error {
+		_, err := c.call("Page.navigate", map[string]string{"url": url})
+		return err
+	})
+}
+
+// Calls a JavaScript function in the page with JSON encodable arguments,
+// decoding its return value into result if it isn't nil.
+func (c *chrome) run(fn string, result interface{}, args ...interface{}) error {
+	var encoded []string
+	for _, a := range args {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, string(b))
+	}
+	raw, err := c.call("Runtime.evaluate", map[string]interface{}{
+		"expression":    fmt.Sprintf("(%s)(%s)", fn, strings.Join(encoded, ", ")),
+		"returnByValue": true,
+	})
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return err
+	}
+	if r.ExceptionDetails != nil {
+		return fmt.Errorf("page script: %s", r.ExceptionDetails.Text)
+	}
+	if result == nil || r.Result.Value == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result.Value, result)
+}
+
+// Cookies the browser holds for Peakbagger.
+func (c *chrome) cookies() ([]*http.Cookie, error) {
+	raw, err := c.call("Network.getCookies", map[string][]string{"urls": {peakbaggerURL}})
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Cookies []exportedCookie `json:"cookies"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, err
+	}
+	var cookies []*http.Cookie
+	for _, ec := range r.Cookies {
+		RegisterSecret(ec.Value)
+		cookies = append(cookies, &http.Cookie{Name: ec.Name, Value: ec.Value})
+	}
+	return cookies, nil
+}
+
+// Sets the file of the page's first file input.
+func (c *chrome) setFile(filename string) error {
+	raw, err := c.call("DOM.getDocument", nil)
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Root struct {
+			NodeID int `json:"nodeId"`
+		} `json:"root"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	raw, err = c.call("DOM.querySelector", map[string]interface{}{"nodeId": doc.Root.NodeID, "selector": "input[type=file]"})
+	if err != nil {
+		return err
+	}
+	var input struct {
+		NodeID int `json:"nodeId"`
+	}
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return err
+	}
+	if input.NodeID == 0 {
+		return fmt.Errorf("no file upload on the page")
+	}
+	_, err = c.call("DOM.setFileInputFiles", map[string]interface{}{"nodeId": input.NodeID, "files": []string{filename}})
+	return err
+}
+
+func (c *chrome) Close() error {
+	if c.ws != nil {
+		c.ws.Close()
+	}
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	}
+	return os.RemoveAll(c.dir)
+}
+
+// Page scripts for Peakbagger's forms. Fields are found by their labels and
+// names rather than generated IDs, which change when the site is rebuilt.
+const (
+	// Fills and submits the login form, the one with a password field.
+	loginScript = `function(username, password) {
+		const pw = document.querySelector('input[type=password]');
+		if (!pw || !pw.form) throw new Error('no login form');
+		const user = Array.from(pw.form.querySelectorAll('input[type=text], input[type=email], input:not([type])'))[0];
+		if (!user) throw new Error('no username field');
+		user.value = username;
+		pw.value = password;
+		const submit = pw.form.querySelector('[type=submit]');
+		if (submit) submit.click(); else pw.form.submit();
+	}`
+
+	// Whether the page still asks for a password.
+	loginFormScript = `function() { return !!document.querySelector('input[type=password]'); }`
+
+	// Fills the ascent form's fields by label, returning the labels of
+	// fields the form doesn't have.
+	ascentFieldsScript = `function(values) {
+		const norm = s => s.replace(/[^a-z]/gi, '').toLowerCase();
+		const find = label => {
+			for (const l of document.querySelectorAll('label')) {
+				if (norm(l.textContent).startsWith(norm(label))) {
+					const f = l.htmlFor ? document.getElementById(l.htmlFor) : l.querySelector('input, textarea, select');
+					if (f) return f;
+				}
+			}
+			return Array.from(document.querySelectorAll('input, textarea, select')).find(f => norm(f.name).endsWith(norm(label)));
+		};
+		const missing = [];
+		for (const [label, value] of Object.entries(values)) {
+			const f = find(label);
+			if (!f) { missing.push(label); continue; }
+			f.value = value;
+			f.dispatchEvent(new Event('change', {bubbles: true}));
+		}
+		return missing;
+	}`
+
+	// Submits the ascent form with its save button.
+	ascentSubmitScript = `function() {
+		const buttons = Array.from(document.querySelectorAll('[type=submit]'));
+		const save = buttons.find(b => /save|add|submit/i.test(b.value || b.textContent)) || buttons[0];
+		if (!save) throw new Error('no save button');
+		save.click();
+	}`
+
+	// Validation messages shown on the page.
+	pageErrorsScript = `function() {
+		return Array.from(document.querySelectorAll('.error, .validation, [style*="color:Red"], [style*="color: red"]'))
+			.map(e => e.textContent.trim()).filter(t => t).join('; ');
+	}`
+)
+
+// Drives Peakbagger's pages in a headless Chrome, for when the scraping
+// client can't submit its JavaScript forms. Logging in and adding ascents
+// go through the browser; lookups use the scraping client with the
+// browser's session.
+type browserClient struct {
+	*peakbagger.PeakBagger
+	chrome *chrome
+}
+
+func newBrowserClient() (Client, error) {
+	c, err := startChrome()
+	if err != nil {
+		return nil, err
+	}
+	b := &browserClient{chrome: c}
+	if err := b.login(*usernamePB, peakbaggerPassword()); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("peakbagger browser login %w", err)
+	}
+	cookies, err := c.cookies()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	InstallCookies(cookies)
+
+	b.PeakBagger = peakbagger.NewClient(*usernamePB, peakbaggerPassword())
+	if climberID, err := b.PeakBagger.Login(); err != nil {
+		log.Warnf("Peakbagger login failed, relying on the browser session: %v", err)
+	} else {
+		log.Infof("Logged in as %v", climberID)
+	}
+	return b, nil
+}
+
+func (b *browserClient) login(username, password string) error {
+	if err := b.chrome.navigate(peakbaggerURL + "/Climber/Login.aspx"); err != nil {
+		return err
+	}
+	err := b.chrome.load(func() error {
+		return b.chrome.run(loginScript, nil, username, password)
+	})
+	if err != nil {
+		return err
+	}
+	var stillAsked bool
+	if err := b.chrome.run(loginFormScript, &stillAsked); err != nil {
+		return err
+	}
+	if stillAsked {
+		return fmt.Errorf("still on the login page, check -username and -password")
+	}
+	return nil
+}
+
+// Adds the ascent with the site's ascent form, returning the ID of the
+// ascent page it saves to.
+func (b *browserClient) AddAscent(a peakbagger.Ascent) (peakbagger.AscentID, error) {
+	c := b.chrome
+	if err := c.navigate(fmt.Sprintf("%s/climber/ascentedit.aspx?pid=%d", peakbaggerURL, a.PeakID)); err != nil {
+		return 0, err
+	}
+
+	values := map[string]string{"Trip Report": a.TripReport}
+	if a.Date != nil {
+		values["Date"] = a.Date.Format("2006-01-02")
+	}
+	if a.TimeUp > 0 {
+		values["Time Up"] = formatAscentDuration(a.TimeUp)
+	}
+	if a.TimeDown > 0 {
+		values["Time Down"] = formatAscentDuration(a.TimeDown)
+	}
+	if a.StartElevation != 0 {
+		values["Start Elevation"] = fmt.Sprintf("%.0f", a.StartElevation)
+	}
+	if a.EndElevation != 0 {
+		values["End Elevation"] = fmt.Sprintf("%.0f", a.EndElevation)
+	}
+	var missing []string
+	if err := c.run(ascentFieldsScript, &missing, values); err != nil {
+		return 0, err
+	}
+	if len(missing) > 0 {
+		log.Warnf("Ascent form has no %s field, leaving it out", strings.Join(missing, ", "))
+	}
+
+	if a.Gpx != nil {
+		filename, err := writeTempGPX(a.Gpx, "ascent.gpx")
+		if err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(filepath.Dir(filename))
+		if err := c.setFile(filename); err != nil {
+			return 0, fmt.Errorf("attach gpx %w", err)
+		}
+	}
+
+	if err := c.load(func() error { return c.run(ascentSubmitScript, nil) }); err != nil {
+		return 0, err
+	}
+	var location string
+	if err := c.run(`function() { return location.href; }`, &location); err != nil {
+		return 0, err
+	}
+	m := ascentIDRe.FindStringSubmatch(location)
+	if m == nil {
+		var problems string
+		c.run(pageErrorsScript, &problems)
+		return 0, fmt.Errorf("ascent form wasn't saved: %s", problems)
+	}
+	var id peakbagger.AscentID
+	fmt.Sscan(m[1], &id)
+	return id, nil
+}
+
+func (b *browserClient) Close() error {
+	return b.chrome.Close()
+}
+
+// Formats a duration as the form's hours and minutes, e.g. 3:05.
+func formatAscentDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// Connects to a fake DevTools page that answers each command with the
+// messages reply returns for its ID, in order.
+func fakeChrome(t *testing.T, reply func(method string, id int) []string) *chrome {
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var m cdpMessage
+			if err := websocket.JSON.Receive(ws, &m); err != nil {
+				return
+			}
+			for _, msg := range reply(m.Method, m.ID) {
+				if err := websocket.Message.Send(ws, msg); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", devtoolsOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &chrome{ws: ws, fired: make(map[string]bool)}
+}
+
+func TestChromeCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   func(id int) []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "result",
+			reply: func(id int) []string { return []string{fmt.Sprintf(`{"id":%d,"result":{"value":1}}`, id)} },
+			want:  `{"value":1}`,
+		},
+		{
+			name: "after events and other replies",
+			reply: func(id int) []string {
+				return []string{
+					`{"method":"Page.frameNavigated","params":{}}`,
+					fmt.Sprintf(`{"id":%d,"result":{"value":"stale"}}`, id+100),
+					fmt.Sprintf(`{"id":%d,"result":{"value":2}}`, id),
+				}
+			},
+			want: `{"value":2}`,
+		},
+		{
+			name: "protocol error",
+			reply: func(id int) []string {
+				return []string{fmt.Sprintf(`{"id":%d,"error":{"code":-32601,"message":"'Test.run' wasn't found"}}`, id)}
+			},
+			wantErr: "chrome Test.run: 'Test.run' wasn't found",
+		},
+		{
+			name:    "page crashed",
+			reply:   func(id int) []string { return []string{`{"method":"Inspector.targetCrashed","params":{}}`} },
+			wantErr: "chrome page crashed",
+		},
+		{
+			name: "page closed",
+			reply: func(id int) []string {
+				return []string{`{"method":"Inspector.detached","params":{"reason":"target_closed"}}`}
+			},
+			wantErr: "chrome page closed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fakeChrome(t, func(method string, id int) []string { return tt.reply(id) })
+			got, err := c.call("Test.run", nil)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("call = %s, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("call = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChromeCallIDs(t *testing.T) {
+	var ids []int
+	c := fakeChrome(t, func(method string, id int) []string {
+		ids = append(ids, id)
+		return []string{fmt.Sprintf(`{"id":%d,"result":{}}`, id)}
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := c.call("Test.run", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ids) != 3 || ids[0] == ids[1] || ids[1] == ids[2] || ids[0] == ids[2] {
+		t.Errorf("command IDs %v, want 3 distinct", ids)
+	}
+}
+
+func TestChromeReceiveClosed(t *testing.T) {
+	c := fakeChrome(t, func(method string, id int) []string { return nil })
+	c.ws.Close()
+	if m, err := c.receive(); err == nil {
+		t.Errorf("receive on a closed connection = %+v, want an error", m)
+	}
+}
+
+func TestChromeLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(id int) []string
+	}{
+		{
+			// Fast pages can load before Chrome replies to Page.navigate.
+			name: "load fired before the reply",
+			reply: func(id int) []string {
+				return []string{
+					`{"method":"Page.loadEventFired","params":{}}`,
+					fmt.Sprintf(`{"id":%d,"result":{}}`, id),
+				}
+			},
+		},
+		{
+			name: "load fired after the reply",
+			reply: func(id int) []string {
+				return []string{
+					fmt.Sprintf(`{"id":%d,"result":{}}`, id),
+					`{"method":"Page.domContentEventFired","params":{}}`,
+					`{"method":"Page.loadEventFired","params":{}}`,
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fakeChrome(t, func(method string, id int) []string {
+				if method != "Page.navigate" {
+					t.Errorf("method %q, want Page.navigate", method)
+				}
+				return tt.reply(id)
+			})
+			if err := c.navigate("https://www.peakbagger.com/"); err != nil {
+				t.Fatal(err)
+			}
+			if !c.fired["Page.loadEventFired"] {
+				t.Error("navigate returned before the page loaded")
+			}
+		})
+	}
+}
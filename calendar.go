@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Writes an iCalendar file of the ascents in the manifest of -directory, as
+// all-day events, to the file named in args or stdout.
+func RunCalendarCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: calendar [file.ics]")
+	}
+	m, err := LoadManifest(*inputDirectory)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "-" {
+		return WriteCalendar(os.Stdout, m)
+	}
+	f, err := os.Create(longPath(args[0]))
+	if err != nil {
+		return err
+	}
+	if err := WriteCalendar(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the manifest's ascents as an iCalendar (RFC 5545) file, oldest
+// first. Events have stable UIDs so re-imports update rather than duplicate.
+func WriteCalendar(w io.Writer, m *Manifest) error {
+	ascents := append([]*ManifestEntry(nil), m.Ascents...)
+	sort.SliceStable(ascents, func(i, j int) bool {
+		return ascents[i].Date.Before(ascents[j].Date)
+	})
+
+	bw := bufio.NewWriter(w)
+	line := func(s string) {
+		writeICSLine(bw, s)
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//" + programName + "//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:Peakbagger ascents")
+	for _, a := range ascents {
+		day := a.Date.Local()
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		name := a.PeakName
+		if name == "" {
+			name = "Peak " + a.PeakID
+		}
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:ascent-%d@%s", a.AscentID, programName))
+		line("DTSTAMP:" + a.Added.UTC().Format("20060102T150405Z"))
+		line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + start.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icsEscape(name))
+		line("DESCRIPTION:" + icsEscape(calendarDescription(a)))
+		line("URL:" + ascentURL(a.AscentID))
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+func calendarDescription(a *ManifestEntry) string {
+	var lines []string
+	if stats := a.Stats(); stats != "" {
+		lines = append(lines, stats)
+	}
+	lines = append(lines,
+		"Ascent: "+ascentURL(a.AscentID),
+		fmt.Sprintf("Peak: %s/peak.aspx?pid=%s", peakbaggerURL, a.PeakID),
+		"Track: "+a.File)
+	return strings.Join(lines, "\n")
+}
+
+// Escapes a TEXT property value.
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// Writes a content line folded at 75 octets, without splitting UTF-8
+// sequences.
+func writeICSLine(w *bufio.Writer, s string) {
+	const limit = 75
+	for first := true; ; first = false {
+		n := limit
+		if !first {
+			// Continuation lines start with a space.
+			n--
+		}
+		if len(s) <= n {
+			if !first {
+				w.WriteString(" ")
+			}
+			w.WriteString(s + "\r\n")
+			return
+		}
+		for n > 0 && s[n]&0xc0 == 0x80 {
+			n--
+		}
+		if !first {
+			w.WriteString(" ")
+		}
+		w.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	caltopoMap = flag.String("caltopo_map", "", "Shared CalTopo map to upload recorded tracks from with -source=caltopo, its URL such as https://caltopo.com/m/ABC12 or just its ID")
+)
+
+var caltopoURL = "https://caltopo.com"
+
+// Syncs recorded tracks from a shared CalTopo map, see -source. Only lines
+// with timestamps, as recorded by the CalTopo app or imported from a GPS,
+// are uploaded; lines drawn while planning aren't.
+type caltopoSource struct {
+	// Base URL of the site serving the map, e.g. sartopo.com.
+	base string
+	id   string
+	// Tracks by feature ID, filled by Activities.
+	tracks map[string]*gpx.GPXTrack
+}
+
+func newCaltopoSource() (ActivitySource, error) {
+	if *caltopoMap == "" {
+		return nil, fmt.Errorf("-source=caltopo requires -caltopo_map")
+	}
+	base, id, err := parseCaltopoMap(*caltopoMap)
+	if err != nil {
+		return nil, err
+	}
+	return &caltopoSource{base: base, id: id}, nil
+}
+
+// Splits a shared map URL into its site and map ID. A bare ID is on
+// caltopo.com.
+func parseCaltopoMap(s string) (string, string, error) {
+	if !strings.Contains(s, "/") {
+		return caltopoURL, s, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("-caltopo_map %q is not a map URL or ID", s)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "m" || parts[1] == "" {
+		return "", "", fmt.Errorf("-caltopo_map %q is not a shared map URL like %s/m/<id>", s, caltopoURL)
+	}
+	return u.Scheme + "://" + u.Host, parts[1], nil
+}
+
+func (c *caltopoSource) Activities(limit int) ([]*RemoteActivity, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/map/%s/since/0", c.base, url.PathEscape(c.id)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doAPIRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m struct {
+		Result struct {
+			State struct {
+				Features []geoJSON `json:"features"`
+			} `json:"state"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse map %s %w", c.id, err)
+	}
+	c.tracks = make(map[string]*gpx.GPXTrack)
+	var activities []*RemoteActivity
+	for _, f := range m.Result.State.Features {
+		t, err := geoJSONTrack(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse line %q of map %s %w", f.Properties.Title, c.id, err)
+		}
+		if t == nil {
+			continue
+		}
+		c.tracks[f.ID] = t
+		activities = append(activities, &RemoteActivity{
+			Source: "caltopo",
+			ID:     c.id + "/" + f.ID,
+			Name:   t.Name,
+			Type:   f.Properties.Class,
+			Start:  t.Segments[0].Points[0].Timestamp,
+			URL:    c.base + "/m/" + c.id,
+		})
+	}
+	// A map's objects aren't in time order, keep the newest.
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].Start.After(activities[j].Start)
+	})
+	if len(activities) > limit {
+		activities = activities[:limit]
+	}
+	return activities, nil
+}
+
+// Every recorded track is selected, lines without timestamps are already
+// left out.
+func (c *caltopoSource) Selected(a *RemoteActivity) bool {
+	return true
+}
+
+// Writes the recorded line as a GPX track of its timestamped positions, as
+// read by ReadGeoJSON.
+func (c *caltopoSource) Download(a *RemoteActivity, dir string) (string, error) {
+	featureID := strings.TrimPrefix(a.ID, c.id+"/")
+	t, ok := c.tracks[featureID]
+	if !ok {
+		return "", fmt.Errorf("no track %s in map %s", featureID, c.id)
+	}
+	g := &gpx.GPX{Creator: "CalTopo", Tracks: []gpx.GPXTrack{*t}}
+	b, err := g.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, featureID+".gpx")
+	if err := os.WriteFile(longPath(filename), b, 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+	"peakbagger-tools/pbtools/track"
+)
+
+var (
+	driver = flag.String("driver", "http", "Peakbagger client driver: http scrapes the site, browser submits its forms in a headless Chrome for when scraping them breaks")
+)
+
+// Operations the uploader needs from Peakbagger. Drivers implement this so a
+// fallback can be selected when one way of talking to the site breaks.
+type Client interface {
+	FindPeaks(b *track.Bounds) (peakbagger.PeakList, error)
+	ListAscents() (peakbagger.AscentList, error)
+	AddAscent(a peakbagger.Ascent) (peakbagger.AscentID, error)
+}
+
+// An optional driver capability, an interface drivers may implement besides
+// Client.
+type clientCapability struct {
+	// What the capability lets the tool do.
+	does      string
+	supported func(c Client) bool
+}
+
+var (
+	canUpdateAscents = &clientCapability{"update existing ascents", func(c Client) bool {
+		_, ok := c.(AscentUpdater)
+		return ok
+	}}
+	canLogAttempts = &clientCapability{"log unsuccessful ascents", func(c Client) bool {
+		_, ok := c.(AttemptLogger)
+		return ok
+	}}
+	canHideAscents = &clientCapability{"make ascents private", func(c Client) bool {
+		_, ok := c.(AscentVisibility)
+		return ok
+	}}
+)
+
+// Fails unless the client has the capability, which what needs.
+func (cc *clientCapability) check(c Client, what string) error {
+	if cc.supported(c) {
+		return nil
+	}
+	return fmt.Errorf("%s needs a driver that can %s, -driver %q can't", what, cc.does, *driver)
+}
+
+// Checks that the client supports flags changing how ascents are added.
+// Their effect can't be undone once an ascent is published, so the ascent
+// isn't added without them.
+func checkAscentFlags(c Client) error {
+	if *privateAscents {
+		if err := canHideAscents.check(c, "-private_ascents"); err != nil {
+			return err
+		}
+	}
+	if *deferGPX {
+		// Otherwise the deferred track could never be attached.
+		if err := canUpdateAscents.check(c, "-defer_gpx"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Constructors for each -driver, returning a logged in client.
+var clientDrivers = map[string]func() (Client, error){
+	"http":    newHTTPClient,
+	"browser": newBrowserClient,
+}
+
+func NewClient() (Client, error) {
+	if *offlineSimulate || *offlineQueue {
+		return newSimulatedClient()
+	}
+	newClient, ok := clientDrivers[*driver]
+	if !ok {
+		var names []string
+		for name := range clientDrivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown driver %q, available drivers: %s", *driver, strings.Join(names, ", "))
+	}
+	return newClient()
+}
+
+// The HTTP scraping client.
+func newHTTPClient() (Client, error) {
+	if *cookiesFile != "" {
+		cookies, err := LoadCookies(*cookiesFile)
+		if err != nil {
+			return nil, fmt.Errorf("load cookies %w", err)
+		}
+		InstallCookies(cookies)
+	}
+
+	pb := peakbagger.NewClient(*usernamePB, peakbaggerPassword())
+	climberID, err := pb.Login()
+	if err != nil && *cookiesFile == "" {
+		return nil, fmt.Errorf("peakbagger login %w", err)
+	}
+	if err != nil {
+		log.Warnf("Peakbagger login failed, relying on imported browser cookies: %v", err)
+	} else {
+		log.Infof("Logged in as %v", climberID)
+	}
+	return pb, nil
+}
+
+func peakbaggerPassword() string {
+	if *passwordPB != "" {
+		return *passwordPB
+	}
+	return os.Getenv(passwordEnv)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const programName = "peakbagger-bulk-uploader"
+
+// When during startup a command runs.
+type commandStage int
+
+const (
+	// Before optional inputs like regions and date filters are loaded.
+	stageSetup commandStage = iota
+	// Without logging in to Peakbagger.
+	stageOffline
+	// With a logged in Uploader.
+	stageOnline
+)
+
+// A subcommand: program [flags] <command> [args].
+type command struct {
+	name    string
+	args    string
+	summary string
+	stage   commandStage
+	// Set for commands that parse their own arguments. Others also accept
+	// global flags after the command name.
+	ownArgs bool
+	// Driver capability an online command needs, refused before it runs
+	// on drivers without it.
+	requires *clientCapability
+
+	run       func(args []string) error
+	runOnline func(u *Uploader, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "upload", summary: "Upload ascents for new tracks in -directory or -filename (the default)", stage: stageOnline,
+			runOnline: func(u *Uploader, args []string) error {
+				if *daemon {
+					return u.RunDaemon()
+				}
+				err := u.Run()
+				u.NotifyResults(0, 0)
+				return err
+			}},
+		{name: "daemon", summary: "Keep uploading new tracks, on -schedule or every -daemon_interval", stage: stageOnline,
+			runOnline: func(u *Uploader, args []string) error { return u.RunDaemon() }},
+		{name: "analyze", summary: "Print movement stats for tracks without matching or uploading", stage: stageOffline,
+			run: func(args []string) error { return Analyze() }},
+		{name: "history", summary: "List processed files and their outcomes from the history file", stage: stageOffline,
+			run: func(args []string) error { return PrintHistory() }},
+		{name: "recap", args: "[-year YYYY] [-o file]", summary: "Write a shareable HTML summary of a year of ascents added from -directory", stage: stageOffline, ownArgs: true, run: RunRecapCommand},
+		{name: "calendar", args: "[file.ics]", summary: "Write an iCalendar file of ascents added from -directory", stage: stageOffline, ownArgs: true, run: RunCalendarCommand},
+		{name: "attempts", args: "[list | reason N text]", summary: "List attempts recorded with -attempts, or set why one turned around", stage: stageOffline, ownArgs: true, run: RunAttemptsCommand},
+		{name: "log-attempt", args: "N", summary: "Log a recorded attempt as an unsuccessful ascent", stage: stageOnline, ownArgs: true, requires: canLogAttempts,
+			runOnline: func(u *Uploader, args []string) error { return u.LogAttempt(args) }},
+		{name: "audit", summary: "Compare tracks against logged ascents", stage: stageOnline,
+			runOnline: func(u *Uploader, args []string) error { return u.Audit() }},
+		{name: "attach", summary: "Attach tracks to logged ascents that have none", stage: stageOnline, requires: canUpdateAscents,
+			runOnline: func(u *Uploader, args []string) error { return u.Attach() }},
+		{name: "attach-deferred", summary: "Attach tracks held back by -defer_gpx to their ascents", stage: stageOnline, requires: canUpdateAscents,
+			runOnline: func(u *Uploader, args []string) error { return u.AttachDeferred() }},
+		{name: "sync", summary: "Upload ascents queued by -offline", stage: stageOnline,
+			runOnline: func(u *Uploader, args []string) error { return u.Sync() }},
+		{name: "retrofit-reports", summary: "Re-render trip reports of ascents added by this tool", stage: stageOnline, requires: canUpdateAscents,
+			runOnline: func(u *Uploader, args []string) error { return u.RetrofitReports() }},
+		{name: "find-peak", args: "-peak_id id | -search name [-state ST] | -name name", summary: "Look up a peak's location", stage: stageOnline, ownArgs: true,
+			runOnline: func(u *Uploader, args []string) error { return u.FindPeak(args) }},
+		{name: "index", args: "[search ...]", summary: "Update or search the archive index", stage: stageOffline, ownArgs: true,
+			run: func(args []string) error {
+				if len(args) > 0 && args[0] == "search" {
+					return SearchIndex(args[1:])
+				}
+				return UpdateIndex(*inputDirectory)
+			}},
+		{name: "users", args: "list | add | remove", summary: "Manage users for serve", stage: stageOffline, ownArgs: true, run: RunUsersCommand},
+		{name: "serve", summary: "Scan every user's directory on their schedule", stage: stageOffline,
+			run: func(args []string) error { return Serve() }},
+		{name: "auth", args: "<provider>", summary: "Authorize a cloud source", stage: stageOffline, ownArgs: true, run: RunAuthCommand},
+		{name: "cache", args: "[list | clear [kind]]", summary: "Inspect or clear the tile cache", stage: stageOffline, ownArgs: true, run: RunCacheCommand},
+		{name: "config", args: "check", summary: "Validate the config file, templates and credentials", stage: stageSetup, ownArgs: true, run: RunConfigCommand},
+		{name: "init", summary: "Set up a config file interactively", stage: stageSetup,
+			run: func(args []string) error { return RunInit() }},
+		{name: "completion", args: "bash | zsh | fish", summary: "Print a shell completion script", stage: stageSetup, ownArgs: true, run: RunCompletion},
+		{name: "help", summary: "Show usage", stage: stageSetup, ownArgs: true,
+			run: func(args []string) error {
+				flag.CommandLine.SetOutput(os.Stdout)
+				flag.Usage()
+				return nil
+			}},
+	}
+	flag.Usage = usage
+}
+
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: %s [flags] [command] [args]\n\nCommands:\n", programName)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		summary := c.summary
+		if c.requires != nil {
+			summary += " (needs a driver that can " + c.requires.does + ")"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(c.name+" "+c.args), summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// Picks the command from the arguments left after flag.Parse, defaulting to
+// upload, and parses any global flags following its name. Returns the
+// command's own arguments.
+func parseCommand() (*command, []string, error) {
+	if flag.NArg() == 0 {
+		return lookupCommand("upload"), nil, nil
+	}
+	c := lookupCommand(flag.Arg(0))
+	if c == nil {
+		return nil, nil, fmt.Errorf("unknown command %q, see %s help", flag.Arg(0), programName)
+	}
+	if c.ownArgs {
+		return c, flag.Args()[1:], nil
+	}
+	if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+		return nil, nil, err
+	}
+	if flag.NArg() > 0 {
+		return nil, nil, fmt.Errorf("%s takes no arguments, got %q", c.name, flag.Args())
+	}
+	return c, nil, nil
+}
+
+// Lists the history file of the input directory.
+func PrintHistory() error {
+	u := &Uploader{dir: *inputDirectory}
+	if err := u.LoadHistory(); err != nil {
+		return err
+	}
+	var files []string
+	for f := range u.FilenameHistory {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return u.FilenameHistory[files[i]].Added.Before(u.FilenameHistory[files[j]].Added)
+	})
+	for _, f := range files {
+		h := u.FilenameHistory[f]
+		status := "ok"
+		if h.Error != "" {
+			status = h.Category
+			if status == "" {
+				status = "error"
+			}
+			status += ": " + h.Error
+		}
+		fmt.Printf("%s  %s  %s\n", h.Added.Local().Format(time.RFC3339), f, status)
+	}
+	return nil
+}
+
+// Prints a completion script for subcommands and flags.
+func RunCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: completion bash | zsh | fish")
+	}
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	var flags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	fn := "_" + strings.ReplaceAll(programName, "-", "_")
+
+	switch args[0] {
+	case "bash":
+		var fs []string
+		for _, f := range flags {
+			fs = append(fs, "-"+f.Name)
+		}
+		fmt.Printf(`%s() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	fi
+}
+complete -o default -F %s %s
+`, fn, strings.Join(fs, " "), strings.Join(names, " "), fn, programName)
+	case "zsh":
+		escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace
+		fmt.Printf("#compdef %s\n\n%s() {\n\tlocal -a commands\n\tcommands=(\n", programName, fn)
+		for _, c := range commands {
+			fmt.Printf("\t\t'%s:%s'\n", c.name, escape(c.summary))
+		}
+		fmt.Printf("\t)\n\t_arguments \\\n")
+		for _, f := range flags {
+			fmt.Printf("\t\t'-%s[%s]' \\\n", f.Name, escape(firstSentence(f.Usage)))
+		}
+		fmt.Printf("\t\t'1:command:->command' \\\n\t\t'*:file:_files'\n")
+		fmt.Printf("\tif [[ $state == command ]]; then\n\t\t_describe command commands\n\tfi\n}\n\n%s \"$@\"\n", fn)
+	case "fish":
+		quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+		for _, c := range commands {
+			fmt.Printf("complete -c %s -n __fish_use_subcommand -f -a %s -d '%s'\n", programName, c.name, quote(c.summary))
+		}
+		for _, f := range flags {
+			fmt.Printf("complete -c %s -o %s -d '%s'\n", programName, f.Name, quote(firstSentence(f.Usage)))
+		}
+	default:
+		return fmt.Errorf("unknown shell %q, want bash, zsh or fish", args[0])
+	}
+	return nil
+}
+
+func firstSentence(s string) string {
+	if i := strings.Index(s, ". "); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	prNote = flag.Bool("pr_note", false, "Add a note to the trip report when an ascent beats the previous best up-time for the peak")
+)
+
+// Compares an ascent against previous ascents of the same peak.
+type Comparison struct {
+	PreviousAscents int
+
+	// Best previous up-time and the date it was set. Zero if no previous
+	// ascent recorded an up-time.
+	BestTimeUp time.Duration
+	BestDate   time.Time
+
+	// Difference from the previous best, negative when faster.
+	Delta          time.Duration
+	PersonalRecord bool
+}
+
+func (c *Comparison) String() string {
+	if c.BestTimeUp == 0 {
+		return fmt.Sprintf("%d previous ascents, none with an up-time", c.PreviousAscents)
+	}
+	verb, delta := "slower than", c.Delta
+	if delta < 0 {
+		verb, delta = "faster than", -delta
+	}
+	return fmt.Sprintf("%v %s previous best of %v on %s (%d previous ascents)",
+		delta, verb, c.BestTimeUp, c.BestDate.Format("2006-01-02"), c.PreviousAscents)
+}
+
+// Compares the up-time of a new ascent against previous ascents of the peak.
+// Returns nil if this is the first ascent of the peak.
+func CompareAscents(ascents peakbagger.AscentList, peakID peakbagger.PeakID, date time.Time, timeUp time.Duration) *Comparison {
+	c := &Comparison{}
+	for _, a := range ascents {
+		if a.PeakID != peakID || a.Date == nil || a.Date.Equal(date) {
+			continue
+		}
+		c.PreviousAscents++
+		if a.TimeUp > 0 && (c.BestTimeUp == 0 || a.TimeUp < c.BestTimeUp) {
+			c.BestTimeUp = a.TimeUp
+			c.BestDate = *a.Date
+		}
+	}
+	if c.PreviousAscents == 0 {
+		return nil
+	}
+	if c.BestTimeUp > 0 && timeUp > 0 {
+		c.Delta = timeUp - c.BestTimeUp
+		c.PersonalRecord = c.Delta < 0
+	}
+	return c
+}
+
+// Returns the cached ascent list, loading it on first use.
+func (u *Uploader) Ascents() (peakbagger.AscentList, error) {
+	if u.ascents != nil {
+		return u.ascents, nil
+	}
+	ascents, err := u.client.ListAscents()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded %d ascents", len(ascents))
+	u.ascents = ascents
+	return ascents, nil
+}
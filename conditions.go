@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	conditionsInTripReport = flag.Bool("trip_report_conditions", false, "Include inferred snow conditions in trip reports, marked as inferred")
+)
+
+// Margin around the modeled snow line in meters within which conditions are
+// reported as patchy rather than snow or no snow.
+const snowLineMargin = 300
+
+// Likely conditions on an ascent. Inferred from season, latitude and
+// elevation unless measured data is available.
+type Conditions struct {
+	// Modeled seasonal snow line in meters.
+	SnowLine float64
+	// Short description, e.g. "snow likely above 6,200 ft".
+	Summary string
+	// Always true for now, the summary is a model rather than an observation.
+	Inferred bool
+
+	// Nearest station snow depth, only set with -snotel.
+	Snotel *SnotelObservation
+}
+
+func (c *Conditions) String() string {
+	var parts []string
+	if c.Summary != "" {
+		s := c.Summary
+		if c.Inferred {
+			s += " (inferred from season and elevation)"
+		}
+		parts = append(parts, s)
+	}
+	if c.Snotel != nil {
+		parts = append(parts, c.Snotel.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Models the seasonal snow line: highest in late summer and lowest in late
+// winter, and lower towards the poles. Crude, but right often enough to be
+// useful context for old trips.
+func seasonalSnowLine(lat float64, date time.Time) float64 {
+	absLat := math.Abs(lat)
+	summer := math.Max(300, math.Min(5500, 5500-130*(absLat-25)))
+	winter := math.Max(0, summer-2000)
+
+	// Lowest around February 15th in the northern hemisphere.
+	day := float64(date.YearDay() - 46)
+	if lat < 0 {
+		day -= 182
+	}
+	f := (1 - math.Cos(2*math.Pi*day/365)) / 2
+	return winter + (summer-winter)*f
+}
+
+// Infers snow conditions for a climb from start to summit elevation.
+func InferConditions(lat float64, date time.Time, startElevation, summitElevation float64) *Conditions {
+	line := seasonalSnowLine(lat, date)
+	c := &Conditions{SnowLine: line, Inferred: true}
+	ft := func(m float64) string {
+		return fmt.Sprintf("%.0f ft", math.Round(m/metersPerFoot/100)*100)
+	}
+	switch {
+	case startElevation > line+snowLineMargin:
+		c.Summary = "snow likely from the start"
+	case summitElevation > line+snowLineMargin:
+		c.Summary = "snow likely above " + ft(line)
+	case summitElevation > line-snowLineMargin:
+		c.Summary = "patchy snow possible near the summit"
+	default:
+		c.Summary = "likely snow free"
+	}
+	return c
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	configFile  = flag.String("config", "", "JSON config file of flag values, keyed by flag name (default config.json in the user config directory, if present). Command line flags take precedence")
+	profileName = flag.String("profile", "", `Named set of flag values from the "profiles" object of the config file, overriding its top level values`)
+)
+
+// Config keys that aren't flags.
+const profilesKey = "profiles"
+
+func configPath() string {
+	if *configFile != "" {
+		return *configFile
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	p := filepath.Join(dir, "peakbagger-bulk-uploader", "config.json")
+	if _, err := os.Stat(longPath(p)); err != nil {
+		return ""
+	}
+	return p
+}
+
+// Reads the config file as raw values keyed by flag name, or nil without one.
+func readConfig() (map[string]json.RawMessage, string, error) {
+	p := configPath()
+	if p == "" {
+		return nil, "", nil
+	}
+	b, err := os.ReadFile(longPath(p))
+	if err != nil {
+		return nil, p, err
+	}
+	var values map[string]json.RawMessage
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&values); err != nil {
+		return nil, p, fmt.Errorf("parse %s %w", p, err)
+	}
+	return values, p, nil
+}
+
+// Converts a config value to flag.Set arguments, checking it has the JSON
+// type the flag expects: booleans, numbers, and strings for everything else
+// including durations. Repeatable flags also accept a list of strings.
+func configFlagValues(f *flag.Flag, raw json.RawMessage) ([]string, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var want string
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		want = "string or list of strings"
+		if list, ok := v.([]interface{}); ok {
+			var values []string
+			for _, e := range list {
+				s, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("want %s", want)
+				}
+				values = append(values, s)
+			}
+			return values, nil
+		}
+	} else {
+		switch getter.Get().(type) {
+		case bool:
+			if b, ok := v.(bool); ok {
+				return []string{fmt.Sprint(b)}, nil
+			}
+			return nil, fmt.Errorf("want true or false")
+		case int, int64, uint, uint64, float64:
+			if n, ok := v.(json.Number); ok {
+				return []string{n.String()}, nil
+			}
+			return nil, fmt.Errorf("want a number")
+		case time.Duration:
+			want = `duration string such as "90s" or "4h"`
+		default:
+			want = "string"
+		}
+	}
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+	return nil, fmt.Errorf("want %s", want)
+}
+
+// Splits the named profiles out of the config values.
+func configProfiles(values map[string]json.RawMessage) (map[string]map[string]json.RawMessage, error) {
+	profiles := make(map[string]map[string]json.RawMessage)
+	raw, ok := values[profilesKey]
+	if !ok {
+		return profiles, nil
+	}
+	delete(values, profilesKey)
+	if err := json.Unmarshal(raw, &profiles); err != nil {
+		return nil, fmt.Errorf("%q must map profile names to objects of flag values: %w", profilesKey, err)
+	}
+	return profiles, nil
+}
+
+func copyConfig(values map[string]json.RawMessage) map[string]json.RawMessage {
+	c := make(map[string]json.RawMessage, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
+
+// Checks keys and value types of a profile that isn't selected.
+func checkProfile(name string, values map[string]json.RawMessage) []error {
+	var errs []error
+	for k, v := range values {
+		f := flag.Lookup(k)
+		if f == nil || k == "config" || k == "profile" {
+			errs = append(errs, fmt.Errorf("profile %q: unknown key %q", name, k))
+			continue
+		}
+		if _, err := configFlagValues(f, v); err != nil {
+			errs = append(errs, fmt.Errorf("profile %q: key %q: %w", name, k, err))
+		}
+	}
+	return errs
+}
+
+// Applies config values, overlaid with the selected profile's, to flags not
+// set on the command line. All problems are returned rather than stopping at
+// the first.
+func applyConfig(values map[string]json.RawMessage) []error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	profiles, err := configProfiles(values)
+	if err != nil {
+		return []error{err}
+	}
+	if raw, ok := values["profile"]; ok && !explicit["profile"] {
+		if err := json.Unmarshal(raw, profileName); err != nil {
+			return []error{fmt.Errorf("key \"profile\": want string")}
+		}
+	}
+	name := *profileName
+	var errs []error
+	if name != "" {
+		profile, ok := profiles[name]
+		if !ok {
+			var available []string
+			for n := range profiles {
+				available = append(available, n)
+			}
+			sort.Strings(available)
+			return []error{fmt.Errorf("no profile %q, available profiles: %s", name, strings.Join(available, ", "))}
+		}
+		merged := copyConfig(values)
+		for k, v := range profile {
+			if k == "profile" {
+				errs = append(errs, fmt.Errorf("profile %q: unknown key %q", name, k))
+				continue
+			}
+			merged[k] = v
+		}
+		values = merged
+	}
+
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		f := flag.Lookup(k)
+		if f == nil || k == "config" {
+			errs = append(errs, fmt.Errorf("unknown key %q", k))
+			continue
+		}
+		if k == "profile" {
+			continue
+		}
+		vs, err := configFlagValues(f, values[k])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+			continue
+		}
+		if explicit[k] {
+			continue
+		}
+		for _, v := range vs {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+			}
+		}
+	}
+	return errs
+}
+
+// Loads the config file into flags. Must run right after flag.Parse.
+func LoadConfig() error {
+	values, p, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if errs := applyConfig(values); len(errs) > 0 {
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return fmt.Errorf("config %s: %s (run config check for details)", p, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Sample data covering every trip report field, so templates referring to
+// fields that don't exist fail the check.
+func sampleTripReportData() *TripReportData {
+	now := time.Now()
+	return &TripReportData{
+		Source:         &Source{Type: "gpx", Format: "gpx", Filename: "sample.gpx", Leg: &Leg{Number: 1, Count: 2}},
+		PeakName:       "Sample Peak",
+		Date:           now,
+		Uploaded:       now,
+		PeakInfo:       &PeakInfo{Name: "Sample Peak"},
+		Comparison:     &Comparison{PreviousAscents: 1},
+		PRNote:         true,
+		Ski:            &SkiStats{},
+		Traverse:       &Traverse{},
+		OnSummit:       time.Minute,
+		Movement:       &MovementStats{},
+		Pace:           true,
+		HeartRate:      &HeartRateStats{},
+		ShowHR:         true,
+		Conditions:     &Conditions{Summary: "snow"},
+		ShowConditions: true,
+		Daylight:       &Daylight{},
+		ShowDaylight:   true,
+	}
+}
+
+// Lists every trip report template file in use.
+func templateFiles() []string {
+	var files []string
+	if *tripReportTemplateDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(*tripReportTemplateDir, "*.tmpl"))
+		files = append(files, matches...)
+	}
+	for _, f := range []string{*tripReportTemplate, *tripReportHeader, *tripReportFooter} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Parses and renders every trip report template against sample data.
+func checkTemplates() []error {
+	if err := CheckTripReportFiles(); err != nil {
+		return []error{err}
+	}
+	var errs []error
+	data := sampleTripReportData()
+	for _, fn := range templateFiles() {
+		t, err := parseTemplateFile(fn)
+		if err == nil {
+			err = t.Execute(&bytes.Buffer{}, data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("template %s: %w", fn, err))
+		}
+	}
+	if *attribution {
+		t, err := template.New("attribution").Funcs(tripReportFuncs).Parse(*attributionText)
+		if err == nil {
+			err = t.Execute(&bytes.Buffer{}, data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-attribution_text: %w", err))
+		}
+	}
+	return errs
+}
+
+// Warns about credential files readable by others. Windows files have no
+// mode bits to check.
+func checkPrivateFile(name, path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(longPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", name, path, err)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s %s is readable by other users (mode %v), chmod 600 it", name, path, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// Checks credentials are present and loadable, without logging in.
+func checkCredentials() []error {
+	var errs []error
+	if !*offlineSimulate {
+		if *usernamePB == "" {
+			errs = append(errs, fmt.Errorf("no -username"))
+		}
+		if *passwordPB == "" && os.Getenv(passwordEnv) == "" && *cookiesFile == "" {
+			errs = append(errs, fmt.Errorf("no -password, $%s or -cookies", passwordEnv))
+		}
+	}
+	if *cookiesFile != "" {
+		if _, err := LoadCookies(*cookiesFile); err != nil {
+			errs = append(errs, fmt.Errorf("-cookies: %w", err))
+		}
+	}
+	if p, err := oauthTokensPath(); err == nil {
+		if err := checkPrivateFile("OAuth tokens", p); err != nil {
+			errs = append(errs, err)
+		}
+		tokens, err := loadOAuthTokens()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for name, t := range tokens {
+			if t.RefreshToken == "" && !t.Expiry.IsZero() && time.Now().After(t.Expiry) {
+				errs = append(errs, fmt.Errorf("OAuth token for %s expired, run auth %s", name, name))
+			}
+		}
+	}
+	if err := checkPrivateFile("users secrets file", *usersSecretsFile); err != nil {
+		errs = append(errs, err)
+	}
+	if p := configPath(); p != "" {
+		// The config may hold a password.
+		if err := checkPrivateFile("config", p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Runs the config subcommand. "config check" validates the config file,
+// flags, templates and credentials before a long run starts, printing every
+// problem found.
+func RunConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: config check")
+	}
+
+	var problems []error
+	values, p, err := readConfig()
+	if err != nil {
+		problems = append(problems, err)
+	}
+	if p != "" {
+		fmt.Printf("Config %s\n", p)
+	}
+	var profiles map[string]map[string]json.RawMessage
+	if values != nil {
+		profiles, _ = configProfiles(copyConfig(values))
+	}
+	problems = append(problems, applyConfig(values)...)
+	var names []string
+	for n := range profiles {
+		if n != *profileName {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		problems = append(problems, checkProfile(n, profiles[n])...)
+	}
+
+	for _, load := range []func() error{LoadDateFilters, LoadEnrichments, LoadMatchRegion, LoadGeofence, LoadHiddenRegion, LoadPeakCorrections} {
+		if err := load(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if *inputFile == "" && *inputDirectory != "" {
+		if fi, err := os.Stat(longPath(*inputDirectory)); err != nil || !fi.IsDir() {
+			problems = append(problems, fmt.Errorf("-directory %q is not a directory", *inputDirectory))
+		}
+	}
+	if *stravaExport != "" {
+		if _, err := os.Stat(longPath(*stravaExport)); err != nil {
+			problems = append(problems, fmt.Errorf("-strava_export %w", err))
+		}
+	}
+	if *gaiaExport != "" {
+		if _, err := os.Stat(longPath(*gaiaExport)); err != nil {
+			problems = append(problems, fmt.Errorf("-gaia_export %w", err))
+		}
+	}
+	if err := checkDeterminism(); err != nil {
+		problems = append(problems, err)
+	}
+	if *driver == "browser" && !*offlineSimulate {
+		if _, err := findChrome(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if *inputFormat != "" {
+		if err := checkFormat(*inputFormat); err != nil {
+			problems = append(problems, fmt.Errorf("-format: %w", err))
+		}
+	}
+	if *sourceName != "" {
+		if _, err := lookupActivitySource(*sourceName); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	problems = append(problems, checkTemplates()...)
+	problems = append(problems, checkCredentials()...)
+
+	if len(problems) == 0 {
+		fmt.Println("OK")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Printf("  %v\n", p)
+	}
+	return fmt.Errorf("config check found %d problems", len(problems))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	cookiesFile = flag.String("cookies", "", "Browser cookies for peakbagger.com, as a cookies.txt (Netscape format) or JSON export, used when programmatic login is broken")
+)
+
+// Cookie as exported by common browser extensions.
+type exportedCookie struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+}
+
+// Loads peakbagger.com cookies from a cookies.txt or JSON cookie export.
+func LoadCookies(filename string) ([]*http.Cookie, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var exported []exportedCookie
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "[") {
+		if err := json.Unmarshal(b, &exported); err != nil {
+			return nil, fmt.Errorf("parse cookie json %w", err)
+		}
+	} else {
+		// Netscape format: domain, include subdomains, path, secure, expiry, name, value.
+		s := bufio.NewScanner(strings.NewReader(string(b)))
+		for n := 1; s.Scan(); n++ {
+			line := strings.TrimPrefix(s.Text(), "#HttpOnly_")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Split(line, "\t")
+			if len(fields) != 7 {
+				// Don't quote the line, it may hold a cookie value.
+				return nil, fmt.Errorf("malformed cookies.txt line %d", n)
+			}
+			exported = append(exported, exportedCookie{Domain: fields[0], Name: fields[5], Value: fields[6]})
+		}
+	}
+
+	var cookies []*http.Cookie
+	for _, c := range exported {
+		if strings.HasSuffix(strings.TrimPrefix(c.Domain, "."), "peakbagger.com") {
+			RegisterSecret(c.Value)
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("no peakbagger.com cookies in %q", filename)
+	}
+	return cookies, nil
+}
+
+// Adds imported browser cookies to all Peakbagger requests, so the session
+// from the browser is reused.
+func InstallCookies(cookies []*http.Cookie) {
+	wrapDefaultTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if isPeakbagger(req) {
+				req = req.Clone(req.Context())
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	})
+	log.Infof("Using %d imported peakbagger.com cookies", len(cookies))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	corosAPIURL     = flag.String("coros_api", "https://teamapi.coros.com", "COROS Training Hub API for -source=coros, https://teameuapi.coros.com for accounts in the EU region")
+	corosEmail      = flag.String("coros_email", "", "COROS account email for -source=coros")
+	corosPassword   = flag.String("coros_password", "", "COROS account password for -source=coros, or set $"+corosPasswordEnv)
+	corosSportTypes = flag.String("coros_sport_types", "100,102,104,105", "Comma separated COROS sport types to upload with -source=coros (default run, trail run, hike and mountain climb)")
+)
+
+const corosPasswordEnv = "COROS_PASSWORD"
+
+// Training Hub's result code for success.
+const corosOK = "0000"
+
+// Syncs activities from COROS Training Hub, see -source. COROS has no OAuth
+// for individuals, so this signs in like the Training Hub site.
+type corosSource struct {
+	token string
+}
+
+func newCorosSource() (ActivitySource, error) {
+	password := *corosPassword
+	if password == "" {
+		password = os.Getenv(corosPasswordEnv)
+	}
+	if *corosEmail == "" || password == "" {
+		return nil, fmt.Errorf("-source=coros requires -coros_email and -coros_password or $%s", corosPasswordEnv)
+	}
+	RegisterSecret(password)
+	// The site sends the password's MD5 rather than the password itself,
+	// which signs in just the same.
+	sum := md5.Sum([]byte(password))
+	hash := hex.EncodeToString(sum[:])
+	RegisterSecret(hash)
+	body, err := json.Marshal(map[string]interface{}{
+		"account":     *corosEmail,
+		"accountType": 2,
+		"pwd":         hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", *corosAPIURL+"/account/login", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var login struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := corosDo(req, &login); err != nil {
+		return nil, fmt.Errorf("coros login %w", err)
+	}
+	RegisterSecret(login.AccessToken)
+	return &corosSource{token: login.AccessToken}, nil
+}
+
+// Sends a request and decodes the data of the response envelope, which
+// reports errors with a result code rather than the HTTP status.
+func corosDo(req *http.Request, data interface{}) error {
+	resp, err := doAPIRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Result  string          `json:"result"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("parse response %w", err)
+	}
+	if r.Result != corosOK {
+		return fmt.Errorf("%s %s", r.Result, r.Message)
+	}
+	return json.Unmarshal(r.Data, data)
+}
+
+func (c *corosSource) request(method, path string, q url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(method, *corosAPIURL+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accessToken", c.token)
+	return req, nil
+}
+
+func (c *corosSource) Activities(limit int) ([]*RemoteActivity, error) {
+	req, err := c.request("GET", "/activity/query", url.Values{"size": {strconv.Itoa(limit)}, "pageNumber": {"1"}})
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		DataList []struct {
+			LabelID   string `json:"labelId"`
+			Name      string `json:"name"`
+			SportType int    `json:"sportType"`
+			// Seconds since the epoch.
+			StartTime int64 `json:"startTime"`
+		} `json:"dataList"`
+	}
+	if err := corosDo(req, &data); err != nil {
+		return nil, err
+	}
+	var activities []*RemoteActivity
+	for _, d := range data.DataList {
+		activities = append(activities, &RemoteActivity{
+			Source: "coros",
+			ID:     d.LabelID,
+			Name:   d.Name,
+			Type:   strconv.Itoa(d.SportType),
+			Start:  time.Unix(d.StartTime, 0).UTC(),
+		})
+	}
+	return activities, nil
+}
+
+func (c *corosSource) Selected(a *RemoteActivity) bool {
+	for _, t := range strings.Split(*corosSportTypes, ",") {
+		if strings.TrimSpace(t) == a.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Training Hub's file type for FIT exports.
+const corosFileTypeFIT = "4"
+
+// Asks Training Hub to export the activity as FIT, then downloads the file
+// from the URL it returns.
+func (c *corosSource) Download(a *RemoteActivity, dir string) (string, error) {
+	req, err := c.request("POST", "/activity/detail/download", url.Values{
+		"labelId":   {a.ID},
+		"sportType": {a.Type},
+		"fileType":  {corosFileTypeFIT},
+	})
+	if err != nil {
+		return "", err
+	}
+	var export struct {
+		FileURL string `json:"fileUrl"`
+	}
+	if err := corosDo(req, &export); err != nil {
+		return "", fmt.Errorf("export activity %s %w", a.ID, err)
+	}
+	req, err = http.NewRequest("GET", export.FileURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doAPIRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	filename := filepath.Join(dir, a.ID+".fit")
+	if err := saveDownload(resp.Body, filename); err != nil {
+		return "", fmt.Errorf("download activity %s %w", a.ID, err)
+	}
+	return filename, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	peakCorrectionsFile = flag.String("peak_corrections", "", "JSON file of peak coordinate corrections, a list of {PeakID, Name, Latitude, Longitude, Note} merged over the built-in set, which ships empty")
+)
+
+// Known bad Peakbagger summit coordinates. Add entries here when a peak's
+// listed location is off by more than ~100m so matching scores against the
+// true summit. None ship yet, corrections come from -peak_corrections.
+//
+//go:embed peak_corrections.json
+var builtinPeakCorrections []byte
+
+// Corrected summit location for a peak.
+type PeakCorrection struct {
+	PeakID    int
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Note      string
+}
+
+var peakCorrections map[string]*PeakCorrection
+
+// Loads built-in and user corrections, keyed by peak ID. User corrections
+// override built-in ones for the same peak.
+func LoadPeakCorrections() error {
+	var corrections []*PeakCorrection
+	if err := json.Unmarshal(builtinPeakCorrections, &corrections); err != nil {
+		return fmt.Errorf("parse built-in peak corrections %w", err)
+	}
+	if *peakCorrectionsFile != "" {
+		b, err := os.ReadFile(*peakCorrectionsFile)
+		if err != nil {
+			return fmt.Errorf("read peak corrections %w", err)
+		}
+		var user []*PeakCorrection
+		if err := json.Unmarshal(b, &user); err != nil {
+			return fmt.Errorf("parse peak corrections %w", err)
+		}
+		corrections = append(corrections, user...)
+	}
+
+	peakCorrections = make(map[string]*PeakCorrection)
+	for _, c := range corrections {
+		peakCorrections[strconv.Itoa(c.PeakID)] = c
+	}
+	log.Infof("Loaded %d peak coordinate corrections", len(peakCorrections))
+	return nil
+}
+
+// Returns corrected peaks within radius meters of a point that aren't among
+// found. Peaks listed far from their true summit aren't found by a search
+// around the track, so they are added by ID.
+func correctedPeaksNear(lat, lng, radius float64, found peakbagger.PeakList) peakbagger.PeakList {
+	have := make(map[string]bool)
+	for _, p := range found {
+		have[fmt.Sprint(p.PeakID)] = true
+	}
+	var peaks peakbagger.PeakList
+	for id, c := range peakCorrections {
+		if have[id] || Distance(c.Latitude, c.Longitude, lat, lng) > radius {
+			continue
+		}
+		peaks = append(peaks, &peakbagger.Peak{
+			PeakID:    peakbagger.PeakID(c.PeakID),
+			Name:      c.Name,
+			Latitude:  c.Latitude,
+			Longitude: c.Longitude,
+		})
+	}
+	return peaks
+}
+
+// Replaces the coordinates of any peaks with known corrections.
+func ApplyPeakCorrections(peaks peakbagger.PeakList) {
+	for i := range peaks {
+		c, ok := peakCorrections[fmt.Sprint(peaks[i].PeakID)]
+		if !ok {
+			continue
+		}
+		log.Infof("Correcting location of %q from %.5f,%.5f to %.5f,%.5f", peaks[i].Name,
+			peaks[i].Latitude, peaks[i].Longitude, c.Latitude, c.Longitude)
+		peaks[i].Latitude = c.Latitude
+		peaks[i].Longitude = c.Longitude
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	crashDir        = flag.String("crash_dir", "", "Directory for diagnostics written when processing a file panics (default a directory under the system temp directory)")
+	bundleFile      = flag.String("bundle", "", "Package crash diagnostics into this zip file for a bug report, then exit")
+	bundleAnonymize = flag.Bool("bundle_anonymize", true, "Strip coordinates, file names and the home directory from the bundle")
+)
+
+// Number of recent log lines kept for crash reports.
+const recentLogLines = 200
+
+// Keeps the most recent formatted log lines.
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+var recentLog = &logRing{}
+
+func (r *logRing) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (r *logRing) Fire(e *log.Entry) error {
+	b, err := e.Logger.Formatter.Format(e)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, string(b))
+	if len(r.lines) > recentLogLines {
+		r.lines = r.lines[len(r.lines)-recentLogLines:]
+	}
+	return nil
+}
+
+func (r *logRing) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.lines, "")
+}
+
+func crashDirectory() string {
+	if *crashDir != "" {
+		return *crashDir
+	}
+	return filepath.Join(os.TempDir(), "peakbagger-bulk-uploader-crashes")
+}
+
+// Identifies a file without its contents, so the report can be matched up
+// with the file later.
+func describeFile(filename string) string {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return fmt.Sprintf("%s (%v)", filename, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Sprintf("%s (%v)", filename, err)
+	}
+	return fmt.Sprintf("%s (%d bytes, sha256 %x)", filename, n, h.Sum(nil))
+}
+
+// Writes a diagnostics report for a panic, returning its path.
+func writeCrashReport(filename string, panicValue interface{}, stack []byte) (string, error) {
+	dir := crashDirectory()
+	if err := os.MkdirAll(longPath(dir), 0700); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.txt", now.Format("20060102-150405.000")))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "peakbagger-bulk-uploader %s, %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Time: %v\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "File: %s\n", describeFile(filename))
+	fmt.Fprintf(&b, "Flags:")
+	flag.Visit(func(f *flag.Flag) {
+		fmt.Fprintf(&b, " -%s=%s", f.Name, f.Value)
+	})
+	fmt.Fprintf(&b, "\n\nPanic: %v\n\n%s\n", panicValue, stack)
+	fmt.Fprintf(&b, "Recent log:\n%s", recentLog)
+
+	return path, os.WriteFile(longPath(path), []byte(Redact(b.String())), 0600)
+}
+
+// Processes a file, turning a panic into an error and a crash report so the
+// rest of the archive can still be processed.
+func (u *Uploader) UploadFileRecovering(filename string) error {
+	return u.uploadRecovering(filename, filepath.Base(filename))
+}
+
+// Like UploadFileRecovering, with the name identifying the file in reports
+// and history, e.g. for a file extracted from an archive.
+func (u *Uploader) uploadRecovering(filename, name string) (err error) {
+	Emit(Event{Type: EventFileStarted, File: name})
+	defer func() {
+		Emit(Event{Type: EventFileFinished, File: name}.withError(err))
+	}()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := debug.Stack()
+		log.Errorf("Panic processing %q: %v", filename, r)
+		path, werr := writeCrashReport(filename, r, stack)
+		if werr != nil {
+			log.Errorf("Failed to write crash report: %v", werr)
+			err = fmt.Errorf("panic %v", r)
+			return
+		}
+		err = fmt.Errorf("panic %v, diagnostics written to %s", r, path)
+	}()
+	return u.uploadFileAs(filename, name)
+}
+
+var (
+	// Decimal degrees and similar high precision numbers.
+	coordinateRe = regexp.MustCompile(`-?\d{1,3}\.\d{4,}`)
+	// Track file names, which often contain dates and place names.
+	trackFileRe = regexp.MustCompile(`[^\s"'/\\]+\.(?i:gpx|fit|tcx|kml|kmz|gdb)\b`)
+)
+
+// Removes identifying details from a crash report.
+func anonymize(s string) string {
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+	s = coordinateRe.ReplaceAllString(s, "<number>")
+	return trackFileRe.ReplaceAllString(s, "<track file>")
+}
+
+// Packages all crash reports into a zip for attaching to a bug report.
+func WriteBundle(filename string) error {
+	dir := crashDirectory()
+	reports, err := filepath.Glob(filepath.Join(dir, "crash-*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		return fmt.Errorf("no crash reports in %s", dir)
+	}
+	sort.Strings(reports)
+
+	f, err := os.Create(longPath(filename))
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, r := range reports {
+		b, err := os.ReadFile(longPath(r))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		s := Redact(string(b))
+		if *bundleAnonymize {
+			s = anonymize(s)
+		}
+		w, err := zw.Create(filepath.Base(r))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := io.WriteString(w, s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Infof("Wrote %d crash reports to %s, please review it before attaching it to a bug report", len(reports), filename)
+	return nil
+}
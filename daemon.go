@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	daemon         = flag.Bool("daemon", false, "Keep running, rescanning the input directory periodically")
+	daemonInterval = flag.Duration("daemon_interval", time.Hour, "Time between directory scans in daemon mode")
+
+	maxUnavailableBackoff = flag.Duration("max_unavailable_backoff", 4*time.Hour, "Maximum pause between retries while Peakbagger is unavailable in daemon mode")
+)
+
+const initialUnavailableBackoff = 15 * time.Minute
+
+// Runs forever, rescanning the input directory. While Peakbagger is down
+// for maintenance scans pause with exponential backoff. With -schedule, scans
+// run on their schedules instead.
+func (u *Uploader) RunDaemon() error {
+	if u.dir == "" && len(schedules) == 0 {
+		return fmt.Errorf("daemon mode requires -directory")
+	}
+
+	if *approvalAddr != "" {
+		if err := u.StartApprovalServer(); err != nil {
+			return err
+		}
+	}
+	if *feedAddr != "" {
+		if err := StartFeedServer(); err != nil {
+			return err
+		}
+	}
+	if len(schedules) > 0 {
+		return u.RunSchedules()
+	}
+
+	var index indexWatcher
+	backoff := initialUnavailableBackoff
+	for {
+		u.mu.Lock()
+		if err := index.Update(u.dir); err != nil {
+			log.Warnf("Failed to update index: %v", err)
+		}
+		since, pendingBefore := u.report.Len(), u.approvals.added()
+		err := u.Run()
+		if !IsUnavailable(err) {
+			u.NotifyResults(since, pendingBefore)
+		}
+		u.mu.Unlock()
+		if IsUnavailable(err) {
+			log.Warnf("%v, retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > *maxUnavailableBackoff {
+				backoff = *maxUnavailableBackoff
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		backoff = initialUnavailableBackoff
+		u.mu.Lock()
+		err = u.WriteReport()
+		u.mu.Unlock()
+		if err != nil {
+			log.Errorf("Failed to write report: %v", err)
+		}
+
+		log.Infof("Scan complete, next scan in %v", *daemonInterval)
+		time.Sleep(*daemonInterval)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	deferGPX = flag.Bool("defer_gpx", false, "Log ascents without their GPS track and keep the track to attach later with attach-deferred, e.g. when on a slow connection. Needs a driver that can update ascents")
+)
+
+// Folder in the input directory holding tracks of ascents logged with
+// -defer_gpx, until attach-deferred attaches them.
+const DeferredGPXDir = "deferred_gpx"
+
+// Strips the GPX from an ascent about to be added and saves it for later.
+// Returns the ascent to add.
+func deferAscentGPX(inputDir string, prep *PreparedAscent) (*PreparedAscent, error) {
+	if !*deferGPX || prep.Ascent.Gpx == nil {
+		return prep, nil
+	}
+	if !*offlineSimulate {
+		dir := filepath.Join(inputDir, DeferredGPXDir)
+		if err := os.MkdirAll(longPath(dir), 0755); err != nil {
+			return nil, err
+		}
+		b, err := prep.Ascent.Gpx.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%v-%s.gpx", prep.Ascent.PeakID, prep.Ascent.Date.UTC().Format("20060102T150405Z"))
+		if err := os.WriteFile(longPath(filepath.Join(dir, name)), b, 0644); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Deferring GPS track of %q, run attach-deferred to upload it", prep.PeakName)
+	deferred := *prep
+	deferred.Ascent.Gpx = nil
+	return &deferred, nil
+}
+
+// Attaches the tracks saved by -defer_gpx to their ascents, removing each
+// once attached.
+func (u *Uploader) AttachDeferred() error {
+	dir := filepath.Join(u.dir, DeferredGPXDir)
+	files, err := ioutil.ReadDir(longPath(dir))
+	if os.IsNotExist(err) {
+		log.Infof("No deferred tracks")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	attached := 0
+	for _, fi := range files {
+		if fi.IsDir() || !strings.EqualFold(filepath.Ext(fi.Name()), ".gpx") {
+			continue
+		}
+		filename := filepath.Join(dir, fi.Name())
+		since := u.report.Len()
+		if err := u.attachFile(filename); err != nil {
+			return err
+		}
+		done := false
+		for _, e := range u.report.entriesSince(since) {
+			done = done || e.Uploaded
+		}
+		if !done {
+			log.Warnf("Deferred track %q not attached, keeping it", fi.Name())
+			continue
+		}
+		attached++
+		if err := os.Remove(longPath(filename)); err != nil {
+			return err
+		}
+	}
+	log.Infof("Attached %d deferred tracks", attached)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	deterministic = flag.Bool("deterministic", false, "Make reports, trip reports, history and events reproducible, with fixed run timestamps and times in UTC, so runs over the same input can be diffed byte for byte. Only with -dry_run or -offline_simulate")
+)
+
+// Stands in for the current time in outputs with -deterministic.
+var deterministicTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Returns the current time for values written to outputs, such as when an
+// ascent was uploaded. Timing that only affects behavior, like rate limits
+// and schedules, uses the real clock.
+func outputNow() time.Time {
+	if *deterministic {
+		return deterministicTime
+	}
+	return time.Now()
+}
+
+// Checks that -deterministic is only used on runs that don't upload, since
+// it would record fixed timestamps in the history, manifest and published
+// trip reports, and dates in UTC.
+func checkDeterminism() error {
+	if *deterministic && !*dryRun && !*offlineSimulate {
+		return fmt.Errorf("-deterministic requires -dry_run or -offline_simulate")
+	}
+	return nil
+}
+
+// Applies -deterministic settings that must be in place before processing.
+// Outputs otherwise depend on the machine's time zone. Nothing that reaches
+// an output uses randomness; the benchmark's synthetic tracks are seeded.
+func ConfigureDeterminism() error {
+	if err := checkDeterminism(); err != nil {
+		return err
+	}
+	if *deterministic {
+		time.Local = time.UTC
+	}
+	return nil
+}
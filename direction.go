@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	traverseDistance = flag.Float64("traverse_distance", 1000, "Distance in meters between a track's start and end beyond which it is treated as a point-to-point traverse")
+)
+
+// Describes a point-to-point track, which goes up one side of the peak and
+// down another.
+type Traverse struct {
+	// Elevations in meters where the ascent started and the descent ended.
+	StartElevation float64
+	EndElevation   float64
+	// Straight line distance between the start and end, in meters.
+	Distance float64
+}
+
+// Returns the track with its points in chronological order. Some exports and
+// converted routes list points newest first, which would swap the ascent and
+// descent. Tracks without timestamps are returned unchanged.
+func orientTrack(t gpx.GPXTrack) (gpx.GPXTrack, bool) {
+	points := trackPoints(&t)
+	var first, last *gpx.GPXPoint
+	for i := range points {
+		if points[i].Timestamp.IsZero() {
+			continue
+		}
+		if first == nil {
+			first = &points[i]
+		}
+		last = &points[i]
+	}
+	if first == nil || !first.Timestamp.After(last.Timestamp) {
+		return t, false
+	}
+
+	// Copy rather than reverse in place, the segments are shared with the
+	// caller's GPX.
+	reversed := t
+	reversed.Segments = make([]gpx.GPXTrackSegment, len(t.Segments))
+	for i, seg := range t.Segments {
+		r := seg
+		r.Points = make([]gpx.GPXPoint, len(seg.Points))
+		for j, p := range seg.Points {
+			r.Points[len(seg.Points)-1-j] = p
+		}
+		reversed.Segments[len(t.Segments)-1-i] = r
+	}
+	return reversed, true
+}
+
+// Drops points whose timestamps repeat or go backwards from a chronologically
+// oriented track, which otherwise produce zero or negative durations and
+// infinite speeds. A single point stamped ahead of its successors is dropped
+// itself rather than everything after it. Returns the repaired track and the
+// number of points dropped.
+func repairTimestamps(t gpx.GPXTrack) (gpx.GPXTrack, int) {
+	points := trackPoints(&t)
+	keep := make([]bool, len(points))
+	var last time.Time
+	dropped := 0
+	for i, p := range points {
+		keep[i] = true
+		if p.Timestamp.IsZero() {
+			continue
+		}
+		if !last.IsZero() && !p.Timestamp.After(last) {
+			keep[i] = false
+			dropped++
+			continue
+		}
+		if next := nextTimestamp(points, i); !next.IsZero() && !next.After(p.Timestamp) && (last.IsZero() || next.After(last)) {
+			keep[i] = false
+			dropped++
+			continue
+		}
+		last = p.Timestamp
+	}
+	if dropped == 0 {
+		return t, 0
+	}
+
+	repaired := t
+	repaired.Segments = nil
+	i := 0
+	for _, seg := range t.Segments {
+		r := seg
+		r.Points = nil
+		for _, p := range seg.Points {
+			if keep[i] {
+				r.Points = append(r.Points, p)
+			}
+			i++
+		}
+		if len(r.Points) > 0 {
+			repaired.Segments = append(repaired.Segments, r)
+		}
+	}
+	return repaired, dropped
+}
+
+// Returns the first timestamp after index i, or zero if there is none.
+func nextTimestamp(points []gpx.GPXPoint, i int) time.Time {
+	for _, p := range points[i+1:] {
+		if !p.Timestamp.IsZero() {
+			return p.Timestamp
+		}
+	}
+	return time.Time{}
+}
+
+// Returns the traverse described by a chronologically ordered track, or nil
+// for an out and back or loop.
+func DetectTraverse(tb *TrackBounds) *Traverse {
+	d := pointDistance(tb.Start, tb.End)
+	if d < *traverseDistance {
+		return nil
+	}
+	return &Traverse{
+		StartElevation: tb.Start.Elevation.Value(),
+		EndElevation:   tb.End.Elevation.Value(),
+		Distance:       d,
+	}
+}
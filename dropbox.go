@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	dropboxFolder = flag.String("dropbox_folder", "", "Dropbox folder to upload new track files from with -source=dropbox, e.g. /GPS where a phone app saves its recordings. Run with -daemon to poll it")
+	dropboxToken  = flag.String("dropbox_token", "", "Dropbox access token for -source=dropbox, or set $"+dropboxTokenEnv+"; without one, authorize with the auth dropbox command")
+)
+
+const dropboxTokenEnv = "DROPBOX_TOKEN"
+
+var (
+	dropboxAPIURL     = "https://api.dropboxapi.com"
+	dropboxContentURL = "https://content.dropboxapi.com"
+)
+
+// Syncs track files saved to a Dropbox folder, see -source. Files are
+// recorded in the history by their Dropbox ID, so they are uploaded once even
+// when renamed or moved within the folder.
+type dropboxSource struct {
+	token string
+}
+
+func newDropboxSource() (ActivitySource, error) {
+	if *dropboxFolder == "" {
+		return nil, fmt.Errorf("-source=dropbox requires -dropbox_folder")
+	}
+	token := *dropboxToken
+	if token == "" {
+		token = os.Getenv(dropboxTokenEnv)
+	}
+	if token == "" {
+		var err error
+		if token, err = OAuthAccessToken("dropbox"); err != nil {
+			return nil, err
+		}
+	}
+	RegisterSecret(token)
+	return &dropboxSource{token: token}, nil
+}
+
+// Calls an RPC endpoint, which takes and returns JSON.
+func (d *dropboxSource) rpc(endpoint string, arg, result interface{}) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", dropboxAPIURL+"/2/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doAPIRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("parse %s response %w", endpoint, err)
+	}
+	return nil
+}
+
+type dropboxEntry struct {
+	Tag  string `json:".tag"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// When the file was saved on the device that uploaded it, the closest
+	// to the recording time before it is downloaded.
+	ClientModified time.Time `json:"client_modified"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+// Lists the track files in -dropbox_folder, the most recently uploaded
+// first. Files are told apart by extension, sniffing content would mean
+// downloading every file, and other files don't count towards the limit.
+func (d *dropboxSource) Activities(limit int) ([]*RemoteActivity, error) {
+	var list struct {
+		Entries []dropboxEntry `json:"entries"`
+		Cursor  string         `json:"cursor"`
+		HasMore bool           `json:"has_more"`
+	}
+	// Dropbox writes the root as "".
+	folder := strings.TrimSuffix(*dropboxFolder, "/")
+	if err := d.rpc("files/list_folder", map[string]interface{}{"path": folder}, &list); err != nil {
+		return nil, err
+	}
+	entries := list.Entries
+	for list.HasMore {
+		list.Entries = nil
+		if err := d.rpc("files/list_folder/continue", map[string]string{"cursor": list.Cursor}, &list); err != nil {
+			return nil, err
+		}
+		entries = append(entries, list.Entries...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ServerModified.After(entries[j].ServerModified)
+	})
+	var activities []*RemoteActivity
+	for _, e := range entries {
+		if e.Tag != "file" || !trackFileSupported(e.Name) {
+			continue
+		}
+		if len(activities) == limit {
+			break
+		}
+		activities = append(activities, &RemoteActivity{
+			Source: "dropbox",
+			ID:     e.ID,
+			// The file name, which hints the peak climbed when named for it.
+			Name:  e.Name,
+			Start: e.ClientModified,
+		})
+	}
+	return activities, nil
+}
+
+// Every track file is selected, others are already left out.
+func (d *dropboxSource) Selected(a *RemoteActivity) bool {
+	return true
+}
+
+// Downloads the file under its own name, so its extension selects the
+// decoder.
+func (d *dropboxSource) Download(a *RemoteActivity, dir string) (string, error) {
+	arg, err := json.Marshal(map[string]string{"path": a.ID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", dropboxContentURL+"/2/files/download", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Dropbox-API-Arg", string(arg))
+	resp, err := doAPIRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	filename := filepath.Join(dir, filepath.Base(a.Name))
+	if err := saveDownload(resp.Body, filename); err != nil {
+		return "", fmt.Errorf("download %s %w", a.Name, err)
+	}
+	return filename, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Turns terminal echo of stdin on or off, failing when stdin isn't a
+// terminal.
+func setEcho(on bool) error {
+	arg := "echo"
+	if !on {
+		arg = "-echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	elevationProviderName = flag.String("elevation_provider", "none", "DEM used by the dem gain algorithm and ski slopes: none, srtm, usgs or open-elevation")
+	srtmDir               = flag.String("srtm_dir", "", "Directory of SRTM .hgt tiles (e.g. N46W122.hgt) for -elevation_provider=srtm, missing tiles are downloaded into the tile cache")
+	srtmURL               = flag.String("srtm_url", "https://s3.amazonaws.com/elevation-tiles-prod/skadi", "Base URL of gzipped SRTM tiles, laid out as N46/N46W122.hgt.gz")
+)
+
+// A location to look up, in degrees.
+type LatLng struct {
+	Lat, Lng float64
+}
+
+// Looks up ground elevations in meters from a digital elevation model.
+type ElevationProvider interface {
+	Elevation(lat, lng float64) (float64, error)
+	// Looks up many locations at once, for providers where that's cheaper.
+	Elevations(locs []LatLng) ([]float64, error)
+}
+
+// Available providers, keyed by -elevation_provider name.
+var elevationProviders = map[string]func() ElevationProvider{
+	"none":           func() ElevationProvider { return nullElevation{} },
+	"srtm":           func() ElevationProvider { return &srtmElevation{dir: *srtmDir, tiles: make(map[string]*srtmTile)} },
+	"usgs":           func() ElevationProvider { return usgsElevation{} },
+	"open-elevation": func() ElevationProvider { return openElevation{} },
+}
+
+var (
+	elevationOnce     sync.Once
+	elevationProvider ElevationProvider
+)
+
+// Returns the -elevation_provider, wrapped in a cache shared by all users.
+func DEM() ElevationProvider {
+	elevationOnce.Do(func() {
+		newProvider, ok := elevationProviders[*elevationProviderName]
+		if !ok {
+			log.Warnf("Unknown -elevation_provider %q, using none", *elevationProviderName)
+			newProvider = elevationProviders["none"]
+		}
+		elevationProvider = &cachedElevation{next: newProvider(), cache: make(map[LatLng]float64)}
+	})
+	return elevationProvider
+}
+
+// Looks up each location one at a time, for providers without a batch API.
+func elevationsEach(p ElevationProvider, locs []LatLng) ([]float64, error) {
+	e := make([]float64, len(locs))
+	for i, l := range locs {
+		v, err := p.Elevation(l.Lat, l.Lng)
+		if err != nil {
+			return nil, err
+		}
+		e[i] = v
+	}
+	return e, nil
+}
+
+type nullElevation struct{}
+
+func (nullElevation) Elevation(lat, lng float64) (float64, error) {
+	return 0, errNoDEM
+}
+
+func (nullElevation) Elevations(locs []LatLng) ([]float64, error) {
+	return nil, errNoDEM
+}
+
+// Caches lookups in memory, rounded to about a meter.
+type cachedElevation struct {
+	next ElevationProvider
+
+	mu    sync.Mutex
+	cache map[LatLng]float64
+}
+
+func roundLatLng(lat, lng float64) LatLng {
+	return LatLng{math.Round(lat*1e5) / 1e5, math.Round(lng*1e5) / 1e5}
+}
+
+func (c *cachedElevation) Elevation(lat, lng float64) (float64, error) {
+	e, err := c.Elevations([]LatLng{{lat, lng}})
+	if err != nil {
+		return 0, err
+	}
+	return e[0], nil
+}
+
+func (c *cachedElevation) Elevations(locs []LatLng) ([]float64, error) {
+	e := make([]float64, len(locs))
+	var missing []LatLng
+	var missingIdx []int
+	c.mu.Lock()
+	for i, l := range locs {
+		k := roundLatLng(l.Lat, l.Lng)
+		if v, ok := c.cache[k]; ok {
+			e[i] = v
+		} else {
+			missing = append(missing, k)
+			missingIdx = append(missingIdx, i)
+		}
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return e, nil
+	}
+
+	found, err := c.next.Elevations(missing)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j, i := range missingIdx {
+		e[i] = found[j]
+		c.cache[missing[j]] = found[j]
+	}
+	return e, nil
+}
+
+// Reads SRTM .hgt tiles: 1x1 degree grids of big endian int16 elevations,
+// 1201 (3 arc second) or 3601 (1 arc second) samples square, named after
+// their southwest corner.
+type srtmElevation struct {
+	dir string
+
+	mu    sync.Mutex
+	tiles map[string]*srtmTile
+}
+
+type srtmTile struct {
+	size    int
+	samples []int16
+}
+
+// Marks missing data in SRTM tiles.
+const srtmVoid = -32768
+
+func srtmTileName(lat, lng float64) string {
+	la, ln := int(math.Floor(lat)), int(math.Floor(lng))
+	ns, ew := 'N', 'E'
+	if la < 0 {
+		ns, la = 'S', -la
+	}
+	if ln < 0 {
+		ew, ln = 'W', -ln
+	}
+	return fmt.Sprintf("%c%02d%c%03d.hgt", ns, la, ew, ln)
+}
+
+func (s *srtmElevation) tile(name string) (*srtmTile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tiles[name]; ok {
+		return t, nil
+	}
+	b, err := s.read(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoDEM, err)
+	}
+	size := int(math.Sqrt(float64(len(b) / 2)))
+	if size*size*2 != len(b) || size < 2 {
+		return nil, fmt.Errorf("srtm tile %s has unexpected size %d", name, len(b))
+	}
+	t := &srtmTile{size: size, samples: make([]int16, size*size)}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, t.samples); err != nil {
+		return nil, err
+	}
+	s.tiles[name] = t
+	return t, nil
+}
+
+// Reads a tile from -srtm_dir, or from the tile cache.
+func (s *srtmElevation) read(name string) ([]byte, error) {
+	if s.dir != "" {
+		b, err := os.ReadFile(longPath(filepath.Join(s.dir, name)))
+		if !errors.Is(err, os.ErrNotExist) {
+			return b, err
+		}
+	}
+	cached := name[:3] + "/" + name + ".gz"
+	gz, err := tileCache.Get(srtmTiles, cached, *srtmURL+"/"+cached)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Bilinearly interpolates between the four surrounding samples.
+func (s *srtmElevation) Elevation(lat, lng float64) (float64, error) {
+	t, err := s.tile(srtmTileName(lat, lng))
+	if err != nil {
+		return 0, err
+	}
+	n := float64(t.size - 1)
+	// Rows run north to south.
+	y := (math.Ceil(lat) - lat) * n
+	x := (lng - math.Floor(lng)) * n
+	r, c := int(y), int(x)
+	if r >= t.size-1 {
+		r = t.size - 2
+	}
+	if c >= t.size-1 {
+		c = t.size - 2
+	}
+	fy, fx := y-float64(r), x-float64(c)
+
+	at := func(r, c int) (float64, bool) {
+		v := t.samples[r*t.size+c]
+		return float64(v), v != srtmVoid
+	}
+	v00, ok00 := at(r, c)
+	v01, ok01 := at(r, c+1)
+	v10, ok10 := at(r+1, c)
+	v11, ok11 := at(r+1, c+1)
+	if !ok00 || !ok01 || !ok10 || !ok11 {
+		return 0, fmt.Errorf("%w: void in srtm data at %.5f, %.5f", errNoDEM, lat, lng)
+	}
+	top := v00 + (v01-v00)*fx
+	bottom := v10 + (v11-v10)*fx
+	return top + (bottom-top)*fy, nil
+}
+
+func (s *srtmElevation) Elevations(locs []LatLng) ([]float64, error) {
+	return elevationsEach(s, locs)
+}
+
+// The USGS Elevation Point Query Service, US only and one point per request.
+type usgsElevation struct{}
+
+const usgsEPQS = "https://epqs.nationalmap.gov/v1/json"
+
+func (usgsElevation) Elevation(lat, lng float64) (float64, error) {
+	q := url.Values{
+		"x":     {fmt.Sprint(lng)},
+		"y":     {fmt.Sprint(lat)},
+		"units": {"Meters"},
+		"wkid":  {"4326"},
+	}
+	resp, err := apiClient().Get(usgsEPQS + "?" + q.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("usgs elevation: %s", resp.Status)
+	}
+	var r struct {
+		Value json.Number `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return 0, fmt.Errorf("decode usgs elevation %w", err)
+	}
+	v, err := r.Value.Float64()
+	// The service returns a large negative value outside its coverage.
+	if err != nil || v < -1000 {
+		return 0, fmt.Errorf("%w: no usgs elevation at %.5f, %.5f", errNoDEM, lat, lng)
+	}
+	return v, nil
+}
+
+func (p usgsElevation) Elevations(locs []LatLng) ([]float64, error) {
+	return elevationsEach(p, locs)
+}
+
+// The public Open-Elevation API, which takes batches of locations.
+type openElevation struct{}
+
+const (
+	openElevationAPI = "https://api.open-elevation.com/api/v1/lookup"
+	// Keeps request bodies within the public instance's limits.
+	openElevationBatch = 200
+)
+
+func (p openElevation) Elevation(lat, lng float64) (float64, error) {
+	e, err := p.Elevations([]LatLng{{lat, lng}})
+	if err != nil {
+		return 0, err
+	}
+	return e[0], nil
+}
+
+func (openElevation) Elevations(locs []LatLng) ([]float64, error) {
+	type location struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Elevation float64 `json:"elevation,omitempty"`
+	}
+	var e []float64
+	for start := 0; start < len(locs); start += openElevationBatch {
+		end := start + openElevationBatch
+		if end > len(locs) {
+			end = len(locs)
+		}
+		var req struct {
+			Locations []location `json:"locations"`
+		}
+		for _, l := range locs[start:end] {
+			req.Locations = append(req.Locations, location{Latitude: l.Lat, Longitude: l.Lng})
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := apiClient().Post(openElevationAPI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		var r struct {
+			Results []location `json:"results"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("open-elevation: %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode open-elevation %w", err)
+		}
+		if len(r.Results) != end-start {
+			return nil, fmt.Errorf("open-elevation returned %d results for %d locations", len(r.Results), end-start)
+		}
+		for _, l := range r.Results {
+			e = append(e, l.Elevation)
+		}
+	}
+	return e, nil
+}
+
+// Returns a copy of points with elevations resampled from the DEM.
+func demElevations(points []gpx.GPXPoint) ([]gpx.GPXPoint, error) {
+	locs := make([]LatLng, len(points))
+	for i, p := range points {
+		locs[i] = LatLng{p.Latitude, p.Longitude}
+	}
+	e, err := DEM().Elevations(locs)
+	if err != nil {
+		return nil, err
+	}
+	resampled := append([]gpx.GPXPoint(nil), points...)
+	for i := range resampled {
+		resampled[i].Elevation = *gpx.NewNullableFloat64(e[i])
+	}
+	return resampled, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Lets wrappers and GUIs show progress without scraping logs.
+	eventsTarget = flag.String("events", "", "Write newline-delimited JSON progress events to - (stdout, with everything else printed moved to stderr), a file, or unix:/path to connect to a listening Unix socket")
+)
+
+// Progress event types.
+const (
+	EventFileStarted    = "file_started"
+	EventFileFinished   = "file_finished"
+	EventPeakMatched    = "peak_matched"
+	EventAscentQueued   = "ascent_queued"
+	EventAscentUploaded = "ascent_uploaded"
+	EventError          = "error"
+	EventRunFinished    = "run_finished"
+)
+
+// A progress event, written as a single JSON line.
+type Event struct {
+	Time time.Time
+	Type string
+
+	File     string `json:",omitempty"`
+	Track    string `json:",omitempty"`
+	PeakID   string `json:",omitempty"`
+	PeakName string `json:",omitempty"`
+	AscentID string `json:",omitempty"`
+
+	Error string `json:",omitempty"`
+	// See ErrorCategory.
+	ErrorCategory string `json:",omitempty"`
+}
+
+var events struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// Opens the -events destination. The returned func closes it.
+func OpenEvents() (func(), error) {
+	target := *eventsTarget
+	var w io.WriteCloser
+	switch {
+	case target == "":
+		return func() {}, nil
+	case target == "-":
+		// Logs already go to stderr. Send what commands and reports print
+		// there too, so readers of stdout only see events.
+		w = nopCloser{os.Stdout}
+		os.Stdout = os.Stderr
+	case strings.HasPrefix(target, "unix:"):
+		// The reader listens and we connect, so no events are lost before it
+		// has attached.
+		c, err := net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+		if err != nil {
+			return nil, fmt.Errorf("connect to events socket %w", err)
+		}
+		w = c
+	default:
+		f, err := os.OpenFile(longPath(target), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open events file %w", err)
+		}
+		w = f
+	}
+
+	events.mu.Lock()
+	events.w = w
+	events.mu.Unlock()
+	return func() {
+		events.mu.Lock()
+		defer events.mu.Unlock()
+		if events.w != nil {
+			events.w.Close()
+			events.w = nil
+		}
+	}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Writes a progress event if -events is set. A failed write, e.g. the GUI
+// went away, stops further events but not the run.
+func Emit(e Event) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if events.w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = outputNow()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Warnf("Failed to encode progress event: %v", err)
+		return
+	}
+	if _, err := events.w.Write(append(b, '\n')); err != nil {
+		log.Warnf("Failed to write progress event, disabling -events: %v", err)
+		events.w.Close()
+		events.w = nil
+	}
+}
+
+// Fills the error fields of an event.
+func (e Event) withError(err error) Event {
+	if err != nil {
+		e.Error = Redact(err.Error())
+		e.ErrorCategory = ErrorCategory(err)
+	}
+	return e
+}
+
+// Builds an event for a report entry.
+func (e *ReportEntry) event(typ string) Event {
+	return Event{
+		Type:     typ,
+		File:     e.File,
+		Track:    e.Track,
+		PeakID:   e.PeakID,
+		PeakName: e.PeakName,
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Separate from -approval_addr since the feed is meant to be shared while
+	// the approval page must stay private.
+	feedAddr    = flag.String("feed_addr", "", "In daemon mode, serve an Atom feed of recently added ascents at /feed.atom on this address, e.g. :8081")
+	feedTitle   = flag.String("feed_title", "Peakbagger ascents", "Title of the -feed_addr feed")
+	feedEntries = flag.Int("feed_entries", 20, "Number of recent ascents in the -feed_addr feed")
+	feedMapURL  = flag.String("feed_map_url", "https://www.openstreetmap.org/?mlat={lat}&mlon={lng}#map=13/{lat}/{lng}", "Map link for each feed entry, with {lat} and {lng} replaced by the peak location")
+	// There is no free static map service to default to.
+	feedThumbnailURL = flag.String("feed_thumbnail_url", "", "Map thumbnail image URL shown in each feed entry, with {lat} and {lng} replaced by the peak location, e.g. from a static map API")
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Updated   string   `xml:"updated"`
+	Published string   `xml:"published"`
+	Link      atomLink `xml:"link"`
+	Content   atomText `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Directories whose manifests the feed covers.
+func feedDirectories() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	add(*inputDirectory)
+	for _, s := range schedules {
+		add(s.Directory)
+	}
+	return dirs
+}
+
+// Replaces {lat} and {lng} in a URL template.
+func mapURL(template string, lat, lng float64) string {
+	return strings.NewReplacer("{lat}", fmt.Sprintf("%.5f", lat), "{lng}", fmt.Sprintf("%.5f", lng)).Replace(template)
+}
+
+// Builds the feed from the most recently added ascents, leaving out private
+// ascents and those in -hide_gpx_region.
+func buildFeed(dirs []string, self string) (*atomFeed, error) {
+	var ascents []*ManifestEntry
+	for _, dir := range dirs {
+		m, err := LoadManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range m.Ascents {
+			// The feed is public, unlike private ascents and those in
+			// sensitive locations.
+			if !a.Private && !a.Hidden {
+				ascents = append(ascents, a)
+			}
+		}
+	}
+	sort.SliceStable(ascents, func(i, j int) bool {
+		return ascents[i].Added.After(ascents[j].Added)
+	})
+	if len(ascents) > *feedEntries {
+		ascents = ascents[:*feedEntries]
+	}
+
+	feed := &atomFeed{
+		ID:      self,
+		Title:   *feedTitle,
+		Updated: outputNow().UTC().Format(time.RFC3339),
+		Author:  programName,
+		Link:    atomLink{Href: self, Rel: "self"},
+	}
+	if len(ascents) > 0 {
+		feed.Updated = ascents[0].Added.UTC().Format(time.RFC3339)
+	}
+	for _, a := range ascents {
+		name := a.PeakName
+		if name == "" {
+			name = "Peak " + a.PeakID
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        ascentURL(a.AscentID),
+			Title:     fmt.Sprintf("%s on %s", name, a.Date.Local().Format("2006-01-02")),
+			Updated:   a.Added.UTC().Format(time.RFC3339),
+			Published: a.Added.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: ascentURL(a.AscentID), Rel: "alternate", Type: "text/html"},
+			Content:   atomText{Type: "html", Body: feedContent(a)},
+		})
+	}
+	return feed, nil
+}
+
+// HTML content of a feed entry, escaped by the XML encoder.
+func feedContent(a *ManifestEntry) string {
+	var b strings.Builder
+	if stats := a.Stats(); stats != "" {
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(stats))
+	}
+	if a.Latitude == 0 && a.Longitude == 0 {
+		return b.String()
+	}
+	link := html.EscapeString(mapURL(*feedMapURL, a.Latitude, a.Longitude))
+	if *feedThumbnailURL != "" {
+		img := html.EscapeString(mapURL(*feedThumbnailURL, a.Latitude, a.Longitude))
+		fmt.Fprintf(&b, `<p><a href="%s"><img src="%s" alt="Map"></a></p>`, link, img)
+	} else {
+		fmt.Fprintf(&b, `<p><a href="%s">Map</a></p>`, link)
+	}
+	return b.String()
+}
+
+func feedHandler(dirs []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.atom", func(w http.ResponseWriter, r *http.Request) {
+		self := "http://" + r.Host + r.URL.Path
+		feed, err := buildFeed(dirs, self)
+		if err != nil {
+			log.Errorf("Failed to build feed: %v", err)
+			http.Error(w, "feed unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", " ")
+		if err := enc.Encode(feed); err != nil {
+			log.Errorf("Failed to write feed: %v", err)
+		}
+	})
+	return mux
+}
+
+// Starts serving the Atom feed. Directories are fixed at startup, since
+// scheduled scans switch -directory while they run.
+func StartFeedServer() error {
+	dirs := feedDirectories()
+	ln, err := net.Listen("tcp", *feedAddr)
+	if err != nil {
+		return fmt.Errorf("feed server %w", err)
+	}
+	log.Infof("Serving ascent feed on http://%v/feed.atom", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, feedHandler(dirs)); err != nil {
+			log.Errorf("Feed server stopped: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	afterDate  = flag.String("after", "", "Only process tracks starting on or after this date (YYYY-MM-DD)")
+	beforeDate = flag.String("before", "", "Only process tracks starting before this date (YYYY-MM-DD)")
+)
+
+var after, before time.Time
+
+// Parses -after and -before.
+func LoadDateFilters() error {
+	var err error
+	if *afterDate != "" {
+		if after, err = time.ParseInLocation("2006-01-02", *afterDate, time.Local); err != nil {
+			return fmt.Errorf("-after %w", err)
+		}
+	}
+	if *beforeDate != "" {
+		if before, err = time.ParseInLocation("2006-01-02", *beforeDate, time.Local); err != nil {
+			return fmt.Errorf("-before %w", err)
+		}
+	}
+	return nil
+}
+
+// Whether any track filter is set.
+func trackFiltersSet() bool {
+	return geofence != nil || !after.IsZero() || !before.IsZero()
+}
+
+// Whether a track starting at the given time is within -after and -before.
+// Tracks without times are kept.
+func inDateRange(start time.Time) bool {
+	if start.IsZero() {
+		return true
+	}
+	return (after.IsZero() || !start.Before(after)) && (before.IsZero() || start.Before(before))
+}
+
+// Whether a track passes -geofence, -after and -before, logging why not.
+func TrackSelected(t *gpx.GPXTrack) bool {
+	if !inDateRange(t.TimeBounds().StartTime) {
+		log.Infof("Skipping track %q outside -after/-before", t.Name)
+		return false
+	}
+	if !InGeofence(t) {
+		log.Infof("Skipping track %q outside -geofence", t.Name)
+		return false
+	}
+	return true
+}
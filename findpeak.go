@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Implemented by drivers that can look up a peak's location without
+// fetching its page.
+type PeakLocator interface {
+	LocatePeak(peakID string) (name string, lat, lng float64, err error)
+}
+
+// Returns the name and location of a peak by ID.
+func (u *Uploader) locatePeak(peakID string) (string, float64, float64, error) {
+	if l, ok := u.client.(PeakLocator); ok {
+		return l.LocatePeak(peakID)
+	}
+	info, err := u.PeakInfo(peakID)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if info.Latitude == 0 && info.Longitude == 0 {
+		return "", 0, 0, fmt.Errorf("no location on the page of peak %s", peakID)
+	}
+	return info.Name, info.Latitude, info.Longitude, nil
+}
+
+// Lists archive tracks that summit a peak, most recent first: find-peak
+// -peak_id N, find-peak -search text [-state ST] to look the peak up by name
+// on Peakbagger, or find-peak -name text to search peaks matched on upload.
+func (u *Uploader) FindPeak(args []string) error {
+	fs := flag.NewFlagSet("find-peak", flag.ContinueOnError)
+	peakID := fs.Int("peak_id", 0, "Peakbagger peak ID")
+	name := fs.String("name", "", "Peak name, matched against peaks recorded in the index on upload")
+	search := fs.String("search", "", "Peak name to look up on Peakbagger")
+	state := fs.String("state", "", "State or region narrowing -search, e.g. WA")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	given := 0
+	for _, set := range []bool{*peakID != 0, *name != "", *search != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return fmt.Errorf("usage: find-peak -peak_id N | -search text [-state ST] | -name text")
+	}
+
+	var located *peakbagger.Peak
+	if *search != "" {
+		results, err := u.SearchPeaks(*search, *state)
+		if err != nil {
+			return fmt.Errorf("search peaks %w", err)
+		}
+		switch {
+		case len(results) == 0:
+			return fmt.Errorf("no peaks named %q found", *search)
+		case len(results) > 1 && !*interactive:
+			for i := range results {
+				fmt.Println(results[i].String())
+			}
+			return fmt.Errorf("%d peaks match %q, narrow with -state or use -peak_id", len(results), *search)
+		}
+		r := results[0]
+		if len(results) > 1 {
+			var options []string
+			for i := range results {
+				options = append(options, results[i].String())
+			}
+			r = results[choose("Matching peaks:", options)]
+		}
+		if located, err = u.locateResult(r); err != nil {
+			return err
+		}
+	}
+
+	idx, err := LoadIndex(u.dir)
+	if err != nil {
+		return err
+	}
+	if len(idx.Files) == 0 {
+		log.Warnf("No index in %q, building one first", u.dir)
+		if err := UpdateIndex(u.dir); err != nil {
+			return err
+		}
+		if idx, err = LoadIndex(u.dir); err != nil {
+			return err
+		}
+	}
+
+	type match struct {
+		date     time.Time
+		file     string
+		track    string
+		distance float64
+	}
+	var matches []match
+
+	if *name != "" {
+		for file, f := range idx.Files {
+			if !containsFold(f.Peaks, *name) {
+				continue
+			}
+			for _, t := range f.Tracks {
+				matches = append(matches, match{date: t.Start, file: file, track: t.Name, distance: -1})
+			}
+		}
+	} else {
+		var peakName string
+		var lat, lng float64
+		if located != nil {
+			peakName, lat, lng = located.Name, located.Latitude, located.Longitude
+		} else {
+			id := strconv.Itoa(*peakID)
+			var err error
+			if peakName, lat, lng, err = u.locatePeak(id); err != nil {
+				return fmt.Errorf("locate peak %s %w", id, err)
+			}
+		}
+		log.Infof("Searching for tracks within %.0fm of %q", peakSearchRadius, peakName)
+
+		// Degrees spanned by the search radius, for the bounding box precheck.
+		dLat := peakSearchRadius / earthRadius * 180 / math.Pi
+		dLng := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+
+		for file, f := range idx.Files {
+			near := false
+			for _, t := range f.Tracks {
+				if lat+dLat >= t.MinLat && lat-dLat <= t.MaxLat && lng+dLng >= t.MinLng && lng-dLng <= t.MaxLng {
+					near = true
+				}
+			}
+			if !near {
+				continue
+			}
+			g, err := LoadGPX(filepath.Join(u.dir, file))
+			if err != nil {
+				log.Warnf("Skipping %q: %v", file, err)
+				continue
+			}
+			for _, gt := range g.Tracks {
+				for _, t := range SplitTrack(gt, *splitGap) {
+					best := math.Inf(1)
+					var when time.Time
+					for _, p := range trackPoints(&t) {
+						if d := Distance(p.Latitude, p.Longitude, lat, lng); d < best {
+							best, when = d, p.Timestamp
+						}
+					}
+					if best <= peakSearchRadius {
+						matches = append(matches, match{date: when, file: file, track: t.Name, distance: best})
+					}
+				}
+			}
+			releaseMemory()
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].date.After(matches[j].date)
+	})
+	for _, m := range matches {
+		line := fmt.Sprintf("%s\t%s\t%q", m.date.Local().Format("2006-01-02"), m.file, m.track)
+		if m.distance >= 0 {
+			line += fmt.Sprintf("\t%.0fm from summit", m.distance)
+		}
+		fmt.Println(line)
+	}
+	if len(matches) == 0 {
+		fmt.Println("No tracks found")
+	}
+	return nil
+}
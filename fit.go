@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	fitElevation = flag.String("fit_elevation", "barometric", "Elevation source for FIT files: barometric or gps")
+	fitCalibrate = flag.Bool("fit_calibrate", true, "Calibrate barometric drift in FIT files against GPS altitude at the start and end, and, with the peak_info enrichment, the matched peak's listed elevation at the summit")
+)
+
+const (
+	fitMesgRecord      = 20
+	fitMesgGPSMetadata = 160
+
+	fitFieldTimestamp = 253
+
+	// Seconds between the unix epoch and the FIT epoch (1989-12-31 00:00:00 UTC).
+	fitEpoch = 631065600
+)
+
+// A single sample decoded from a FIT file.
+type fitRecord struct {
+	Time     time.Time
+	Lat, Lng float64
+	HasPos   bool
+
+	// Barometric (or device fused) altitude from record messages.
+	Altitude    float64
+	HasAltitude bool
+
+	HeartRate float64
+}
+
+// A GPS altitude sample from gps_metadata messages.
+type fitGPSAltitude struct {
+	Time     time.Time
+	Altitude float64
+}
+
+type fitFieldDef struct {
+	num, size, baseType byte
+}
+
+type fitDefinition struct {
+	bigEndian bool
+	global    uint16
+	fields    []fitFieldDef
+	devSize   int
+}
+
+type fitDecoder struct {
+	r    *bufio.Reader
+	defs [16]*fitDefinition
+
+	// Last full timestamp, for compressed timestamp headers.
+	lastTimestamp uint32
+
+	records []fitRecord
+	gpsAlts []fitGPSAltitude
+}
+
+// Decodes a FIT activity file into a GPX with a single track.
+func ReadFIT(filename string) (*gpx.GPX, error) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &fitDecoder{r: bufio.NewReader(f)}
+	if err := d.decode(); err != nil {
+		return nil, fmt.Errorf("decode fit %w", err)
+	}
+	if len(d.records) == 0 {
+		return nil, fmt.Errorf("fit file has no records")
+	}
+
+	d.applyElevationSource()
+
+	seg := gpx.GPXTrackSegment{}
+	for _, r := range d.records {
+		if !r.HasPos {
+			continue
+		}
+		p := gpx.GPXPoint{Timestamp: r.Time}
+		p.Latitude = r.Lat
+		p.Longitude = r.Lng
+		if r.HasAltitude {
+			p.Elevation = *gpx.NewNullableFloat64(r.Altitude)
+		}
+		if r.HeartRate > 0 {
+			setPointHeartRate(&p, r.HeartRate)
+		}
+		seg.Points = append(seg.Points, p)
+	}
+
+	return &gpx.GPX{
+		Creator: "FIT",
+		Tracks: []gpx.GPXTrack{{
+			Segments: []gpx.GPXTrackSegment{seg},
+		}},
+	}, nil
+}
+
+// Replaces record altitudes according to -fit_elevation and -fit_calibrate.
+func (d *fitDecoder) applyElevationSource() {
+	if len(d.gpsAlts) == 0 {
+		if *fitElevation == "gps" {
+			log.Warnf("FIT file has no GPS altitude, using barometric")
+		}
+		return
+	}
+	sort.Slice(d.gpsAlts, func(i, j int) bool {
+		return d.gpsAlts[i].Time.Before(d.gpsAlts[j].Time)
+	})
+
+	switch *fitElevation {
+	case "gps":
+		for i := range d.records {
+			if alt, ok := d.gpsAltitudeAt(d.records[i].Time); ok {
+				d.records[i].Altitude = alt
+				d.records[i].HasAltitude = true
+			}
+		}
+	case "barometric":
+		if *fitCalibrate {
+			d.calibrateDrift()
+		}
+	default:
+		log.Warnf("Unknown -fit_elevation %q, using barometric", *fitElevation)
+	}
+}
+
+// Returns the GPS altitude sampled at the given time, if any.
+func (d *fitDecoder) gpsAltitudeAt(t time.Time) (float64, bool) {
+	i := sort.Search(len(d.gpsAlts), func(i int) bool {
+		return !d.gpsAlts[i].Time.Before(t)
+	})
+	if i < len(d.gpsAlts) && d.gpsAlts[i].Time.Sub(t) < 2*time.Second {
+		return d.gpsAlts[i].Altitude, true
+	}
+	if i > 0 && t.Sub(d.gpsAlts[i-1].Time) < 2*time.Second {
+		return d.gpsAlts[i-1].Altitude, true
+	}
+	return 0, false
+}
+
+// Corrects barometric drift (weather changes over the day) with a linear
+// offset between the GPS-derived offsets at the start and end of the track.
+// GPS altitude is noisy but unbiased, so the median over a window is a
+// reasonable reference.
+func (d *fitDecoder) calibrateDrift() {
+	const window = 10 * time.Minute
+
+	first, last := d.records[0].Time, d.records[len(d.records)-1].Time
+	var startOffsets, endOffsets []float64
+	for _, r := range d.records {
+		if !r.HasAltitude {
+			continue
+		}
+		alt, ok := d.gpsAltitudeAt(r.Time)
+		if !ok {
+			continue
+		}
+		if r.Time.Sub(first) < window {
+			startOffsets = append(startOffsets, alt-r.Altitude)
+		}
+		if last.Sub(r.Time) < window {
+			endOffsets = append(endOffsets, alt-r.Altitude)
+		}
+	}
+	if len(startOffsets) == 0 || len(endOffsets) == 0 {
+		return
+	}
+
+	start, end := median(startOffsets), median(endOffsets)
+	log.Infof("Calibrating barometric altitude, offset %.1fm at start and %.1fm at end", start, end)
+
+	span := last.Sub(first).Seconds()
+	for i := range d.records {
+		frac := 0.0
+		if span > 0 {
+			frac = d.records[i].Time.Sub(first).Seconds() / span
+		}
+		d.records[i].Altitude += start + (end-start)*frac
+	}
+}
+
+// Calibrates a barometric FIT track against the matched peak's listed
+// elevation, see calibrateToPeak. Returns whether the track changed. The
+// elevation is on the peak page, so only fetched with the peak_info
+// enrichment; otherwise the drift calibration stands alone.
+func (u *Uploader) calibrateFIT(src *Source, t *gpx.GPXTrack, summit *gpx.GPXPoint, peakID peakbagger.PeakID) bool {
+	if src.Format != extToGPSBabelFormat[".fit"] || *fitElevation != "barometric" || !*fitCalibrate {
+		return false
+	}
+	if !Enriching("peak_info") {
+		log.Debugf("Not calibrating against the peak elevation without the peak_info enrichment")
+		return false
+	}
+	info, err := u.PeakInfo(fmt.Sprint(peakID))
+	if err != nil {
+		log.Debugf("Not calibrating against the peak elevation: %v", err)
+		return false
+	}
+	if info.Elevation <= 0 {
+		return false
+	}
+	delta := calibrateToPeak(t, summit, info.Elevation)
+	if delta == 0 {
+		return false
+	}
+	log.Infof("Calibrating barometric altitude to %q elevation %.0fm, offset %.1fm at the summit", info.Name, info.Elevation, delta)
+	return true
+}
+
+// Shifts a track's altitude so the summit is at the peak's elevation. The
+// correction tapers linearly to nothing at the start and end, which
+// calibrateDrift anchored to GPS altitude. Differences beyond
+// -peak_elevation_tolerance are left alone, since they suggest a wrong
+// match rather than drift. Returns the correction at the summit.
+func calibrateToPeak(t *gpx.GPXTrack, summit *gpx.GPXPoint, peakElevation float64) float64 {
+	delta := peakElevation - summit.Elevation.Value()
+	if delta == 0 || math.Abs(delta) > *peakElevationTolerance {
+		return 0
+	}
+	times := t.TimeBounds()
+	up := summit.Timestamp.Sub(times.StartTime).Seconds()
+	down := times.EndTime.Sub(summit.Timestamp).Seconds()
+	for i := range t.Segments {
+		for j := range t.Segments[i].Points {
+			p := &t.Segments[i].Points[j]
+			if p.Elevation.Null() || p.Timestamp.IsZero() {
+				continue
+			}
+			frac := 1.0
+			switch dt := p.Timestamp.Sub(summit.Timestamp).Seconds(); {
+			case dt < 0 && up > 0:
+				frac = 1 + dt/up
+			case dt > 0 && down > 0:
+				frac = 1 - dt/down
+			}
+			frac = math.Max(0, math.Min(1, frac))
+			p.Elevation = *gpx.NewNullableFloat64(p.Elevation.Value() + delta*frac)
+		}
+	}
+	summit.Elevation = *gpx.NewNullableFloat64(peakElevation)
+	return delta
+}
+
+func median(v []float64) float64 {
+	s := append([]float64(nil), v...)
+	sort.Float64s(s)
+	return s[len(s)/2]
+}
+
+func (d *fitDecoder) decode() error {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(d.r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[8:12]) != ".FIT" {
+		return errors.New("missing .FIT signature")
+	}
+	if hdr[0] > 12 {
+		if _, err := d.r.Discard(int(hdr[0]) - 12); err != nil {
+			return err
+		}
+	}
+
+	remaining := int(binary.LittleEndian.Uint32(hdr[4:8]))
+	for remaining > 0 {
+		n, err := d.readMessage()
+		if err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// Reads one definition or data message, returning the number of bytes read.
+func (d *fitDecoder) readMessage() (int, error) {
+	h, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if h&0x80 != 0 {
+		// Compressed timestamp header.
+		local := (h >> 5) & 0x3
+		offset := uint32(h & 0x1f)
+		ts := (d.lastTimestamp &^ 0x1f) + offset
+		if offset < d.lastTimestamp&0x1f {
+			ts += 0x20
+		}
+		d.lastTimestamp = ts
+		n, err := d.readData(d.defs[local], &ts)
+		return n + 1, err
+	}
+
+	local := h & 0x0f
+	if h&0x40 != 0 {
+		n, err := d.readDefinition(local, h&0x20 != 0)
+		return n + 1, err
+	}
+	n, err := d.readData(d.defs[local], nil)
+	return n + 1, err
+}
+
+func (d *fitDecoder) readDefinition(local byte, dev bool) (int, error) {
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return 0, err
+	}
+	def := &fitDefinition{bigEndian: b[1] == 1}
+	if def.bigEndian {
+		def.global = binary.BigEndian.Uint16(b[2:4])
+	} else {
+		def.global = binary.LittleEndian.Uint16(b[2:4])
+	}
+	n := 5
+
+	fields := make([]byte, 3*int(b[4]))
+	if _, err := io.ReadFull(d.r, fields); err != nil {
+		return 0, err
+	}
+	n += len(fields)
+	for i := 0; i < len(fields); i += 3 {
+		def.fields = append(def.fields, fitFieldDef{fields[i], fields[i+1], fields[i+2]})
+	}
+
+	if dev {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		devFields := make([]byte, 3*int(c))
+		if _, err := io.ReadFull(d.r, devFields); err != nil {
+			return 0, err
+		}
+		n += 1 + len(devFields)
+		for i := 0; i < len(devFields); i += 3 {
+			def.devSize += int(devFields[i+1])
+		}
+	}
+
+	d.defs[local] = def
+	return n, nil
+}
+
+func (d *fitDecoder) readData(def *fitDefinition, ts *uint32) (int, error) {
+	if def == nil {
+		return 0, errors.New("data message without definition")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if def.bigEndian {
+		order = binary.BigEndian
+	}
+
+	values := make(map[byte]uint32)
+	n := 0
+	for _, f := range def.fields {
+		b := make([]byte, f.size)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return 0, err
+		}
+		n += len(b)
+		switch f.size {
+		case 1:
+			values[f.num] = uint32(b[0])
+		case 2:
+			values[f.num] = uint32(order.Uint16(b))
+		case 4:
+			values[f.num] = order.Uint32(b)
+		}
+	}
+	if def.devSize > 0 {
+		if _, err := d.r.Discard(def.devSize); err != nil {
+			return 0, err
+		}
+		n += def.devSize
+	}
+
+	if v, ok := values[fitFieldTimestamp]; ok {
+		d.lastTimestamp = v
+		ts = &v
+	}
+	if ts == nil {
+		return n, nil
+	}
+	t := time.Unix(int64(*ts)+fitEpoch, 0).UTC()
+
+	switch def.global {
+	case fitMesgRecord:
+		r := fitRecord{Time: t}
+		lat, latOK := values[0]
+		lng, lngOK := values[1]
+		if latOK && lngOK && lat != 0x7fffffff && lng != 0x7fffffff {
+			r.Lat = semicirclesToDegrees(lat)
+			r.Lng = semicirclesToDegrees(lng)
+			r.HasPos = true
+		}
+		if v, ok := values[78]; ok && v != 0xffffffff {
+			r.Altitude, r.HasAltitude = float64(v)/5-500, true
+		} else if v, ok := values[2]; ok && v != 0xffff {
+			r.Altitude, r.HasAltitude = float64(v)/5-500, true
+		}
+		if v, ok := values[3]; ok && v != 0xff {
+			r.HeartRate = float64(v)
+		}
+		d.records = append(d.records, r)
+	case fitMesgGPSMetadata:
+		if v, ok := values[3]; ok && v != 0xffffffff {
+			d.gpsAlts = append(d.gpsAlts, fitGPSAltitude{Time: t, Altitude: float64(v)/5 - 500})
+		}
+	}
+	return n, nil
+}
+
+func semicirclesToDegrees(v uint32) float64 {
+	return float64(int32(v)) * 180 / math.Pow(2, 31)
+}
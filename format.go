@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	inputFormat = flag.String("format", "", "Read input files as this format regardless of their extension, e.g. gpx for a GPX saved as .kml. A file's own override can be given in a sidecar named <file>"+formatSidecarExt+" holding the format")
+)
+
+// Extension of per-file format override sidecars.
+const formatSidecarExt = ".format"
+
+// Checks a format name, a supported extension without the dot.
+func checkFormat(format string) error {
+	if _, ok := extToGPSBabelFormat["."+format]; !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
+
+// The format a file is forced to by its sidecar or -format, or "" to go by
+// its extension.
+func formatOverride(filename string) (string, error) {
+	b, err := os.ReadFile(longPath(filename + formatSidecarExt))
+	if err == nil {
+		format := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(string(b)), "."))
+		if err := checkFormat(format); err != nil {
+			return "", fmt.Errorf("%s%s: %w", filepath.Base(filename), formatSidecarExt, err)
+		}
+		return format, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if *inputFormat != "" {
+		if err := checkFormat(*inputFormat); err != nil {
+			return "", fmt.Errorf("-format: %w", err)
+		}
+		return *inputFormat, nil
+	}
+	return "", nil
+}
+
+// Whether a file in the input directory has a format override sidecar, so it
+// is read even without a supported extension.
+func hasFormatSidecar(dir, name string) bool {
+	_, err := os.Stat(longPath(filepath.Join(dir, name+formatSidecarExt)))
+	return err == nil
+}
+
+// Returns a file ready to decode by its extension: gunzipped, and under the
+// extension of its format if that differs. The format is its override if it
+// has one, otherwise what its content looks like, falling back to its
+// extension. Warns when the content looks unlike an override. The returned
+// func removes temporary files.
+func prepareTrack(filename string) (string, func(), error) {
+	format, err := formatOverride(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	filename, cleanup, err := gunzipTrack(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	sniffed := sniffFormat(filename)
+	if format == "" && sniffed != "" && extToGPSBabelFormat[sniffed] != extToGPSBabelFormat[ext] {
+		format = strings.TrimPrefix(sniffed, ".")
+	}
+	if format != "" && extToGPSBabelFormat["."+format] != extToGPSBabelFormat[ext] {
+		log.Infof("Reading %q as %s", filepath.Base(filename), format)
+		renamed, err := copyWithExt(filename, "."+format)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		gunzipped := cleanup
+		cleanup = func() {
+			os.RemoveAll(filepath.Dir(renamed))
+			gunzipped()
+		}
+		filename, ext = renamed, "."+format
+	}
+	if sniffed != "" && extToGPSBabelFormat[sniffed] != extToGPSBabelFormat[ext] {
+		log.Warnf("%q looks like a %s file, not %s as overridden", filepath.Base(filename), sniffed, ext)
+	}
+	return filename, cleanup, nil
+}
+
+// Copies a file into a temporary directory under a different extension.
+func copyWithExt(filename, ext string) (string, error) {
+	in, err := os.Open(longPath(filename))
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	out := filepath.Join(tmp, strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))+ext)
+	if err := saveDownload(in, out); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return out, nil
+}
+
+// Guesses a file's format from its first bytes, returning its extension or
+// "" if it isn't recognized.
+func sniffFormat(filename string) string {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	head = head[:n]
+
+	switch {
+	case len(head) >= 12 && string(head[8:12]) == ".FIT":
+		return ".fit"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		// Only a KMZ if it leads with its KML, other zips are archives.
+		if len(head) >= 30 {
+			n := int(head[26]) | int(head[27])<<8
+			if 30+n <= len(head) && strings.EqualFold(path.Ext(string(head[30:30+n])), ".kml") {
+				return ".kmz"
+			}
+		}
+		return ""
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(text, []byte("<")):
+		switch xmlRootElement(text) {
+		case "gpx":
+			return ".gpx"
+		case "kml":
+			return ".kml"
+		case "TrainingCenterDatabase":
+			return ".tcx"
+		}
+	case bytes.HasPrefix(text, []byte("{")):
+		// Unlike the JSON files this tool keeps next to tracks.
+		if bytes.Contains(text, []byte(`"type"`)) && (bytes.Contains(text, []byte(`"Feature`)) || bytes.Contains(text, []byte(`"coordinates"`))) {
+			return ".geojson"
+		}
+	case bytes.HasPrefix(text, []byte("$GP")), bytes.HasPrefix(text, []byte("$GN")):
+		return ".nmea"
+	case bytes.HasPrefix(text, []byte("A")) && bytes.Contains(text, []byte("\nHF")):
+		return ".igc"
+	}
+	return ""
+}
+
+// Name of the first element in an XML prefix, skipping the declaration,
+// comments and doctype.
+func xmlRootElement(text []byte) string {
+	for {
+		i := bytes.IndexByte(text, '<')
+		if i < 0 || i+1 >= len(text) {
+			return ""
+		}
+		text = text[i+1:]
+		if text[0] == '?' || text[0] == '!' {
+			continue
+		}
+		end := bytes.IndexAny(text, " \t\r\n/>")
+		if end < 0 {
+			return ""
+		}
+		name := string(text[:end])
+		// Drop a namespace prefix, e.g. gpx:gpx.
+		if j := strings.IndexByte(name, ':'); j >= 0 {
+			name = name[j+1:]
+		}
+		return name
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	gaiaExport = flag.String("gaia_export", "", "Upload each saved track of a Gaia GPS export, a GPX file or a folder of them such as a synced folder exported again as it grows. Tracks are recorded in the history one by one and their titles hint which peak was climbed. History is kept in -directory, by default next to the export")
+)
+
+// A saved track in a Gaia GPS export.
+type GaiaTrack struct {
+	Title string
+}
+
+func (t *GaiaTrack) apply(src *Source) {
+	src.Type = "gaia"
+	src.ActivityName = t.Title
+}
+
+// Lists the GPX files of a Gaia export, a file or a folder.
+func gaiaExportFiles() ([]string, error) {
+	fi, err := os.Stat(longPath(*gaiaExport))
+	if err != nil {
+		return nil, fmt.Errorf("gaia export %w", err)
+	}
+	if !fi.IsDir() {
+		return []string{*gaiaExport}, nil
+	}
+	entries, err := ioutil.ReadDir(longPath(*gaiaExport))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".gpx") {
+			files = append(files, filepath.Join(*gaiaExport, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// Uploads the tracks of -gaia_export. An export holds many tracks per file
+// and is replaced when exported again, so each track is recorded in the
+// history as "<file>/<start time>" rather than by file.
+func (u *Uploader) RunGaiaExport() error {
+	if u.dir == "" {
+		if fi, err := os.Stat(longPath(*gaiaExport)); err == nil && fi.IsDir() {
+			u.dir = *gaiaExport
+		} else {
+			u.dir = filepath.Dir(*gaiaExport)
+		}
+	}
+	if err := u.LoadHistory(); err != nil {
+		return err
+	}
+	files, err := gaiaExportFiles()
+	if err != nil {
+		return err
+	}
+
+	u.activities = make(map[string]activityMetadata)
+	count := 0
+	for _, filename := range files {
+		g, err := LoadGPX(filename)
+		if err != nil {
+			log.Errorf("Failed to read %q: %v", filename, err)
+			continue
+		}
+		for _, t := range g.Tracks {
+			start := t.TimeBounds().StartTime
+			if start.IsZero() {
+				log.Infof("Skipping track %q without times", t.Name)
+				continue
+			}
+			count++
+			t := t
+			name := path.Join(filepath.Base(filename), start.UTC().Format(time.RFC3339))
+			u.activities[name] = &GaiaTrack{Title: t.Name}
+			err := u.processInput(name, nil, func() error {
+				single := *g
+				single.Tracks = []gpx.GPXTrack{t}
+				tmp, err := writeTempGPX(&single, "track.gpx")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(filepath.Dir(tmp))
+				return u.uploadRecovering(tmp, name)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("Processed %d tracks in the Gaia export", count)
+	return nil
+}
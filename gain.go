@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	gainAlgorithm = flag.String("gain_algorithm", "hysteresis", "Elevation gain algorithm: hysteresis, smoothed or dem")
+	gainThreshold = flag.Float64("gain_threshold", 5, "Elevation change in meters required before the hysteresis gain algorithm counts a climb")
+	gainSmoothing = flag.Int("gain_smoothing", 9, "Number of points in the moving average used by the smoothed gain algorithm")
+)
+
+var errNoDEM = errors.New("no DEM available")
+
+// Computes total elevation gain in meters over a sequence of points.
+type GainFunc func(points []gpx.GPXPoint) (float64, error)
+
+// Available gain algorithms, keyed by -gain_algorithm name.
+//
+// hysteresis (the default) only counts a climb once elevation has moved more
+// than -gain_threshold from the last turning point, which rejects GPS and
+// barometer noise while preserving real climbs. This is closest to what most
+// watches report.
+//
+// smoothed applies a centered moving average then sums positive differences.
+// It is less sensitive to the threshold choice but flattens short steep
+// bumps.
+//
+// dem discards recorded elevations and resamples them from a digital
+// elevation model, which avoids device errors entirely but underestimates
+// gain along ridges narrower than the DEM resolution.
+var gainAlgorithms = map[string]GainFunc{
+	"hysteresis": hysteresisGain,
+	"smoothed":   smoothedGain,
+	"dem":        demGain,
+}
+
+func elevations(points []gpx.GPXPoint) []float64 {
+	var e []float64
+	for _, p := range points {
+		if p.Elevation.NotNull() {
+			e = append(e, p.Elevation.Value())
+		}
+	}
+	return e
+}
+
+func hysteresisGain(points []gpx.GPXPoint) (float64, error) {
+	e := elevations(points)
+	if len(e) == 0 {
+		return 0, nil
+	}
+	gain := 0.0
+	ref := e[0]
+	for _, v := range e[1:] {
+		if v-ref >= *gainThreshold {
+			gain += v - ref
+			ref = v
+		} else if ref-v >= *gainThreshold {
+			ref = v
+		}
+	}
+	return gain, nil
+}
+
+func smoothedGain(points []gpx.GPXPoint) (float64, error) {
+	e := elevations(points)
+	half := *gainSmoothing / 2
+	smoothed := make([]float64, len(e))
+	for i := range e {
+		lo, hi := i-half, i+half+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(e) {
+			hi = len(e)
+		}
+		sum := 0.0
+		for _, v := range e[lo:hi] {
+			sum += v
+		}
+		smoothed[i] = sum / float64(hi-lo)
+	}
+
+	gain := 0.0
+	for i := 1; i < len(smoothed); i++ {
+		if d := smoothed[i] - smoothed[i-1]; d > 0 {
+			gain += d
+		}
+	}
+	return gain, nil
+}
+
+// Points resampled by the dem algorithm, since lookups may be a request each.
+const demGainSamples = 500
+
+func demGain(points []gpx.GPXPoint) (float64, error) {
+	resampled, err := demElevations(reducePoints(points, demGainSamples))
+	if err != nil {
+		return 0, err
+	}
+	return hysteresisGain(resampled)
+}
+
+// Computes elevation gain with the configured algorithm. When verbose logging
+// is enabled all algorithms are logged to help explain discrepancies.
+func ComputeGain(points []gpx.GPXPoint) (float64, error) {
+	if log.IsLevelEnabled(log.DebugLevel) {
+		var names []string
+		for name := range gainAlgorithms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if g, err := gainAlgorithms[name](points); err != nil {
+				log.Debugf("Gain (%s): %v", name, err)
+			} else {
+				log.Debugf("Gain (%s): %.0fm", name, g)
+			}
+		}
+	}
+
+	f, ok := gainAlgorithms[*gainAlgorithm]
+	if !ok {
+		return 0, fmt.Errorf("unknown gain algorithm %q", *gainAlgorithm)
+	}
+	return f(points)
+}
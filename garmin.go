@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	garminTypes = flag.String("garmin_types", "hiking,mountaineering,trail_running,running,walking,backcountry_skiing,backcountry_skiing_snowboarding_ws,snow_shoe_ws", "Comma separated Garmin Connect activity type keys to upload with -source=garmin")
+)
+
+var garminAPIURL = "https://connectapi.garmin.com"
+
+// Start times in the activity list, in UTC.
+const garminTimeLayout = "2006-01-02 15:04:05"
+
+type garminSource struct {
+	token string
+}
+
+func newGarminSource() (ActivitySource, error) {
+	token, err := OAuthAccessToken("garmin")
+	if err != nil {
+		return nil, err
+	}
+	return &garminSource{token: token}, nil
+}
+
+func (g *garminSource) Activities(limit int) ([]*RemoteActivity, error) {
+	resp, err := bearerGet(fmt.Sprintf("%s/activitylist-service/activities/search/activities?start=0&limit=%d", garminAPIURL, limit), g.token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list []struct {
+		ActivityID   int64  `json:"activityId"`
+		ActivityName string `json:"activityName"`
+		StartTimeGMT string `json:"startTimeGMT"`
+		ActivityType struct {
+			TypeKey string `json:"typeKey"`
+		} `json:"activityType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("parse activity list %w", err)
+	}
+	var activities []*RemoteActivity
+	for _, l := range list {
+		id := fmt.Sprint(l.ActivityID)
+		a := &RemoteActivity{
+			Source: "garmin",
+			ID:     id,
+			Name:   l.ActivityName,
+			Type:   l.ActivityType.TypeKey,
+			URL:    "https://connect.garmin.com/modern/activity/" + id,
+		}
+		if t, err := time.Parse(garminTimeLayout, l.StartTimeGMT); err == nil {
+			a.Start = t
+		}
+		activities = append(activities, a)
+	}
+	return activities, nil
+}
+
+func (g *garminSource) Selected(a *RemoteActivity) bool {
+	for _, t := range strings.Split(*garminTypes, ",") {
+		if strings.TrimSpace(t) == a.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Downloads the original file the device uploaded, a zip holding the FIT
+// file, so no detail is lost to Garmin's GPX export.
+func (g *garminSource) Download(a *RemoteActivity, dir string) (string, error) {
+	resp, err := bearerGet(fmt.Sprintf("%s/download-service/files/activity/%s", garminAPIURL, a.ID), g.token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	archive := filepath.Join(dir, a.ID+".zip")
+	if err := saveDownload(resp.Body, archive); err != nil {
+		return "", fmt.Errorf("download activity %s %w", a.ID, err)
+	}
+	defer os.Remove(archive)
+
+	zr, err := zip.OpenReader(longPath(archive))
+	if err != nil {
+		return "", fmt.Errorf("open activity %s %w", a.ID, err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if archiveEntrySupported(f) {
+			return extractArchiveEntry(f, dir)
+		}
+	}
+	return "", fmt.Errorf("activity %s has no track file", a.ID)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/track"
+)
+
+// Mean earth radius in meters.
+const earthRadius = 6371008.8
+
+// Returns bounds covering radius meters around a point. Longitude extent
+// grows with latitude, covers all longitudes near the poles, and bounds
+// crossing the antimeridian are split in two since bounds can't wrap.
+func SearchBounds(lat, lng, radius float64) []track.Bounds {
+	dLat := radius / earthRadius * 180 / math.Pi
+
+	minLat, maxLat := lat-dLat, lat+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		return []track.Bounds{{
+			MinLat: math.Max(minLat, -90),
+			MaxLat: math.Min(maxLat, 90),
+			MinLng: -180,
+			MaxLng: 180,
+		}}
+	}
+
+	dLng := dLat / math.Cos(lat*math.Pi/180)
+	if dLng >= 180 {
+		return []track.Bounds{{MinLat: minLat, MaxLat: maxLat, MinLng: -180, MaxLng: 180}}
+	}
+
+	minLng, maxLng := lng-dLng, lng+dLng
+	switch {
+	case minLng < -180:
+		return []track.Bounds{
+			{MinLat: minLat, MaxLat: maxLat, MinLng: minLng + 360, MaxLng: 180},
+			{MinLat: minLat, MaxLat: maxLat, MinLng: -180, MaxLng: maxLng},
+		}
+	case maxLng > 180:
+		return []track.Bounds{
+			{MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: 180},
+			{MinLat: minLat, MaxLat: maxLat, MinLng: -180, MaxLng: maxLng - 360},
+		}
+	}
+	return []track.Bounds{{MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: maxLng}}
+}
+
+// WGS84 ellipsoid parameters.
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+// Returns the geodesic distance in meters between two points on the WGS84
+// ellipsoid using Vincenty's inverse formula. Falls back to haversine for
+// nearly antipodal points where Vincenty fails to converge.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	if lat1 == lat2 && lng1 == lng2 {
+		return 0
+	}
+
+	rad := math.Pi / 180
+	L := (lng2 - lng1) * rad
+	U1 := math.Atan((1 - wgs84F) * math.Tan(lat1*rad))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(lat2*rad))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0
+		if cos2Alpha != 0 {
+			// Zero on the equator.
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-prev) < 1e-12 {
+			u2 := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+			A := 1 + u2/16384*(4096+u2*(-768+u2*(320-175*u2)))
+			B := u2 / 1024 * (256 + u2*(-128+u2*(74-47*u2)))
+			deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return wgs84B * A * (sigma - deltaSigma)
+		}
+	}
+	return haversine(lat1, lng1, lat2, lng2)
+}
+
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Distance between two GPX points in meters, ignoring elevation.
+func pointDistance(a, b *gpx.GPXPoint) float64 {
+	return Distance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"peakbagger-tools/pbtools/track"
+)
+
+func TestSearchBounds(t *testing.T) {
+	// Degrees of latitude spanned by 1000m.
+	dLat := 1000 / earthRadius * 180 / math.Pi
+	tests := []struct {
+		name     string
+		lat, lng float64
+		want     []track.Bounds
+	}{
+		{
+			name: "equator",
+			lat:  0, lng: 0,
+			want: []track.Bounds{{MinLat: -dLat, MaxLat: dLat, MinLng: -dLat, MaxLng: dLat}},
+		},
+		{
+			name: "east of the antimeridian",
+			lat:  0, lng: -179.995,
+			want: []track.Bounds{
+				{MinLat: -dLat, MaxLat: dLat, MinLng: -179.995 - dLat + 360, MaxLng: 180},
+				{MinLat: -dLat, MaxLat: dLat, MinLng: -180, MaxLng: -179.995 + dLat},
+			},
+		},
+		{
+			name: "west of the antimeridian",
+			lat:  0, lng: 179.995,
+			want: []track.Bounds{
+				{MinLat: -dLat, MaxLat: dLat, MinLng: 179.995 - dLat, MaxLng: 180},
+				{MinLat: -dLat, MaxLat: dLat, MinLng: -180, MaxLng: 179.995 + dLat - 360},
+			},
+		},
+		{
+			name: "on the antimeridian",
+			lat:  0, lng: 180,
+			want: []track.Bounds{
+				{MinLat: -dLat, MaxLat: dLat, MinLng: 180 - dLat, MaxLng: 180},
+				{MinLat: -dLat, MaxLat: dLat, MinLng: -180, MaxLng: -180 + dLat},
+			},
+		},
+		{
+			name: "high latitude",
+			lat:  60, lng: 10,
+			want: []track.Bounds{{MinLat: 60 - dLat, MaxLat: 60 + dLat, MinLng: 10 - 2*dLat, MaxLng: 10 + 2*dLat}},
+		},
+		{
+			name: "near the north pole",
+			lat:  89.995, lng: 45,
+			want: []track.Bounds{{MinLat: 89.995 - dLat, MaxLat: 90, MinLng: -180, MaxLng: 180}},
+		},
+		{
+			name: "north pole",
+			lat:  90, lng: 0,
+			want: []track.Bounds{{MinLat: 90 - dLat, MaxLat: 90, MinLng: -180, MaxLng: 180}},
+		},
+		{
+			name: "south pole",
+			lat:  -90, lng: 0,
+			want: []track.Bounds{{MinLat: -90, MaxLat: -90 + dLat, MinLng: -180, MaxLng: 180}},
+		},
+		{
+			name: "near the south pole across the antimeridian",
+			lat:  -89.995, lng: 179.999,
+			want: []track.Bounds{{MinLat: -90, MaxLat: -89.995 + dLat, MinLng: -180, MaxLng: 180}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SearchBounds(tt.lat, tt.lng, 1000)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchBounds(%v, %v) = %+v, want %+v", tt.lat, tt.lng, got, tt.want)
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				if !near(g.MinLat, w.MinLat, 1e-9) || !near(g.MaxLat, w.MaxLat, 1e-9) ||
+					!near(g.MinLng, w.MinLng, 1e-9) || !near(g.MaxLng, w.MaxLng, 1e-9) {
+					t.Errorf("SearchBounds(%v, %v)[%d] = %+v, want %+v", tt.lat, tt.lng, i, g, w)
+				}
+				if g.MinLat < -90 || g.MaxLat > 90 || g.MinLng < -180 || g.MaxLng > 180 || g.MinLng > g.MaxLng {
+					t.Errorf("SearchBounds(%v, %v)[%d] = %+v is out of range", tt.lat, tt.lng, i, g)
+				}
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want, tolerance        float64
+	}{
+		{"same point", 46.85, -121.76, 46.85, -121.76, 0, 0},
+		// One degree of longitude on the equator is a degree of the
+		// ellipsoid's major axis.
+		{"equator degree", 0, 0, 0, 1, wgs84A * math.Pi / 180, 0.001},
+		{"across the antimeridian", 0, 179.9995, 0, -179.9995, wgs84A * math.Pi / 180 / 1000, 0.001},
+		{"antimeridian as +180 and -180", 10, 180, 10, -180, 0, 0.001},
+		{"along the antimeridian", 45, 180, 46, -180, 111141.5, 1},
+		{"pole to 89 degrees", 90, 0, 89, 0, 111694.0, 1},
+		{"pole from any longitude", 90, 0, 90, 123, 0, 0.001},
+		{"across the north pole", 89.999, 0, 89.999, 180, 223.4, 0.5},
+		{"across the south pole", -89.999, -90, -89.999, 90, 223.4, 0.5},
+		{"pole to pole", 90, 0, -90, 0, 20003931.5, 1},
+		// Vincenty doesn't converge here, so this is the haversine fallback.
+		{"nearly antipodal", 0, 0, 0.5, 179.7, 19950277.3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Distance(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			if !near(got, tt.want, tt.tolerance) {
+				t.Errorf("Distance(%v, %v, %v, %v) = %.3f, want %.3f ±%v", tt.lat1, tt.lng1, tt.lat2, tt.lng2, got, tt.want, tt.tolerance)
+			}
+			if back := Distance(tt.lat2, tt.lng2, tt.lat1, tt.lng1); !near(back, got, 0.001) {
+				t.Errorf("Distance is not symmetric: %.3f one way, %.3f back", got, back)
+			}
+		})
+	}
+}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
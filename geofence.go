@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	geofenceFile = flag.String("geofence", "", "Only process tracks that enter the polygons of this GeoJSON file")
+)
+
+var geofence *Region
+
+// Loads -geofence, if set.
+func LoadGeofence() error {
+	if *geofenceFile == "" {
+		return nil
+	}
+	r, err := LoadRegion(*geofenceFile)
+	if err != nil {
+		return fmt.Errorf("load geofence %w", err)
+	}
+	log.Infof("Only processing tracks entering %d polygons from %s", len(r.polygons), *geofenceFile)
+	geofence = r
+	return nil
+}
+
+// Whether a track enters -geofence, or true without one. Tracks whose
+// bounding box misses the geofence are rejected without testing each point.
+func InGeofence(t *gpx.GPXTrack) bool {
+	if geofence == nil {
+		return true
+	}
+	return geofence.Enters(t)
+}
+
+// Whether any point of a track is inside the region.
+func (r *Region) Enters(t *gpx.GPXTrack) bool {
+	b := t.Bounds()
+	if !r.Overlaps(b.MinLatitude, b.MaxLatitude, b.MinLongitude, b.MaxLongitude) {
+		return false
+	}
+	for _, seg := range t.Segments {
+		for _, p := range seg.Points {
+			if r.Contains(p.Latitude, p.Longitude) {
+				return true
+			}
+		}
+	}
+	return false
+}
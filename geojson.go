@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// Feature properties used for tracks. Decoding shares the geoJSON types with
+// -region.
+type geoJSONProps struct {
+	// CalTopo names features by title, most other tools by name.
+	Title string `json:"title"`
+	Name  string `json:"name"`
+	// CalTopo's kind of map object, e.g. Shape for lines.
+	Class string `json:"class"`
+	// Times of each coordinate as written by togeojson and Mapbox tools:
+	// strings for a LineString, a list per line for a MultiLineString.
+	CoordTimes json.RawMessage `json:"coordTimes"`
+}
+
+// Decodes LineString and MultiLineString features into tracks, one per
+// feature with a segment per line. Times come from a fourth coordinate in
+// epoch milliseconds, as CalTopo writes them, or a coordTimes property.
+// Features without times, such as drawn lines, are skipped.
+func ReadGeoJSON(filename string) (*gpx.GPX, error) {
+	b, err := os.ReadFile(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	var gj geoJSON
+	if err := json.Unmarshal(b, &gj); err != nil {
+		return nil, fmt.Errorf("decode geojson %w", err)
+	}
+
+	features := gj.Features
+	switch gj.Type {
+	case "Feature":
+		features = []geoJSON{gj}
+	case "LineString", "MultiLineString":
+		features = []geoJSON{{Type: "Feature", Geometry: &geoJSONGeometry{Type: gj.Type, Coordinates: gj.Coordinates}}}
+	}
+
+	g := &gpx.GPX{Creator: "GeoJSON"}
+	for _, f := range features {
+		t, err := geoJSONTrack(f)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			g.Tracks = append(g.Tracks, *t)
+		}
+	}
+	if len(g.Tracks) == 0 {
+		return nil, fmt.Errorf("geojson file has no lines with times")
+	}
+	return g, nil
+}
+
+func geoJSONTrack(f geoJSON) (*gpx.GPXTrack, error) {
+	if f.Geometry == nil {
+		return nil, nil
+	}
+	var lines [][][]float64
+	var times [][]string
+	switch f.Geometry.Type {
+	case "LineString":
+		var line [][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &line); err != nil {
+			return nil, fmt.Errorf("decode geojson line %w", err)
+		}
+		lines = [][][]float64{line}
+		var lt []string
+		if len(f.Properties.CoordTimes) > 0 && json.Unmarshal(f.Properties.CoordTimes, &lt) == nil {
+			times = [][]string{lt}
+		}
+	case "MultiLineString":
+		if err := json.Unmarshal(f.Geometry.Coordinates, &lines); err != nil {
+			return nil, fmt.Errorf("decode geojson lines %w", err)
+		}
+		if len(f.Properties.CoordTimes) > 0 {
+			json.Unmarshal(f.Properties.CoordTimes, &times)
+		}
+	default:
+		// Markers and shapes.
+		return nil, nil
+	}
+
+	t := &gpx.GPXTrack{Name: f.Properties.Title}
+	if t.Name == "" {
+		t.Name = f.Properties.Name
+	}
+	for i, line := range lines {
+		seg := gpx.GPXTrackSegment{}
+		for j, c := range line {
+			if len(c) < 2 {
+				continue
+			}
+			p := gpx.GPXPoint{}
+			p.Longitude, p.Latitude = c[0], c[1]
+			if len(c) >= 3 {
+				p.Elevation = *gpx.NewNullableFloat64(c[2])
+			}
+			switch {
+			case len(c) >= 4 && c[3] > 0:
+				p.Timestamp = time.UnixMilli(int64(c[3])).UTC()
+			case i < len(times) && j < len(times[i]):
+				ts, err := time.Parse(time.RFC3339, times[i][j])
+				if err != nil {
+					continue
+				}
+				p.Timestamp = ts
+			default:
+				continue
+			}
+			seg.Points = append(seg.Points, p)
+		}
+		if len(seg.Points) > 0 {
+			t.Segments = append(t.Segments, seg)
+		}
+	}
+	if len(t.Segments) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
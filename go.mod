@@ -5,10 +5,7 @@ go 1.18
 require (
 	github.com/sirupsen/logrus v1.9.0
 	github.com/tkrajina/gpxgo v1.2.1
-)
-
-require (
-	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
-	golang.org/x/text v0.3.6 // indirect
+	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
+	golang.org/x/text v0.3.6
 )
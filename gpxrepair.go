@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	lenientGPX = flag.Bool("lenient_gpx", true, "Repair GPX files gpxgo rejects, e.g. with bare ampersands, stray control characters, mismatched or missing closing tags or an unknown version, instead of failing them")
+)
+
+// Parses a GPX file that failed to parse, after repairing it. GPX 1.0 files
+// that still fail are upgraded to 1.1, which keeps their tracks.
+func parseGPXLeniently(filename string, parseErr error) (*gpx.GPX, error) {
+	if !*lenientGPX {
+		return nil, parseErr
+	}
+	log.Warnf("Repairing malformed GPX file %q: %v", filename, parseErr)
+	b, err := os.ReadFile(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	repaired, version, err := repairGPX(b, "")
+	if err != nil {
+		return nil, fmt.Errorf("%v, repair failed: %w", parseErr, err)
+	}
+	g, err := gpx.ParseBytes(repaired)
+	if err != nil && version == "1.0" {
+		log.Infof("Upgrading GPX 1.0 file %q to 1.1", filename)
+		if repaired, _, err = repairGPX(b, "1.1"); err == nil {
+			g, err = gpx.ParseBytes(repaired)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v, still malformed after repair: %w", parseErr, err)
+	}
+	return g, nil
+}
+
+// Rewrites a GPX document as well formed XML. Control characters are
+// dropped, entities and unquoted attributes are read leniently, and elements
+// are closed where their parent closes or the file ends, so truncated files
+// keep the points before the cut. The root's version is set to version, or
+// kept when it is 1.0 or 1.1 and set to 1.1 otherwise. Returns the document
+// and the version it declares.
+func repairGPX(b []byte, version string) ([]byte, string, error) {
+	// Bytewise, since the document may not be UTF-8.
+	clean := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c >= 0x20 || c == '\t' || c == '\n' || c == '\r' {
+			clean = append(clean, c)
+		}
+	}
+	b = clean
+	// Drop a byte order mark or junk before the document.
+	if i := bytes.IndexByte(b, '<'); i > 0 {
+		b = b[i:]
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.CharsetReader = charset.NewReaderLabel
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	var open []string
+	sawRoot := false
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !sawRoot {
+				return nil, "", err
+			}
+			log.Warnf("Truncating GPX at %v", err)
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := qualifiedName(t.Name)
+			if !sawRoot && t.Name.Local == "gpx" {
+				sawRoot = true
+				version = setGPXVersion(&t, version)
+			}
+			out.WriteString("<" + name)
+			for _, a := range t.Attr {
+				out.WriteString(" " + qualifiedName(a.Name) + `="`)
+				xml.EscapeText(&out, []byte(a.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+			open = append(open, name)
+		case xml.EndElement:
+			name := qualifiedName(t.Name)
+			// Close elements left open inside this one, or ignore a stray
+			// closing tag.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					for len(open) > i {
+						out.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+		case xml.CharData:
+			if len(open) > 0 {
+				xml.EscapeText(&out, t)
+			}
+		}
+		// Comments, directives and the XML declaration are dropped, the
+		// output is always UTF-8.
+	}
+	if !sawRoot {
+		return nil, "", fmt.Errorf("no gpx element")
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.Bytes(), version, nil
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// Sets the version attribute of the gpx element, see repairGPX. Upgrading
+// also switches the default namespace to 1.1's.
+func setGPXVersion(t *xml.StartElement, version string) string {
+	current := ""
+	for _, a := range t.Attr {
+		if a.Name.Space == "" && a.Name.Local == "version" {
+			current = strings.TrimSpace(a.Value)
+		}
+	}
+	if version == "" {
+		version = "1.1"
+		if current == "1.0" {
+			version = "1.0"
+		}
+	}
+	// Version first, gpxgo only looks for it near the start.
+	attrs := []xml.Attr{{Name: xml.Name{Local: "version"}, Value: version}}
+	for _, a := range t.Attr {
+		if a.Name.Space == "" && a.Name.Local == "version" {
+			continue
+		}
+		if version == "1.1" && a.Name.Space == "" && a.Name.Local == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	if version == "1.1" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: "http://www.topografix.com/GPX/1/1"})
+	}
+	t.Attr = attrs
+	return version
+}
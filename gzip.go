@@ -0,0 +1,60 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Whether a file name has a supported track extension, also when gzipped as
+// in Strava's bulk export, e.g. 1234567.gpx.gz.
+func trackFileSupported(name string) bool {
+	name = strings.ToLower(name)
+	_, ok := extToGPSBabelFormat[filepath.Ext(strings.TrimSuffix(name, ".gz"))]
+	return ok
+}
+
+// Decompresses a gzipped track file into a temporary file named without the
+// .gz, so its extension still selects the decoder. Other files are returned
+// as is. The returned func removes the temporary file.
+func gunzipTrack(filename string) (string, func(), error) {
+	if strings.ToLower(filepath.Ext(filename)) != ".gz" {
+		return filename, func() {}, nil
+	}
+	in, err := os.Open(longPath(filename))
+	if err != nil {
+		return "", nil, err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return "", nil, fmt.Errorf("gunzip %w", err)
+	}
+	defer zr.Close()
+
+	tmp, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+	out := filepath.Join(tmp, strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	w, err := os.Create(longPath(out))
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := io.Copy(w, zr); err != nil {
+		w.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("gunzip %w", err)
+	}
+	if err := w.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return out, cleanup, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	hrInTripReport = flag.Bool("trip_report_hr", false, "Include average and max heart rate and an effort score in trip reports")
+	maxHR          = flag.Float64("max_hr", 190, "Maximum heart rate, for the effort score")
+	restingHR      = flag.Float64("resting_hr", 60, "Resting heart rate, for the effort score")
+)
+
+// Garmin's track point extension namespace, used by most devices for heart
+// rate in GPX.
+const gpxtpxNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v1"
+
+type HeartRateStats struct {
+	Avg float64
+	Max float64
+	// Banister TRIMP: minutes weighted exponentially by heart rate reserve.
+	Effort float64
+}
+
+// Returns the heart rate recorded in a point's extensions, if any.
+func pointHeartRate(p *gpx.GPXPoint) (float64, bool) {
+	var find func(nodes []gpx.ExtensionNode) (float64, bool)
+	find = func(nodes []gpx.ExtensionNode) (float64, bool) {
+		for _, n := range nodes {
+			if strings.EqualFold(n.XMLName.Local, "hr") || strings.EqualFold(n.XMLName.Local, "heartrate") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(n.Data), 64); err == nil && v > 0 {
+					return v, true
+				}
+			}
+			if v, ok := find(n.Nodes); ok {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+	return find(p.Extensions.Nodes)
+}
+
+// Sets a point's heart rate extension.
+func setPointHeartRate(p *gpx.GPXPoint, hr float64) {
+	p.Extensions.Nodes = append(p.Extensions.Nodes, gpx.ExtensionNode{
+		XMLName: xml.Name{Space: gpxtpxNamespace, Local: "TrackPointExtension"},
+		Nodes: []gpx.ExtensionNode{{
+			XMLName: xml.Name{Space: gpxtpxNamespace, Local: "hr"},
+			Data:    strconv.Itoa(int(hr)),
+		}},
+	})
+}
+
+// Computes heart rate stats, or nil when the track has no heart rate.
+func ComputeHeartRate(points []gpx.GPXPoint) *HeartRateStats {
+	s := &HeartRateStats{}
+	var sum, minutes float64
+	prev := -1
+	for i := range points {
+		hr, ok := pointHeartRate(&points[i])
+		if !ok {
+			continue
+		}
+		if hr > s.Max {
+			s.Max = hr
+		}
+		if prev >= 0 {
+			dt := points[i].Timestamp.Sub(points[prev].Timestamp).Minutes()
+			if dt > 0 {
+				sum += hr * dt
+				minutes += dt
+				if reserve := (hr - *restingHR) / (*maxHR - *restingHR); reserve > 0 {
+					s.Effort += dt * reserve * 0.64 * math.Exp(1.92*reserve)
+				}
+			}
+		}
+		prev = i
+	}
+	if s.Max == 0 {
+		return nil
+	}
+	if minutes > 0 {
+		s.Avg = sum / minutes
+	} else {
+		s.Avg = s.Max
+	}
+	return s
+}
+
+// Returns a copy of a track without point extensions, which hold sensor data
+// like heart rate that shouldn't be uploaded.
+func stripExtensions(t gpx.GPXTrack) gpx.GPXTrack {
+	c := t
+	c.Segments = make([]gpx.GPXTrackSegment, len(t.Segments))
+	for i, seg := range t.Segments {
+		c.Segments[i] = seg
+		c.Segments[i].Points = make([]gpx.GPXPoint, len(seg.Points))
+		for j, p := range seg.Points {
+			p.Extensions = gpx.Extension{}
+			c.Segments[i].Points[j] = p
+		}
+	}
+	return c
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	igcTrimFlight   = flag.Bool("igc_trim_flight", true, "Trim IGC flight logs at takeoff, so hike and fly outings are logged from the hike up only")
+	igcTakeoffSpeed = flag.Float64("igc_takeoff_speed", 7, "Ground speed in meters per second, sustained for 20 seconds, taken as the takeoff in IGC logs")
+)
+
+// Track type of IGC logs trimmed at takeoff, which end at launch rather than
+// back at a trailhead.
+const igcFlownType = "hike and fly"
+
+// Ground speed is averaged over this long to tell flying from a fast descent
+// on foot.
+const igcTakeoffWindow = 20 * time.Second
+
+// Decodes an IGC flight log, as written by paragliding varios. B records are
+// fixes with a time of day, the date comes from the HFDTE header.
+func ReadIGC(filename string) (*gpx.GPX, error) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := gpx.GPXTrack{Type: "paragliding"}
+	seg := gpx.GPXTrackSegment{}
+	var date, last time.Time
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "HFDTE"):
+			date, err = parseIGCDate(line)
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "HFPLTPILOT"):
+			if i := strings.Index(line, ":"); i >= 0 {
+				t.Name = strings.TrimSpace(line[i+1:])
+			}
+		case strings.HasPrefix(line, "B"):
+			if date.IsZero() {
+				return nil, fmt.Errorf("igc fix before the HFDTE date header")
+			}
+			p, ok := parseIGCFix(line, date)
+			if !ok {
+				continue
+			}
+			// Times of day wrap past midnight UTC.
+			if !last.IsZero() && p.Timestamp.Before(last.Add(-time.Hour)) {
+				p.Timestamp = p.Timestamp.AddDate(0, 0, 1)
+				date = date.AddDate(0, 0, 1)
+			}
+			last = p.Timestamp
+			seg.Points = append(seg.Points, p)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read igc %w", err)
+	}
+	if len(seg.Points) == 0 {
+		return nil, fmt.Errorf("igc file has no fixes")
+	}
+	if *igcTrimFlight {
+		if i := igcTakeoff(seg.Points); i > 0 {
+			log.Infof("Trimming flight from takeoff at %v", seg.Points[i].Timestamp)
+			seg.Points = seg.Points[:i+1]
+			t.Type = igcFlownType
+		}
+	}
+	t.Segments = []gpx.GPXTrackSegment{seg}
+	return &gpx.GPX{Creator: "IGC", Tracks: []gpx.GPXTrack{t}}, nil
+}
+
+// Parses "HFDTEDDMMYY" or the newer "HFDTEDATE:DDMMYY,NN".
+func parseIGCDate(line string) (time.Time, error) {
+	v := strings.TrimPrefix(line, "HFDTE")
+	if i := strings.Index(v, ":"); i >= 0 {
+		v = v[i+1:]
+	}
+	if len(v) < 6 {
+		return time.Time{}, fmt.Errorf("igc date %q", line)
+	}
+	d, err := time.Parse("020106", v[:6])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("igc date %w", err)
+	}
+	return d, nil
+}
+
+// Parses a B record: BHHMMSSDDMMmmmNDDDMMmmmEVPPPPPGGGGG with pressure and
+// GPS altitudes in meters. GPS altitude is preferred, loggers without GPS
+// altitude write zeros. Fixes marked void (V) have no 3D fix.
+func parseIGCFix(line string, date time.Time) (gpx.GPXPoint, bool) {
+	p := gpx.GPXPoint{}
+	if len(line) < 35 || line[24] != 'A' {
+		return p, false
+	}
+	hh, err1 := strconv.Atoi(line[1:3])
+	mm, err2 := strconv.Atoi(line[3:5])
+	ss, err3 := strconv.Atoi(line[5:7])
+	lat, err4 := parseIGCCoord(line[7:14], line[14], 2)
+	lng, err5 := parseIGCCoord(line[15:23], line[23], 3)
+	pressAlt, err6 := strconv.Atoi(line[25:30])
+	gpsAlt, err7 := strconv.Atoi(line[30:35])
+	for _, err := range []error{err1, err2, err3, err4, err5, err6, err7} {
+		if err != nil {
+			return p, false
+		}
+	}
+	p.Timestamp = time.Date(date.Year(), date.Month(), date.Day(), hh, mm, ss, 0, time.UTC)
+	p.Latitude, p.Longitude = lat, lng
+	alt := gpsAlt
+	if alt == 0 {
+		alt = pressAlt
+	}
+	p.Elevation = *gpx.NewNullableFloat64(float64(alt))
+	return p, true
+}
+
+// Parses degrees and thousandths of minutes, e.g. "4651234" and 'N'.
+func parseIGCCoord(v string, hemi byte, degDigits int) (float64, error) {
+	deg, err := strconv.Atoi(v[:degDigits])
+	if err != nil {
+		return 0, err
+	}
+	min, err := strconv.Atoi(v[degDigits:])
+	if err != nil {
+		return 0, err
+	}
+	c := float64(deg) + float64(min)/1000/60
+	if hemi == 'S' || hemi == 'W' {
+		c = -c
+	}
+	return c, nil
+}
+
+// Returns the index of the fix where the flight begins, the first after which
+// ground speed stays above -igc_takeoff_speed for igcTakeoffWindow, or -1 if
+// the log has no flight.
+func igcTakeoff(points []gpx.GPXPoint) int {
+	for i := range points {
+		dist := 0.0
+		j := i + 1
+		for ; j < len(points) && points[j].Timestamp.Sub(points[i].Timestamp) < igcTakeoffWindow; j++ {
+			dist += pointDistance(&points[j-1], &points[j])
+		}
+		if j == len(points) {
+			break
+		}
+		dist += pointDistance(&points[j-1], &points[j])
+		if dt := points[j].Timestamp.Sub(points[i].Timestamp).Seconds(); dist/dt > *igcTakeoffSpeed {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const IndexFilename = "index.json"
+
+// Summary of a track in the archive index.
+type IndexTrack struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+
+	MinLat, MaxLat, MinLng, MaxLng float64
+
+	HighLat, HighLng float64
+	// High point elevation in meters.
+	HighElevation float64
+}
+
+func (t *IndexTrack) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// Index entry for one file. Size and ModTime detect changed files, with the
+// content hash as a fallback so touched but unchanged files aren't reparsed.
+type IndexFile struct {
+	Size    int64
+	ModTime time.Time
+	SHA256  string
+	Tracks  []*IndexTrack
+
+	// Names of the peaks matched when the file was uploaded.
+	Peaks []string `json:",omitempty"`
+}
+
+// Catalog of the tracks in the input directory, so filtered runs can skip
+// files without parsing them.
+type Index struct {
+	Files map[string]*IndexFile
+
+	// Input directory the index catalogs.
+	dir string
+}
+
+// Loads the index of an input directory, or an empty index if there is none.
+func LoadIndex(dir string) (*Index, error) {
+	idx := &Index{Files: make(map[string]*IndexFile), dir: dir}
+	b, err := ioutil.ReadFile(longPath(filepath.Join(dir, IndexFilename)))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, idx); err != nil {
+		return nil, fmt.Errorf("parse index %w", err)
+	}
+	return idx, nil
+}
+
+func (idx *Index) Save() error {
+	b, err := json.MarshalIndent(idx, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(longPath(filepath.Join(idx.dir, IndexFilename)), b, 0644)
+}
+
+// Returns the index entry for a file if it is up to date.
+func (idx *Index) Lookup(fi os.FileInfo) *IndexFile {
+	f, ok := idx.Files[fi.Name()]
+	if !ok || f.Size != fi.Size() {
+		return nil
+	}
+	if f.ModTime.Equal(fi.ModTime()) {
+		return f
+	}
+	if h, err := fileSHA256(filepath.Join(idx.dir, fi.Name())); err == nil && h == f.SHA256 {
+		f.ModTime = fi.ModTime()
+		return f
+	}
+	return nil
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Summarizes a track for the index.
+func indexTrack(t *gpx.GPXTrack) *IndexTrack {
+	it := &IndexTrack{Name: t.Name}
+	times := t.TimeBounds()
+	it.Start, it.End = times.StartTime, times.EndTime
+	b := t.Bounds()
+	it.MinLat, it.MaxLat, it.MinLng, it.MaxLng = b.MinLatitude, b.MaxLatitude, b.MinLongitude, b.MaxLongitude
+	if tb, err := ToTrackBounds(*t); err == nil {
+		it.HighLat, it.HighLng = tb.Highest.Latitude, tb.Highest.Longitude
+		it.HighElevation = tb.Highest.Elevation.Value()
+	}
+	return it
+}
+
+// Parses a file and records its tracks in the index.
+func (idx *Index) Update(fi os.FileInfo) (*IndexFile, error) {
+	filename := filepath.Join(idx.dir, fi.Name())
+	h, err := fileSHA256(filename)
+	if err != nil {
+		return nil, err
+	}
+	g, err := LoadGPX(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &IndexFile{Size: fi.Size(), ModTime: fi.ModTime(), SHA256: h}
+	for i := range g.Tracks {
+		f.Tracks = append(f.Tracks, indexTrack(&g.Tracks[i]))
+	}
+	idx.Files[fi.Name()] = f
+	return f, nil
+}
+
+// Builds or refreshes the index of an input directory, reparsing only new
+// and changed files.
+func UpdateIndex(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("index requires -directory")
+	}
+	files, err := ListInputFiles(dir)
+	if err != nil {
+		return err
+	}
+	return updateIndex(dir, files)
+}
+
+func updateIndex(dir string, files []os.FileInfo) error {
+	idx, err := LoadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool)
+	updated := 0
+	for _, fi := range files {
+		present[fi.Name()] = true
+		if idx.Lookup(fi) != nil {
+			continue
+		}
+		log.Infof("Indexing %q", fi.Name())
+		if _, err := idx.Update(fi); err != nil {
+			log.Warnf("Failed to index %q: %v", fi.Name(), err)
+			continue
+		}
+		updated++
+		releaseMemory()
+	}
+	for name := range idx.Files {
+		if !present[name] {
+			delete(idx.Files, name)
+		}
+	}
+	log.Infof("Indexed %d files, %d updated", len(idx.Files), updated)
+	if filterIndex.dir == dir {
+		filterIndex.idx = idx
+	}
+	return idx.Save()
+}
+
+// Keeps the index of a directory current across repeated scans, rebuilding it
+// only when the directory's files were added, removed or changed since the
+// last scan.
+type indexWatcher struct {
+	listing string
+}
+
+func (w *indexWatcher) Update(dir string) error {
+	files, err := ListInputFiles(dir)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(dir)
+	for _, fi := range files {
+		fmt.Fprintf(&b, "\n%s %d %d", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+	}
+	if b.String() == w.listing {
+		log.Debugf("No changed files in %q, index is current", dir)
+		return nil
+	}
+	if err := updateIndex(dir, files); err != nil {
+		return err
+	}
+	w.listing = b.String()
+	return nil
+}
+
+// Names of the peaks matched in report entries, without duplicates.
+func matchedPeaks(entries []*ReportEntry) []string {
+	var peaks []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.PeakName != "" && !seen[e.PeakName] {
+			seen[e.PeakName] = true
+			peaks = append(peaks, e.PeakName)
+		}
+	}
+	return peaks
+}
+
+// Records the peaks matched in a file, if the file is indexed.
+func RecordIndexPeaks(dir string, fi os.FileInfo, peaks []string) error {
+	if len(peaks) == 0 {
+		return nil
+	}
+	idx, err := LoadIndex(dir)
+	if err != nil {
+		return err
+	}
+	f := idx.Lookup(fi)
+	if f == nil {
+		return nil
+	}
+	f.Peaks = peaks
+	return idx.Save()
+}
+
+// Index used to skip files by filters, loaded on first use per directory.
+var filterIndex struct {
+	dir string
+	idx *Index
+}
+
+// Whether a file may contain tracks passing the track filters. Uses the
+// index when it has an up to date entry, otherwise the file has to be parsed
+// to know. Geofences are only checked against track bounding boxes here.
+func FileSelected(dir string, fi os.FileInfo) bool {
+	if !trackFiltersSet() {
+		return true
+	}
+	if filterIndex.idx == nil || filterIndex.dir != dir {
+		idx, err := LoadIndex(dir)
+		if err != nil {
+			log.Warnf("Failed to load index: %v", err)
+			idx = &Index{Files: make(map[string]*IndexFile), dir: dir}
+		}
+		filterIndex.dir, filterIndex.idx = dir, idx
+	}
+	f := filterIndex.idx.Lookup(fi)
+	if f == nil {
+		return true
+	}
+	for _, t := range f.Tracks {
+		if inDateRange(t.Start) && (geofence == nil || geofence.Overlaps(t.MinLat, t.MaxLat, t.MinLng, t.MaxLng)) {
+			return true
+		}
+	}
+	log.Debugf("Skipping %q, no indexed tracks pass the filters", fi.Name())
+	return false
+}
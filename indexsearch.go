@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Queries the archive index: index search [-peak name] [-from date] [-to date]
+// [-bbox minlat,minlng,maxlat,maxlng] [-area geojson].
+func SearchIndex(args []string) error {
+	fs := flag.NewFlagSet("index search", flag.ContinueOnError)
+	peak := fs.String("peak", "", "Only tracks where a peak containing this text was matched")
+	from := fs.String("from", "", "Only tracks starting on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "Only tracks starting on or before this date (YYYY-MM-DD)")
+	bbox := fs.String("bbox", "", "Only tracks overlapping this box: minlat,minlng,maxlat,maxlng")
+	area := fs.String("area", "", "Only tracks whose high point is inside the polygons of this GeoJSON file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var fromT, toT time.Time
+	var err error
+	if *from != "" {
+		if fromT, err = time.ParseInLocation("2006-01-02", *from, time.Local); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if toT, err = time.ParseInLocation("2006-01-02", *to, time.Local); err != nil {
+			return err
+		}
+		toT = toT.AddDate(0, 0, 1)
+	}
+	var box []float64
+	if *bbox != "" {
+		for _, s := range strings.Split(*bbox, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fmt.Errorf("-bbox %w", err)
+			}
+			box = append(box, v)
+		}
+		if len(box) != 4 {
+			return fmt.Errorf("-bbox needs minlat,minlng,maxlat,maxlng")
+		}
+	}
+	var region *Region
+	if *area != "" {
+		if region, err = LoadRegion(*area); err != nil {
+			return err
+		}
+	}
+
+	idx, err := LoadIndex(*inputDirectory)
+	if err != nil {
+		return err
+	}
+	if len(idx.Files) == 0 {
+		return fmt.Errorf("no index in %q, run the index command first", *inputDirectory)
+	}
+
+	var lines []string
+	for name, f := range idx.Files {
+		if *peak != "" && !containsFold(f.Peaks, *peak) {
+			continue
+		}
+		for _, t := range f.Tracks {
+			if !fromT.IsZero() && t.Start.Before(fromT) || !toT.IsZero() && !t.Start.Before(toT) {
+				continue
+			}
+			if box != nil && (t.MaxLat < box[0] || t.MinLat > box[2] || t.MaxLng < box[1] || t.MinLng > box[3]) {
+				continue
+			}
+			if region != nil && !region.Contains(t.HighLat, t.HighLng) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%q\t%v\t%.0fm\t%s",
+				t.Start.Local().Format("2006-01-02"), name, t.Name, t.Duration().Round(time.Minute), t.HighElevation, strings.Join(f.Peaks, ", ")))
+		}
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return nil
+}
+
+func containsFold(list []string, substr string) bool {
+	substr = strings.ToLower(substr)
+	for _, s := range list {
+		if strings.Contains(strings.ToLower(s), substr) {
+			return true
+		}
+	}
+	return false
+}
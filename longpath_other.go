@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+// Only Windows limits path length.
+func longPath(p string) string {
+	return p
+}
+
+// Path of an existing file to pass to another program, which reads paths
+// the same as this one outside Windows.
+func programPath(p string) string {
+	return p
+}
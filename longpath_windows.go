@@ -0,0 +1,73 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows paths longer than MAX_PATH need the \\?\ prefix, which also
+// disables path normalization so the path must be absolute and clean.
+// Directories are limited to MAX_PATH less room for an 8.3 file name.
+const maxPath = 260 - 12
+
+func longPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil || utf16Len(abs) < maxPath {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC path, \\server\share becomes \\?\UNC\server\share.
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// Length of a path in the UTF-16 code units Windows limits, fewer than its
+// bytes when it has non-ASCII characters.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// Path of an existing file to pass to another program. Programs like gpsbabel
+// that open their arguments through the ANSI code page can't open names
+// outside it, so a non-ASCII path is passed by its 8.3 short name when the
+// volume keeps short names.
+func programPath(p string) string {
+	if isASCII(p) {
+		return longPath(p)
+	}
+	if short, err := shortPathName(longPath(p)); err == nil && isASCII(short) {
+		return longPath(short)
+	}
+	return longPath(p)
+}
+
+func shortPathName(p string) (string, error) {
+	long, err := windows.UTF16PtrFromString(p)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n, err := windows.GetShortPathName(long, &buf[0], uint32(len(buf)))
+	if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buf[:n]), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name, path, want string
+	}{
+		{"short", `C:\tracks\rainier.gpx`, `C:\tracks\rainier.gpx`},
+		{"short relative", `tracks\rainier.gpx`, `tracks\rainier.gpx`},
+		{"long", `C:\tracks\` + long + `.gpx`, `\\?\C:\tracks\` + long + `.gpx`},
+		{"long unclean", `C:\tracks\..\tracks\.\` + long + `.gpx`, `\\?\C:\tracks\` + long + `.gpx`},
+		{"long forward slashes", `C:/tracks/` + long + `.gpx`, `\\?\C:\tracks\` + long + `.gpx`},
+		{"long UNC", `\\nas\share\` + long + `.gpx`, `\\?\UNC\nas\share\` + long + `.gpx`},
+		{"already prefixed", `\\?\C:\tracks\rainier.gpx`, `\\?\C:\tracks\rainier.gpx`},
+		// 200 characters but 400 bytes, under the limit in UTF-16.
+		{"non-ASCII under the limit", `C:\` + strings.Repeat("é", 200), `C:\` + strings.Repeat("é", 200)},
+		{"non-ASCII over the limit", `C:\` + strings.Repeat("é", 300), `\\?\C:\` + strings.Repeat("é", 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := longPath(tt.path); got != tt.want {
+				t.Errorf("longPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUTF16Len(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{`C:\a.gpx`, 8},
+		{"Mont Blanc été", 14},
+		{"富士山", 3},
+		// Outside the Basic Multilingual Plane, a surrogate pair.
+		{"⛰🏔", 3},
+	}
+	for _, tt := range tests {
+		if got := utf16Len(tt.s); got != tt.want {
+			t.Errorf("utf16Len(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestProgramPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"rainier.gpx", "Mont Blanc été.gpx", "富士山.gpx", strings.Repeat("ü", 250) + ".gpx"} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(dir, name)
+			if err := os.WriteFile(longPath(filename), []byte("<gpx/>"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got := programPath(filename)
+			if _, err := os.Stat(got); err != nil {
+				t.Errorf("programPath(%q) = %q, which can't be opened: %v", filename, got, err)
+			}
+			// Volumes without short names keep the long name.
+			if !isASCII(got) && !strings.HasSuffix(got, name) {
+				t.Errorf("programPath(%q) = %q, neither ASCII nor the file name", filename, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"runtime/debug"
+)
+
+var (
+	lowMemory = flag.Bool("low_memory", false, "Reduce memory use on small devices by collecting garbage aggressively and releasing memory between files")
+)
+
+// Garbage collection target percentage in low memory mode, trading CPU for
+// a smaller heap.
+const lowMemoryGCPercent = 25
+
+func ConfigureLowMemory() {
+	if *lowMemory {
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
+}
+
+// Returns memory from the previous file to the OS before starting the next,
+// so peak usage is bounded by the largest single file.
+func releaseMemory() {
+	if *lowMemory {
+		debug.FreeOSMemory()
+	}
+}
@@ -5,40 +5,51 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tkrajina/gpxgo/gpx"
 	"peakbagger-tools/pbtools/peakbagger"
-	"peakbagger-tools/pbtools/track"
 )
 
 var (
 	usernamePB = flag.String("username", "", "Peakbagger username")
-	passwordPB = flag.String("password", "", "Peakbagger password")
+	passwordPB = flag.String("password", "", "Peakbagger password (default $PEAKBAGGER_PASSWORD)")
 
 	inputFile      = flag.String("filename", "", "Input GPS track file")
 	inputDirectory = flag.String("directory", "", "Input directory")
 
 	dryRun = flag.Bool("dry_run", false, "Dry run, don't upload ascents")
-	retry = flag.Bool("retry", false, "Retry historic failures")
+	retry  = flag.Bool("retry", false, "Retry historic failures")
+
+	verbose = flag.Bool("verbose", false, "Verbose logging, including alternate stats calculations")
 
 	// Maps file extension to gpsbabel input format string
 	extToGPSBabelFormat = map[string]string{
-		".gdb": "gdb",
-		".gpx": "gpx",
-		".kml": "kml",
-		".kmz": "kmz",
+		".gdb":     "gdb",
+		".gpx":     "gpx",
+		".kml":     "kml",
+		".kmz":     "kmz",
+		".fit":     "garmin_fit",
+		".tcx":     "gtrnctr",
+		".geojson": "geojson",
+		".igc":     "igc",
+		// Raw NMEA sentences from standalone loggers.
+		".nmea": "nmea",
+		".log":  "nmea",
 	}
 )
 
+// Maximum number of points in a track, tracks are simplified to fit.
+const maxTrackPoints = 2900
+
 // Converts a provided file (of any supported GPS format) into a temporary GPX file
 // The caller is responsible for deleting the temporary file
 func ToGPX(inputFile string) (string, error) {
@@ -48,20 +59,23 @@ func ToGPX(inputFile string) (string, error) {
 	if !ok {
 		return "", fmt.Errorf("file extension %q is not not a known GPS format", ext)
 	}
+	if _, err := exec.LookPath("gpsbabel"); err != nil {
+		return "", fmt.Errorf("reading %s files needs gpsbabel, install it from https://www.gpsbabel.org: %w", ext, err)
+	}
 
 	of, err := ioutil.TempFile("", "peakbagger-bulk-uploader.*.gpx")
-	outputFile := of.Name()
-	of.Close()
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp gpx output file: %v", err)
 	}
+	outputFile := of.Name()
+	of.Close()
 
 	log.Infof("Converting %q to %q", inputFile, outputFile)
-	cmd := exec.Command("gpsbabel", "-t", "-i", format, "-f", inputFile, "-x", "simplify,count=2900", "-o", "gpx,garminextensions", "-F", outputFile)
+	cmd := exec.Command("gpsbabel", "-t", "-i", format, "-f", programPath(inputFile), "-x", fmt.Sprintf("simplify,count=%d", maxTrackPoints), "-o", "gpx,garminextensions", "-F", programPath(outputFile))
 
-	if err := cmd.Run(); err != nil {
-		out, _ := cmd.CombinedOutput()
-		return outputFile, fmt.Errorf("gpsbabel conversion failed %v: %s", err, string(out))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(outputFile)
+		return "", fmt.Errorf("gpsbabel conversion failed %v: %s", err, string(out))
 	}
 
 	return outputFile, nil
@@ -114,138 +128,550 @@ func ToTrackBounds(t gpx.GPXTrack) (*TrackBounds, error) {
 
 type History struct {
 	Error string
-	Added time.Time
+	// See ErrorCategory.
+	Category string `json:",omitempty"`
+	Added    time.Time
 }
 
 type Uploader struct {
-	client *peakbagger.PeakBagger
+	client Client
+
+	// Ascent list, loaded on first use and invalidated after adding ascents.
+	ascents peakbagger.AscentList
+
+	// Peak page info by peak ID, see -fetch_peak_info.
+	peakInfo map[string]*PeakInfo
+
+	report *RunReport
+
+	// Ascents held for approval on the web page, see -approval_addr.
+	approvals *ApprovalQueue
+
+	// Serializes Peakbagger access between daemon scans and approvals.
+	mu sync.Mutex
+
+	// Metadata of -strava_export and -source activities, keyed by history
+	// name.
+	activities map[string]activityMetadata
+
+	// Input directory being processed, -directory unless a schedule or
+	// export names another. Its files hold the history and ledgers.
+	dir string
+	// Activity source synced instead of reading the directory's files,
+	// -source unless a schedule names another.
+	source string
 
 	FilenameHistory map[string]*History
 }
 
 func NewUploader() (*Uploader, error) {
-	pb := peakbagger.NewClient(*usernamePB, *passwordPB)
-	climberID, err := pb.Login()
+	client, err := NewClient()
 	if err != nil {
-		return nil, fmt.Errorf("peakbagger login %w", err)
+		return nil, err
 	}
 
-	log.Infof("Logged in as %v", climberID)
-
 	return &Uploader{
-		client:          pb,
+		client:          client,
+		peakInfo:        make(map[string]*PeakInfo),
+		report:          NewRunReport(),
 		FilenameHistory: make(map[string]*History),
+		dir:             *inputDirectory,
+		source:          *sourceName,
 	}, nil
 }
 
-func (u *Uploader) UploadTrack(t gpx.GPXTrack) error {
+// A track analyzed and matched to a peak, ready to be logged.
+type PreparedAscent struct {
+	Ascent   peakbagger.Ascent
+	PeakName string
+	// Location of the matched peak.
+	Latitude, Longitude float64
+	// Motorized gain, flagged on the ascent once added.
+	Assisted *AssistedStats `json:",omitempty"`
+
+	// Whether an ascent of the peak is already logged on this date.
+	Duplicate bool
+	// Whether the track entered -hide_gpx_region.
+	Hidden bool `json:",omitempty"`
+}
+
+// Analyzes a track, matches it to a peak and builds the ascent to log.
+func (u *Uploader) PrepareAscent(src *Source, t gpx.GPXTrack, entry *ReportEntry) (*PreparedAscent, error) {
+	t, reversed := orientTrack(t)
+	if reversed {
+		log.Infof("Track points are newest first, reversing")
+	}
+	t, dropped := repairTimestamps(t)
+	if dropped > 0 {
+		log.Warnf("Dropped %d points with duplicate or out of order timestamps", dropped)
+	}
+	if !hasTimestamps(&t) {
+		// E.g. a course or planned route rather than a recording.
+		return nil, fmt.Errorf("no timestamps")
+	}
+
 	tb, err := ToTrackBounds(t)
 	if err != nil {
-		return fmt.Errorf("highest point %w", err)
+		return nil, fmt.Errorf("highest point %w", err)
 	}
 
 	log.Infof("Highest point is %v", tb.Highest)
 
-	bounds := track.Bounds{
-		MinLat: tb.Highest.Latitude,
-		MaxLat: tb.Highest.Latitude,
-		MinLng: tb.Highest.Longitude,
-		MaxLng: tb.Highest.Longitude,
+	points := trackPoints(&t)
+	if *interactive {
+		if candidates := HighPointCandidates(points, *summitCandidates); len(candidates) > 1 {
+			var options []string
+			for _, c := range candidates {
+				options = append(options, formatCandidate(c))
+			}
+			tb.Highest = candidates[choose(fmt.Sprintf("Candidate high points in %s:", src.Filename), options)]
+		}
+	}
+	gain, err := ComputeGain(points)
+	if err != nil && *gainAlgorithm == "dem" {
+		entry.degraded("DEM gain", err)
+		gain, err = hysteresisGain(points)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("elevation gain %w", err)
+	}
+	log.Infof("Elevation gain is %.0fm", gain)
+	entry.Gain = gain
 
-	// Allow 1000 of search area for peaks
-	bounds = bounds.Extend(float64(1000) / float64(69*5280))
+	// Before the plausibility check, which would reject lift and vehicle
+	// climbs.
+	assisted := ComputeAssisted(points, gain)
+	if assisted != nil {
+		log.Infof("Gained %.0fm by vehicle or lift, %.0fm under own power", assisted.AssistedGain, assisted.OwnGain)
+		entry.Assisted = assisted
+	}
+	if err := CheckPlausibility(points, gain, assisted); err != nil {
+		return nil, err
+	}
 
-	peaks, err := u.client.FindPeaks(&bounds)
+	peaks, err := u.CandidatePeaks(tb.Highest.Latitude, tb.Highest.Longitude)
 	if err != nil {
-		return fmt.Errorf("find peaks %w", err)
+		return nil, err
 	}
 
-	// Sort by closest to our highest point
-	sort.Slice(peaks, func(i, j int) bool {
-		return gpx.Distance2D(peaks[i].Latitude, peaks[i].Longitude, tb.Highest.Latitude, tb.Highest.Longitude, true) <
-			gpx.Distance2D(peaks[j].Latitude, peaks[j].Longitude, tb.Highest.Latitude, tb.Highest.Longitude, true)
-	})
-
 	log.Infof("Found %d matching peaks", len(peaks))
-	if len(peaks) == 0 {
-		return fmt.Errorf("no peaks found")
+	peaks = preferTitledPeak(peaks, t.Name, src.ActivityName)
+	if len(peaks) == 0 && *recordAttempts {
+		if err := u.recordAttempt(src, &t, tb.Highest, entry); err != nil {
+			return nil, err
+		}
 	}
-	if len(peaks) > 1 {
-		log.Warnf("expected 1 matching peak, found %d: %v. Using first.", len(peaks), peaks)
+	var peak *peakbagger.Peak
+	switch {
+	case *interactive && len(peaks) != 1:
+		if peak, err = u.choosePeak(src, peaks, tb.Highest); err != nil {
+			return nil, err
+		}
+	case len(peaks) == 0:
+		return nil, fmt.Errorf("no peaks found")
+	default:
+		if len(peaks) > 1 {
+			log.Warnf("expected 1 matching peak, found %d: %v. Using first.", len(peaks), peaks)
+		}
+		peak = peaks[0]
 	}
-	peak := peaks[0]
 	log.Infof("Highest point corresponds to %q", peak.Name)
+	entry.PeakID = fmt.Sprint(peak.PeakID)
+	entry.PeakName = peak.Name
+	Emit(entry.event(EventPeakMatched))
+
+	if u.calibrateFIT(src, &t, tb.Highest, peak.PeakID) {
+		points = trackPoints(&t)
+		if *gainAlgorithm != "dem" {
+			if gain, err = ComputeGain(points); err != nil {
+				return nil, fmt.Errorf("elevation gain %w", err)
+			}
+			log.Infof("Calibrated elevation gain is %.0fm", gain)
+			entry.Gain = gain
+		}
+	}
+
+	if err := CheckAssisted(assisted, peak.Name); err != nil {
+		return nil, err
+	}
 
-	ascents, err := u.client.ListAscents()
+	summit, err := ResolveSummit(points, tb.Highest, peak.Latitude, peak.Longitude)
 	if err != nil {
-		return fmt.Errorf("list ascents %w", err)
+		return nil, err
+	}
+	if !summit.Timestamp.Equal(tb.Highest.Timestamp) {
+		log.Infof("Using summit plateau point at %v", summit.Timestamp)
 	}
+	tb.Highest = summit
+	entry.Date = &tb.Highest.Timestamp
 
-	log.Infof("Loaded %d ascents", len(ascents))
+	onSummit := TimeOnSummit(points, summit)
+	log.Infof("Spent %v on the summit", onSummit)
+	entry.TimeOnSummit = onSummit
 
-	if ascents.Has(peak.PeakID, &tb.Highest.Timestamp) {
-		return fmt.Errorf("Already have ascent logged for %q on %v", peak.Name, tb.Highest.Timestamp)
+	accuracy := EstimateSummitAccuracy(points, summit)
+	if accuracy != nil {
+		log.Infof("Summit accuracy %v", accuracy)
+		entry.SummitAccuracy = accuracy.Estimate
+	}
+	if accuracy.Low() {
+		d := Distance(summit.Latitude, summit.Longitude, peak.Latitude, peak.Longitude)
+		log.Warnf("Low confidence match to %q: summit accuracy %v, %.0fm from the listed peak", peak.Name, accuracy, d)
+		if *interactive {
+			fmt.Println(u.peakFactSheet(peak, summit.Latitude, summit.Longitude))
+		}
+		if *interactive && !confirm("Summit accuracy is %v. Log an ascent of %s?", accuracy, peak.Name) {
+			return nil, fmt.Errorf("low confidence match to %q not confirmed", peak.Name)
+		}
+	}
+
+	var info *PeakInfo
+	if Enriching("peak_info") {
+		entry.Enrich("Peak info", func() error {
+			var err error
+			info, err = u.PeakInfo(fmt.Sprint(peak.PeakID))
+			if err != nil {
+				return err
+			}
+			CheckPeakInfo(info, tb.Highest.Elevation.Value(), gain)
+			return nil
+		})
+	}
+
+	ascents, err := u.Ascents()
+	if err != nil {
+		return nil, fmt.Errorf("list ascents %w", err)
 	}
 
 	times := t.TimeBounds()
+	if err := CheckDurations(times.StartTime, tb.Highest.Timestamp, times.EndTime); err != nil {
+		return nil, err
+	}
+	timeUp := tb.Highest.Timestamp.Sub(times.StartTime)
 
 	// TODO: split the track on uphill vs downhill, then trim tracks to remove stopped time at summit
 
+	cmp := CompareAscents(ascents, peak.PeakID, tb.Highest.Timestamp, timeUp)
+	if cmp != nil {
+		log.Infof("Repeat ascent of %q: %v", peak.Name, cmp)
+	}
+
+	// Legs of a multi-peak track start and end at cols, not trailheads, and
+	// flown down tracks end at launch.
+	var traverse *Traverse
+	if src.Leg == nil && t.Type != igcFlownType {
+		traverse = DetectTraverse(tb)
+	}
+	if traverse != nil {
+		log.Infof("Traverse from %.0fm to %.0fm, %.1fkm apart", traverse.StartElevation, traverse.EndElevation, traverse.Distance/1000)
+	}
+
+	var movement *MovementStats
+	if Enriching("movement") {
+		movement = ComputeMovementStats(points, tb.Highest)
+		entry.Movement = movement
+	}
+	var hr *HeartRateStats
+	if Enriching("heart_rate") {
+		hr = ComputeHeartRate(points)
+		entry.HeartRate = hr
+	}
+	var conditions *Conditions
+	if Enriching("conditions") {
+		conditions = InferConditions(tb.Highest.Latitude, tb.Highest.Timestamp, tb.Start.Elevation.Value(), tb.Highest.Elevation.Value())
+	}
+	if Enriching("snotel") {
+		entry.Enrich("SNOTEL snow depth", func() error {
+			obs, err := NearestSnotel(tb.Highest.Latitude, tb.Highest.Longitude, tb.Highest.Timestamp.Local())
+			if err != nil {
+				return err
+			}
+			if obs != nil {
+				log.Infof("%v", obs)
+				if conditions == nil {
+					conditions = &Conditions{}
+				}
+				conditions.Snotel = obs
+			}
+			return nil
+		})
+	}
+	if conditions != nil {
+		entry.Conditions = conditions.String()
+	}
+
+	var daylight *Daylight
+	if Enriching("daylight") {
+		daylight = ComputeDaylight(times.StartTime, times.EndTime, tb.Highest.Latitude, tb.Highest.Longitude)
+		entry.Daylight = daylight
+		if daylight.StartedBeforeSunrise || daylight.FinishedAfterSunset {
+			log.Infof("Spent %v of the outing in the dark", daylight.Dark)
+		}
+	}
+
+	var ski *SkiStats
+	if Enriching("ski") && IsSkiTrack(src, &t) {
+		entry.Enrich("Ski stats", func() error {
+			var err error
+			ski, err = ComputeSkiStats(&t, tb.Highest)
+			log.Infof("Ski descent stats %+v", ski)
+			return err
+		})
+	}
+
+	var route *RouteDeviation
+	if Enriching("route") {
+		entry.Enrich("Route comparison", func() error {
+			var err error
+			route, err = CheckRoutes(points, entry.PeakID, entry)
+			return err
+		})
+	}
+
+	report, err := RenderTripReport(&TripReportData{
+		Source:         src.ForTrack(&t),
+		PeakName:       peak.Name,
+		Date:           tb.Highest.Timestamp,
+		Uploaded:       outputNow(),
+		Comparison:     cmp,
+		PRNote:         *prNote && cmp != nil && cmp.PersonalRecord,
+		PeakInfo:       info,
+		Gain:           gain,
+		Ski:            ski,
+		Traverse:       traverse,
+		FlownDown:      t.Type == igcFlownType,
+		Assisted:       assisted,
+		OnSummit:       onSummit,
+		Movement:       movement,
+		Pace:           *paceInTripReport,
+		HeartRate:      hr,
+		ShowHR:         *hrInTripReport,
+		Conditions:     conditions,
+		ShowConditions: *conditionsInTripReport,
+		Daylight:       daylight,
+		ShowDaylight:   *daylightInTripReport,
+		Route:          route,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	ascent := peakbagger.Ascent{
 		PeakID:     peak.PeakID,
 		Date:       &tb.Highest.Timestamp,
-		Gpx:        &gpx.GPX{Tracks: []gpx.GPXTrack{t}},
-		TripReport: fmt.Sprintf("[i]Uploaded by [a href=\"https://github.com/jheidel/peakbagger-bulk-uploader\"]peakbagger-bulk-uploader[/a] on %s[/i]", time.Now().Format(time.RFC3339Nano)),
+		Gpx:        &gpx.GPX{Tracks: []gpx.GPXTrack{stripExtensions(t)}},
+		TripReport: report,
 
 		// TODO polish up some of the stats
 
-		TimeUp:   tb.Highest.Timestamp.Sub(times.StartTime),
+		TimeUp:   timeUp,
 		TimeDown: times.EndTime.Sub(tb.Highest.Timestamp),
 
 		StartElevation: tb.Start.Elevation.Value(),
 		EndElevation:   tb.End.Elevation.Value(),
 	}
+	hidden := InHiddenRegion(&t)
+	if *hideGPX || hidden {
+		log.Infof("Hiding the GPS track, only logging stats")
+		ascent.Gpx = nil
+	}
+	entry.TimeUp = ascent.TimeUp
+	entry.TimeDown = ascent.TimeDown
+	entry.TripReport = ascent.TripReport
+
+	return &PreparedAscent{
+		Ascent:    ascent,
+		PeakName:  peak.Name,
+		Latitude:  peak.Latitude,
+		Longitude: peak.Longitude,
+		Assisted:  assisted,
+		Duplicate: ascents.Has(peak.PeakID, &tb.Highest.Timestamp),
+		Hidden:    hidden,
+	}, nil
+}
 
-	log.Infof("Adding ascent %v", ascent)
+func (u *Uploader) UploadTrack(src *Source, t gpx.GPXTrack) (err error) {
+	entry := u.report.NewEntry(src, &t)
+	defer func() {
+		entry.Finish(err)
+	}()
+
+	prep, err := u.PrepareAscent(src, t, entry)
+	if err != nil {
+		return err
+	}
+	if prep.Duplicate {
+		return fmt.Errorf("Already have ascent logged for %q on %v", prep.PeakName, *prep.Ascent.Date)
+	}
+
+	if u.approvals != nil {
+		u.approvals.Add(u.dir, prep, entry)
+		log.Infof("Ascent of %q awaiting approval", prep.PeakName)
+		Emit(entry.event(EventAscentQueued))
+		return nil
+	}
+
+	return u.addAscent(prep, entry)
+}
+
+func (u *Uploader) addAscent(prep *PreparedAscent, entry *ReportEntry) error {
+	if err := checkAscentFlags(u.client); err != nil {
+		return err
+	}
+	log.Infof("Adding ascent %v", prep.Ascent)
 
 	if *dryRun {
 		log.Infof("DRY RUN, skipping ascent add")
 		return nil
 	}
+	if *offlineQueue {
+		return u.queueAscent(prep, entry)
+	}
 
-	if _, err := u.client.AddAscent(ascent); err != nil {
+	prep, err := deferAscentGPX(u.dir, prep)
+	if err != nil {
+		return fmt.Errorf("defer gpx %w", err)
+	}
+	id, err := u.client.AddAscent(prep.Ascent)
+	if err != nil {
 		return fmt.Errorf("failed to add ascent %w", err)
 	}
+	u.ascents = nil
+	entry.Uploaded = true
+	if err := RecordManifest(u.dir, id, prep, entry); err != nil {
+		log.Warnf("Failed to record ascent in %s: %v", ManifestFilename, err)
+	}
+	if err := u.applyVisibility(id); err != nil {
+		log.Warnf("Failed to make ascent of %q private: %v", prep.PeakName, err)
+	}
+	if err := u.applyAssisted(id, prep.Assisted); err != nil {
+		log.Warnf("Failed to flag ascent of %q as motorized: %v", prep.PeakName, err)
+	}
 
-	log.Infof("Uploaded new ascent for %q", peak.Name)
+	log.Infof("Uploaded new ascent for %q", prep.PeakName)
+	ev := entry.event(EventAscentUploaded)
+	ev.AscentID = fmt.Sprint(id)
+	Emit(ev)
 
 	return nil
 
 }
 
-func (u *Uploader) UploadFile(filename string) error {
+// Formats decoded without gpsbabel, keyed by file extension.
+var nativeReaders = map[string]func(filename string) (*gpx.GPX, error){
+	".fit":     ReadFIT,
+	".gpx":     ReadGPX,
+	".tcx":     ReadTCX,
+	".geojson": ReadGeoJSON,
+	".igc":     ReadIGC,
+}
+
+// Parses a GPX file's tracks. Like gpsbabel -t, routes and waypoints are
+// dropped.
+func ReadGPX(filename string) (*gpx.GPX, error) {
+	// Parse directly from the file to avoid holding the raw XML in memory.
+	g, err := gpx.ParseFile(longPath(filename))
+	if err != nil {
+		g, err = parseGPXLeniently(filename, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse gpx file %w", err)
+	}
+	g.Routes, g.Waypoints = nil, nil
+	return g, nil
+}
+
+// Loads a provided file (of any supported GPS format) as simplified GPX.
+func LoadGPX(filename string) (*gpx.GPX, error) {
+	filename, cleanup, err := prepareTrack(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	return decodeTrack(filename)
+}
+
+// Decodes a file prepared by prepareTrack.
+func decodeTrack(filename string) (*gpx.GPX, error) {
+	if read, ok := nativeReaders[strings.ToLower(filepath.Ext(filename))]; ok {
+		g, err := loadNative(read, filename)
+		if err == nil {
+			return g, nil
+		}
+		// The native decoders only read what activity files need, gpsbabel
+		// copes with some files they reject.
+		log.Warnf("Failed to read %q natively, trying gpsbabel: %v", filename, err)
+		g, gerr := loadWithGPSBabel(filename)
+		if gerr != nil {
+			return nil, fmt.Errorf("%v, and with gpsbabel: %w", err, gerr)
+		}
+		return g, nil
+	}
+	return loadWithGPSBabel(filename)
+}
+
+func loadNative(read func(string) (*gpx.GPX, error), filename string) (*gpx.GPX, error) {
+	g, err := read(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i := range g.Tracks {
+		for j := range g.Tracks[i].Segments {
+			seg := &g.Tracks[i].Segments[j]
+			seg.Points = reducePoints(seg.Points, maxTrackPoints)
+		}
+	}
+	return g, nil
+}
+
+func loadWithGPSBabel(filename string) (*gpx.GPX, error) {
 	gf, err := ToGPX(filename)
 	if err != nil {
-		return fmt.Errorf("ToGPX failed %w", err)
+		return nil, fmt.Errorf("ToGPX failed %w", err)
 	}
 	defer func() {
 		os.Remove(gf)
 	}()
 
-	b, err := ioutil.ReadFile(gf)
+	// Parse directly from the file to avoid holding the raw XML in memory.
+	g, err := gpx.ParseFile(longPath(gf))
 	if err != nil {
-		return fmt.Errorf("read gpx file %w", err)
+		return nil, fmt.Errorf("parse gpx file %w", err)
 	}
+	return g, nil
+}
+
+func (u *Uploader) UploadFile(filename string) error {
+	return u.uploadFileAs(filename, filepath.Base(filename))
+}
 
-	g, err := gpx.ParseBytes(b)
+func (u *Uploader) uploadFileAs(filename, name string) error {
+	filename, cleanup, err := prepareTrack(filename)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	g, err := decodeTrack(filename)
 	if err != nil {
-		return fmt.Errorf("parse gpx bytes %w", err)
+		return err
+	}
+
+	src := NewSource(filename)
+	src.Filename = name
+	if a := u.activities[name]; a != nil {
+		a.apply(src)
 	}
 
 	var errAcc error
-	for _, t := range g.Tracks {
-		if err := u.UploadTrack(t); err != nil {
+	err = u.forEachLeg(g, func(t gpx.GPXTrack, leg *Leg) error {
+		legSrc := src
+		if leg != nil {
+			legSrc = src.ForLeg(leg)
+		}
+		if err := u.UploadTrack(legSrc, t); err != nil {
+			if IsUnavailable(err) {
+				return err
+			}
 			err = fmt.Errorf("%v processing track %q", err, t.Name)
 			if errAcc == nil {
 				errAcc = err
@@ -253,14 +679,44 @@ func (u *Uploader) UploadFile(filename string) error {
 				errAcc = fmt.Errorf("%v, %v", errAcc, err)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	return errAcc
 }
 
+// Calls visit with each track that would be logged as an ascent from a file:
+// selected tracks, split into outings at gaps and, with -multi_peak, into a
+// leg per summit. The leg is nil for a track that wasn't split at summits.
+// Stops at the first error.
+func (u *Uploader) forEachLeg(g *gpx.GPX, visit func(t gpx.GPXTrack, leg *Leg) error) error {
+	for _, gt := range g.Tracks {
+		if !TrackSelected(&gt) {
+			continue
+		}
+		for _, outing := range SplitTrack(gt, *splitGap) {
+			tracks, legs, err := u.SplitAtPeaks(outing)
+			if err != nil {
+				return err
+			}
+			for i, t := range tracks {
+				if err := visit(t, legs[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 const HistoryFilename = "history.json"
 
 func (u *Uploader) LoadHistory() error {
-	b, err := ioutil.ReadFile(path.Join(*inputDirectory, HistoryFilename))
+	// Scheduled scans may switch directories, don't carry history across.
+	u.FilenameHistory = make(map[string]*History)
+	b, err := ioutil.ReadFile(longPath(filepath.Join(u.dir, HistoryFilename)))
 	if errors.Is(err, os.ErrNotExist) {
 		return nil
 	}
@@ -271,54 +727,153 @@ func (u *Uploader) LoadHistory() error {
 }
 
 func (u *Uploader) SaveHistory() error {
+	if *offlineSimulate {
+		// Simulated uploads must not mark files as processed.
+		return nil
+	}
 	b, err := json.MarshalIndent(u, "", " ")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path.Join(*inputDirectory, HistoryFilename), b, 0644)
+	return ioutil.WriteFile(longPath(filepath.Join(u.dir, HistoryFilename)), b, 0644)
+}
+
+// Lists supported GPS files in an input directory.
+func ListInputFiles(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(longPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var supported []os.FileInfo
+	for _, fi := range files {
+		if fi.IsDir() || fi.Name() == UploaderLogFilename {
+			continue
+		}
+		if !inputFileSupported(dir, fi.Name()) {
+			// Skip unsupported formats, see ReportSkippedFiles.
+			continue
+		}
+		supported = append(supported, fi)
+	}
+	return supported, nil
+}
+
+// Whether a file in the input directory can be read, by its extension, its
+// format sidecar or its content.
+func inputFileSupported(dir, name string) bool {
+	// Garmin devices name files in upper case, e.g. 9A1B2C3D.FIT.
+	return trackFileSupported(name) || hasFormatSidecar(dir, name) || sniffFormat(filepath.Join(dir, name)) != ""
 }
 
 func (u *Uploader) Run() error {
 	if *inputFile != "" {
-		return u.UploadFile(*inputFile)
+		return u.UploadFileRecovering(*inputFile)
+	}
+	if *stravaExport != "" {
+		return u.RunStravaExport()
+	}
+	if u.source != "" {
+		return u.RunSource()
+	}
+	if *gaiaExport != "" {
+		return u.RunGaiaExport()
 	}
 
-	files, err := ioutil.ReadDir(*inputDirectory)
+	files, err := ListInputFiles(u.dir)
 	if err != nil {
 		return err
 	}
+	if files, err = OrderInputFiles(u.dir, files); err != nil {
+		return err
+	}
 
 	if err := u.LoadHistory(); err != nil {
 		return err
 	}
 
+	var selected []os.FileInfo
 	for _, fi := range files {
-		if fi.IsDir() {
-			continue
-		}
-		if _, ok := extToGPSBabelFormat[path.Ext(fi.Name())]; !ok {
-			// Skip unsupported formats
-			continue
+		if FileSelected(u.dir, fi) {
+			selected = append(selected, fi)
 		}
-		hist, ok := u.FilenameHistory[fi.Name()]
-		if ok && (hist.Error == "" || !*retry) {
-			log.Infof("Skipping already processed file %q", fi.Name())
+	}
+	for _, group := range u.StitchInputFiles(selected) {
+		if len(group) > 1 {
+			if err := u.processStitched(group); err != nil {
+				return err
+			}
 			continue
 		}
-		err := u.UploadFile(path.Join(*inputDirectory, fi.Name()))
-		v := ""
+		fi := group[0]
+		err := u.processInput(fi.Name(), fi, func() error {
+			return u.UploadFileRecovering(filepath.Join(u.dir, fi.Name()))
+		})
 		if err != nil {
-			v = err.Error()
-		}
-		u.FilenameHistory[fi.Name()] = &History{
-			Error: v,
-			Added: time.Now(),
+			return err
 		}
+	}
 
-		if err := u.SaveHistory(); err != nil {
+	archives, err := ListInputArchives(u.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range archives {
+		if err := u.RunArchive(fi); err != nil {
 			return err
 		}
 	}
+	return ReportSkippedFiles(u.dir)
+}
+
+// Whether the history says an input is done: uploaded, or failed without
+// -retry.
+func (u *Uploader) processed(name string) bool {
+	hist, ok := u.FilenameHistory[name]
+	return ok && (hist.Error == "" || !*retry)
+}
+
+// Uploads an input unless the history says it was already processed, then
+// records the outcome in the history. The name keys the history; fi is the
+// file in the input directory, or nil for an archive entry.
+func (u *Uploader) processInput(name string, fi os.FileInfo, upload func() error) error {
+	if u.approvals.HasFile(u.dir, name) {
+		log.Infof("Skipping file %q with ascents awaiting approval", name)
+		return nil
+	}
+	if u.processed(name) {
+		log.Infof("Skipping already processed file %q", name)
+		return nil
+	}
+	since := u.report.Len()
+	err := upload()
+	if fi != nil {
+		if ierr := RecordIndexPeaks(u.dir, fi, matchedPeaks(u.report.entriesSince(since))); ierr != nil {
+			log.Warnf("Failed to record peaks in index: %v", ierr)
+		}
+	}
+	if IsUnavailable(err) {
+		// Not the file's fault, leave it to be retried.
+		return err
+	}
+	if u.approvals.HasFile(u.dir, name) {
+		// Recorded once every track is approved or skipped.
+		return nil
+	}
+	v := ""
+	if err != nil {
+		v = Redact(err.Error())
+	}
+	u.FilenameHistory[name] = &History{
+		Error:    v,
+		Category: ErrorCategory(err),
+		Added:    outputNow(),
+	}
+
+	if err := u.SaveHistory(); err != nil {
+		return err
+	}
+	releaseMemory()
 	return nil
 }
 
@@ -332,20 +887,129 @@ func (u *Uploader) Run() error {
 
 func main() {
 	flag.Parse()
+	cmd, args, err := parseCommand()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// config check reports problems itself, and init replaces the config.
+	if err := LoadConfig(); err != nil && cmd.name != "config" && cmd.name != "init" {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cmd.stage == stageSetup {
+		if err := cmd.run(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Configure logging.
 	customFormatter := new(log.TextFormatter)
 	customFormatter.TimestampFormat = "2006-01-02 15:04:05"
 	customFormatter.FullTimestamp = true
-	log.SetFormatter(customFormatter)
+	log.SetFormatter(&redactingFormatter{customFormatter})
+	RegisterFlagSecrets()
+	log.AddHook(recentLog)
+	if *verbose {
+		log.SetLevel(log.DebugLevel)
+	}
 
 	log.Infof("Started!")
 
+	if err := ConfigureDeterminism(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ConfigureLowMemory()
+
+	stopProfiling, err := StartProfiling()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer stopProfiling()
+	log.RegisterExitHandler(stopProfiling)
+
+	if *bundleFile != "" {
+		if err := WriteBundle(*bundleFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if err := LoadPeakCorrections(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := LoadMatchRegion(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := LoadEnrichments(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := LoadHiddenRegion(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := LoadGeofence(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := LoadDateFilters(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := CheckTripReportFiles(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Commands that don't talk to Peakbagger themselves.
+	if cmd.stage == stageOffline {
+		if err := cmd.run(args); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	closeEvents, err := OpenEvents()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer closeEvents()
+
+	prevRun, err := LoadCompareRun()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	InstallHTTPDebug()
+	InstallUploadThrottle()
+	InstallMaintenanceDetection()
+	InstallPoliteness()
+
 	u, err := NewUploader()
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	if err := u.Run(); err != nil {
+	if c, ok := u.client.(io.Closer); ok {
+		// Drivers running a browser shut it down.
+		defer c.Close()
+		log.RegisterExitHandler(func() { c.Close() })
+	}
+	if cmd.requires != nil {
+		if err := cmd.requires.check(u.client, cmd.name); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	err = cmd.runOnline(u, args)
+	Emit(Event{Type: EventRunFinished}.withError(err))
+	if rerr := u.WriteReport(); rerr != nil {
+		log.Errorf("Failed to write report: %v", rerr)
+	}
+	if prevRun != nil {
+		PrintRunDiff(os.Stdout, prevRun, u.report)
+	}
+	if IsUnavailable(err) {
+		log.Fatalf("Peakbagger appears to be down for maintenance or blocking requests, try again later (%v)", err)
+	}
+	if err != nil {
 		log.Fatalf("%v", err)
 	}
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListInputFilesNonASCII(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"Mont Blanc été.gpx", "富士山.GPX", "Škrlatica.fit", "notes.txt"}
+	for _, name := range names {
+		if err := os.WriteFile(longPath(filepath.Join(dir, name)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ListInputFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range files {
+		got = append(got, fi.Name())
+	}
+	want := []string{"Mont Blanc été.gpx", "Škrlatica.fit", "富士山.GPX"}
+	if len(got) != len(want) {
+		t.Fatalf("ListInputFiles = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ListInputFiles = %q, want %q", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Phrases heading Peakbagger's maintenance and ban pages. These pages are
+// served in place of normal content, which otherwise surfaces as confusing
+// parse errors in the client. Only the title and top heading are checked,
+// since normal pages such as trip reports can mention the phrases.
+var (
+	unavailablePageRe    = regexp.MustCompile(`(?i)down for maintenance|undergoing maintenance|temporarily unavailable|access (has been )?(denied|blocked)|you have been banned`)
+	unavailableHeadingRe = regexp.MustCompile(`(?is)<(title|h1)[^>]*>(.*?)</(title|h1)>`)
+)
+
+// The maintenance or ban phrase heading a page, if any.
+func unavailablePhrase(page []byte) []byte {
+	for _, m := range unavailableHeadingRe.FindAllSubmatch(page, -1) {
+		if phrase := unavailablePageRe.Find(m[2]); phrase != nil {
+			return phrase
+		}
+	}
+	return nil
+}
+
+const unavailableMessage = "peakbagger unavailable"
+
+// Returned for requests answered with a maintenance or ban page.
+type UnavailableError struct {
+	Reason string
+	// How long Peakbagger asked us to wait, if it said.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s", unavailableMessage, e.Reason)
+}
+
+// Whether an error was caused by Peakbagger being unavailable. Also matches
+// on the message since the client doesn't always wrap errors.
+func IsUnavailable(err error) bool {
+	var ue *UnavailableError
+	return err != nil && (errors.As(err, &ue) || strings.Contains(err.Error(), unavailableMessage))
+}
+
+// Parses a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Turns Peakbagger maintenance and ban pages into UnavailableError.
+func InstallMaintenanceDetection() {
+	wrapDefaultTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil || !isPeakbagger(req) {
+				return resp, err
+			}
+			switch resp.StatusCode {
+			case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+				resp.Body.Close()
+				return nil, &UnavailableError{Reason: resp.Status, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+			case http.StatusForbidden:
+				resp.Body.Close()
+				return nil, &UnavailableError{Reason: "access forbidden, possibly banned"}
+			}
+			if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+				return resp, nil
+			}
+
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			if m := unavailablePhrase(b); m != nil {
+				return nil, &UnavailableError{Reason: string(m)}
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(b))
+			return resp, nil
+		})
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+const ManifestFilename = "manifest.json"
+
+// Manifest of the ascents -offline_simulate would have added, kept apart
+// since they don't exist on Peakbagger. Each simulated run replaces it.
+const SimulatedManifestFilename = "manifest.simulated.json"
+
+// Directories whose simulated manifest was written by this run.
+var simulatedManifests = make(map[string]bool)
+
+// An ascent created by this tool, recorded so it can be found again later.
+type ManifestEntry struct {
+	AscentID peakbagger.AscentID
+	PeakID   string
+	Date     time.Time
+	File     string
+	Track    string `json:",omitempty"`
+	Added    time.Time
+
+	// Summary for exports like the calendar, absent for older entries.
+	PeakName string        `json:",omitempty"`
+	TimeUp   time.Duration `json:",omitempty"`
+	TimeDown time.Duration `json:",omitempty"`
+	// Elevation gain in meters.
+	Gain float64 `json:",omitempty"`
+	// Location of the peak.
+	Latitude  float64 `json:",omitempty"`
+	Longitude float64 `json:",omitempty"`
+
+	// Added with -private_ascents.
+	Private bool `json:",omitempty"`
+	// The track entered -hide_gpx_region, so the location is sensitive.
+	Hidden bool `json:",omitempty"`
+}
+
+// Ascents created from the files in a directory.
+type Manifest struct {
+	Ascents []*ManifestEntry
+
+	dir string
+}
+
+func manifestFilename() string {
+	if *offlineSimulate {
+		return SimulatedManifestFilename
+	}
+	return ManifestFilename
+}
+
+func LoadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{dir: dir}
+	if *offlineSimulate && !simulatedManifests[dir] {
+		return m, nil
+	}
+	b, err := os.ReadFile(longPath(filepath.Join(dir, manifestFilename())))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("parse %s %w", manifestFilename(), err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) Save() error {
+	b, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(longPath(filepath.Join(m.dir, manifestFilename())), b, 0644); err != nil {
+		return err
+	}
+	if *offlineSimulate {
+		simulatedManifests[m.dir] = true
+	}
+	return nil
+}
+
+// Summarizes the recorded stats, e.g. "2h15m0s up, 9186 ft gain".
+func (e *ManifestEntry) Stats() string {
+	var stats []string
+	if e.TimeUp > 0 {
+		stats = append(stats, fmt.Sprintf("%v up", e.TimeUp.Round(time.Minute)))
+	}
+	if e.TimeDown > 0 {
+		stats = append(stats, fmt.Sprintf("%v down", e.TimeDown.Round(time.Minute)))
+	}
+	if e.Gain > 0 {
+		stats = append(stats, formatElevation(e.Gain)+" gain")
+	}
+	return strings.Join(stats, ", ")
+}
+
+// Returns the Peakbagger page of an ascent.
+func ascentURL(id peakbagger.AscentID) string {
+	return fmt.Sprintf("%s/climber/ascent.aspx?aid=%d", peakbaggerURL, id)
+}
+
+// Finds the entry for an ascent of a peak on the same local date.
+func (m *Manifest) Find(peakID string, date time.Time) *ManifestEntry {
+	day := ascentDay(date.Local())
+	for _, e := range m.Ascents {
+		if e.PeakID == peakID && ascentDay(e.Date.Local()) == day {
+			return e
+		}
+	}
+	return nil
+}
+
+// Records an added ascent in the input directory's manifest.
+func RecordManifest(dir string, id peakbagger.AscentID, prep *PreparedAscent, entry *ReportEntry) error {
+	a := &prep.Ascent
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	m.Ascents = append(m.Ascents, &ManifestEntry{
+		AscentID:  id,
+		PeakID:    fmt.Sprint(a.PeakID),
+		Date:      *a.Date,
+		File:      entry.File,
+		Track:     entry.Track,
+		Added:     outputNow(),
+		PeakName:  entry.PeakName,
+		TimeUp:    a.TimeUp,
+		TimeDown:  a.TimeDown,
+		Gain:      entry.Gain,
+		Latitude:  prep.Latitude,
+		Longitude: prep.Longitude,
+		Private:   *privateAscents,
+		Hidden:    prep.Hidden,
+	})
+	return m.Save()
+}
+
+// Re-renders the trip report of every ascent in the manifest with the current
+// template and settings, and updates the ascents whose report changed.
+func (u *Uploader) RetrofitReports() error {
+	// Checked by the retrofit-reports command before it runs.
+	updater := u.client.(AscentUpdater)
+	m, err := LoadManifest(u.dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, e := range m.Ascents {
+		if !seen[e.File] {
+			seen[e.File] = true
+			files = append(files, e.File)
+		}
+	}
+	log.Infof("Retrofitting trip reports for %d ascents from %d files", len(m.Ascents), len(files))
+
+	for _, file := range files {
+		filename := filepath.Join(m.dir, file)
+		g, err := LoadGPX(filename)
+		if err != nil {
+			log.Warnf("Skipping %q: %v", filename, err)
+			continue
+		}
+		src := NewSource(filename)
+		for _, gt := range g.Tracks {
+			for _, outing := range SplitTrack(gt, *splitGap) {
+				tracks, legs, err := u.SplitAtPeaks(outing)
+				if err != nil {
+					return err
+				}
+				for i, t := range tracks {
+					legSrc := src
+					if legs[i] != nil {
+						legSrc = src.ForLeg(legs[i])
+					}
+					err := u.retrofitReport(updater, m, legSrc, t)
+					if IsUnavailable(err) {
+						return err
+					}
+					if err != nil {
+						log.Warnf("Failed to retrofit report for %q from %q: %v", t.Name, filename, err)
+					}
+				}
+			}
+		}
+		releaseMemory()
+	}
+	return nil
+}
+
+func (u *Uploader) retrofitReport(updater AscentUpdater, m *Manifest, src *Source, t gpx.GPXTrack) (err error) {
+	entry := u.report.NewEntry(src, &t)
+	defer func() {
+		entry.Finish(err)
+	}()
+
+	prep, err := u.PrepareAscent(src, t, entry)
+	if err != nil {
+		return err
+	}
+	peakID := fmt.Sprint(prep.Ascent.PeakID)
+	if m.Find(peakID, *prep.Ascent.Date) == nil {
+		log.Infof("Ascent of %q on %s wasn't added by this tool, skipping", prep.PeakName, ascentDay(prep.Ascent.Date.Local()))
+		return nil
+	}
+
+	ascents, err := u.Ascents()
+	if err != nil {
+		return fmt.Errorf("list ascents %w", err)
+	}
+	var existing *peakbagger.Ascent
+	for _, a := range ascents {
+		if a.Date != nil && fmt.Sprint(a.PeakID) == peakID && ascentDay(a.Date.Local()) == ascentDay(prep.Ascent.Date.Local()) {
+			existing = a
+			break
+		}
+	}
+	if existing == nil {
+		return fmt.Errorf("ascent of %q on %s is no longer logged", prep.PeakName, ascentDay(prep.Ascent.Date.Local()))
+	}
+	if existing.TripReport == prep.Ascent.TripReport {
+		log.Infof("Trip report for %q is unchanged", prep.PeakName)
+		return nil
+	}
+
+	log.Infof("Updating trip report for %q on %s", prep.PeakName, ascentDay(existing.Date.Local()))
+	if *dryRun {
+		log.Infof("DRY RUN, skipping ascent update")
+		return nil
+	}
+	updated := *existing
+	updated.TripReport = prep.Ascent.TripReport
+	if err := updater.UpdateAscent(existing, updated); err != nil {
+		return fmt.Errorf("failed to update ascent %w", err)
+	}
+	u.ascents = nil
+	entry.Uploaded = true
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Radius around a high point searched for peaks.
+const peakSearchRadius = 1000 * metersPerFoot
+
+// Returns peaks near a point, sorted by distance with the closest first.
+func (u *Uploader) CandidatePeaks(lat, lng float64) (peakbagger.PeakList, error) {
+	return u.peaksWithin(lat, lng, peakSearchRadius)
+}
+
+// Returns peaks within radius meters of a point, closest first.
+func (u *Uploader) peaksWithin(lat, lng, radius float64) (peakbagger.PeakList, error) {
+	var peaks peakbagger.PeakList
+	for _, bounds := range SearchBounds(lat, lng, radius) {
+		bounds := bounds
+		found, err := u.client.FindPeaks(&bounds)
+		if err != nil {
+			return nil, fmt.Errorf("find peaks %w", err)
+		}
+		peaks = append(peaks, found...)
+	}
+	peaks = append(peaks, correctedPeaksNear(lat, lng, radius, peaks)...)
+	CleanPeakNames(peaks)
+	ApplyPeakCorrections(peaks)
+	peaks = FilterPeaksByRegion(peaks)
+
+	// Sort by closest to our highest point, breaking ties by ID so the match
+	// doesn't depend on the order search results came back in.
+	sort.Slice(peaks, func(i, j int) bool {
+		di := Distance(peaks[i].Latitude, peaks[i].Longitude, lat, lng)
+		dj := Distance(peaks[j].Latitude, peaks[j].Longitude, lat, lng)
+		if di != dj {
+			return di < dj
+		}
+		return peaks[i].PeakID < peaks[j].PeakID
+	})
+	return peaks, nil
+}
+
+// Moves the first candidate peak named in a track or activity title to the
+// front, e.g. "Rainier via DC" picks Mount Rainier over a closer subpeak.
+func preferTitledPeak(peaks peakbagger.PeakList, titles ...string) peakbagger.PeakList {
+	if len(peaks) < 2 {
+		return peaks
+	}
+	for i, p := range peaks {
+		name := peakTitleKey(p.Name)
+		if len(name) < 3 {
+			continue
+		}
+		for _, title := range titles {
+			if !strings.Contains(peakTitleKey(title), name) {
+				continue
+			}
+			if i > 0 {
+				log.Infof("Title %q names %q, preferring it", title, p.Name)
+				sorted := peakbagger.PeakList{p}
+				sorted = append(sorted, peaks[:i]...)
+				peaks = append(sorted, peaks[i+1:]...)
+			}
+			return peaks
+		}
+	}
+	return peaks
+}
+
+// Lowercases a name and drops "Mount" and punctuation, so titles match names
+// however the mountain is abbreviated.
+func peakTitleKey(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var kept []string
+	for _, w := range words {
+		switch w {
+		case "mount", "mt", "mtn", "mont", "monte":
+			continue
+		}
+		kept = append(kept, w)
+	}
+	return " " + strings.Join(kept, " ") + " "
+}
+
+// Describes a candidate peak for interactive choices: elevation and
+// prominence from its page, previous ascents and distance from the high
+// point. Page details are left out if the page can't be fetched.
+func (u *Uploader) peakFactSheet(p *peakbagger.Peak, lat, lng float64) string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+	if info, err := u.PeakInfo(fmt.Sprint(p.PeakID)); err != nil {
+		log.Debugf("No page details for %q: %v", p.Name, err)
+	} else {
+		if info.Elevation > 0 {
+			fmt.Fprintf(&b, ", %s", formatElevation(info.Elevation))
+		}
+		if info.Prominence > 0 {
+			fmt.Fprintf(&b, ", %s prominence", formatElevation(info.Prominence))
+		}
+	}
+	fmt.Fprintf(&b, ", %.0fm from the high point", Distance(p.Latitude, p.Longitude, lat, lng))
+
+	if ascents, err := u.Ascents(); err == nil {
+		var n int
+		var last time.Time
+		for _, a := range ascents {
+			if a.PeakID != p.PeakID || a.Date == nil {
+				continue
+			}
+			n++
+			if a.Date.After(last) {
+				last = *a.Date
+			}
+		}
+		switch n {
+		case 0:
+			b.WriteString("\n     not climbed before")
+		case 1:
+			fmt.Fprintf(&b, "\n     climbed once before, on %s", last.Local().Format("2006-01-02"))
+		default:
+			fmt.Fprintf(&b, "\n     climbed %d times before, last on %s", n, last.Local().Format("2006-01-02"))
+		}
+	}
+	fmt.Fprintf(&b, "\n     %s/peak.aspx?pid=%d", peakbaggerURL, p.PeakID)
+	return b.String()
+}
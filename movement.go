@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	paceInTripReport = flag.Bool("trip_report_pace", false, "Include ascent pace, descent speed and distance in trip reports")
+)
+
+const (
+	// Slower than this between points counts as stopped.
+	movingSpeed = 0.3
+	// Minimum window for max speed, so a single GPS jump doesn't count.
+	maxSpeedWindow = time.Minute
+)
+
+// Time and elevation change over one mile of a track.
+type Split struct {
+	Mile     int
+	Duration time.Duration
+	// Gain and loss in meters.
+	Gain float64
+	Loss float64
+}
+
+// Movement analytics for a track. Distances are in meters, speeds in meters
+// per second and vertical rates in meters per hour.
+type MovementStats struct {
+	Distance   float64
+	Duration   time.Duration
+	MovingTime time.Duration
+
+	AvgSpeed float64
+	MaxSpeed float64
+
+	// Vertical rate from the start to the summit, over moving time.
+	AscentRate float64
+	// Horizontal speed and vertical rate from the summit to the end.
+	DescentSpeed float64
+	DescentRate  float64
+
+	Splits []Split
+}
+
+// Computes movement stats. The summit splits the track into ascent and
+// descent, and may be nil to skip those.
+func ComputeMovementStats(points []gpx.GPXPoint, summit *gpx.GPXPoint) *MovementStats {
+	if len(points) < 2 {
+		return nil
+	}
+	s := &MovementStats{Duration: points[len(points)-1].Timestamp.Sub(points[0].Timestamp)}
+	summitIdx := -1
+	if summit != nil {
+		summitIdx = pointIndex(points, summit)
+	}
+
+	var upMoving, downMoving time.Duration
+	var upVert, downVert, downDist float64
+	split := Split{Mile: 1}
+	splitStart := points[0].Timestamp
+	j := 0
+	for i := 1; i < len(points); i++ {
+		a, b := &points[i-1], &points[i]
+		d := pointDistance(a, b)
+		dt := b.Timestamp.Sub(a.Timestamp)
+		s.Distance += d
+		moving := dt > 0 && d/dt.Seconds() >= movingSpeed
+		if moving {
+			s.MovingTime += dt
+		}
+
+		var dz float64
+		if a.Elevation.NotNull() && b.Elevation.NotNull() {
+			dz = b.Elevation.Value() - a.Elevation.Value()
+		}
+		if dz > 0 {
+			split.Gain += dz
+		} else {
+			split.Loss -= dz
+		}
+
+		if summitIdx >= 0 {
+			if i <= summitIdx {
+				upVert += dz
+				if moving {
+					upMoving += dt
+				}
+			} else {
+				downVert -= dz
+				downDist += d
+				if moving {
+					downMoving += dt
+				}
+			}
+		}
+
+		if s.Distance >= float64(split.Mile)*metersPerMile {
+			split.Duration = b.Timestamp.Sub(splitStart)
+			s.Splits = append(s.Splits, split)
+			split = Split{Mile: split.Mile + 1}
+			splitStart = b.Timestamp
+		}
+
+		// Speed over the shortest window of at least maxSpeedWindow ending here.
+		for j < i-1 && b.Timestamp.Sub(points[j+1].Timestamp) >= maxSpeedWindow {
+			j++
+		}
+		if w := b.Timestamp.Sub(points[j].Timestamp); w >= maxSpeedWindow {
+			if v := pointDistance(&points[j], b) / w.Seconds(); v > s.MaxSpeed {
+				s.MaxSpeed = v
+			}
+		}
+	}
+
+	if s.MovingTime > 0 {
+		s.AvgSpeed = s.Distance / s.MovingTime.Seconds()
+	}
+	if upMoving > 0 {
+		s.AscentRate = upVert / upMoving.Hours()
+	}
+	if downMoving > 0 {
+		s.DescentSpeed = downDist / downMoving.Seconds()
+		s.DescentRate = downVert / downMoving.Hours()
+	}
+	return s
+}
+
+// Prints stats for every track in the input without matching peaks or
+// uploading: the analyze command.
+func Analyze() error {
+	var files []string
+	if *inputFile != "" {
+		files = append(files, *inputFile)
+	} else {
+		fis, err := ListInputFiles(*inputDirectory)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if FileSelected(*inputDirectory, fi) {
+				files = append(files, filepath.Join(*inputDirectory, fi.Name()))
+			}
+		}
+	}
+
+	for _, filename := range files {
+		g, err := LoadGPX(filename)
+		if err != nil {
+			log.Warnf("Skipping %q: %v", filename, err)
+			continue
+		}
+		for _, gt := range g.Tracks {
+			if !TrackSelected(&gt) {
+				continue
+			}
+			for _, t := range SplitTrack(gt, *splitGap) {
+				t, _ = orientTrack(t)
+				t, _ = repairTimestamps(t)
+				printAnalysis(os.Stdout, filename, &t)
+			}
+		}
+		releaseMemory()
+	}
+	return nil
+}
+
+func printAnalysis(w io.Writer, filename string, t *gpx.GPXTrack) {
+	points := trackPoints(t)
+	tb, err := ToTrackBounds(*t)
+	if err != nil {
+		fmt.Fprintf(w, "%s %q: %v\n\n", filepath.Base(filename), t.Name, err)
+		return
+	}
+	gain, err := ComputeGain(points)
+	if err != nil {
+		log.Warnf("Elevation gain: %v", err)
+	}
+	m := ComputeMovementStats(points, tb.Highest)
+	if m == nil {
+		return
+	}
+
+	mph := func(v float64) float64 { return v * 3600 / metersPerMile }
+	fmt.Fprintf(w, "%s %q, %s\n", filepath.Base(filename), t.Name, tb.Highest.Timestamp.Local().Format("2006-01-02"))
+	fmt.Fprintf(w, "  Distance %.1f mi in %v, moving %v\n", m.Distance/metersPerMile, m.Duration, m.MovingTime)
+	fmt.Fprintf(w, "  Gain %.0f ft, high point %.0f ft\n", gain/metersPerFoot, tb.Highest.Elevation.Value()/metersPerFoot)
+	fmt.Fprintf(w, "  Speed avg %.1f mph, max %.1f mph\n", mph(m.AvgSpeed), mph(m.MaxSpeed))
+	fmt.Fprintf(w, "  Ascent pace %.0f ft/hr, descent %.1f mph at %.0f ft/hr\n", m.AscentRate/metersPerFoot, mph(m.DescentSpeed), m.DescentRate/metersPerFoot)
+	if hr := ComputeHeartRate(points); hr != nil {
+		fmt.Fprintf(w, "  Heart rate avg %.0f, max %.0f bpm, effort %.0f\n", hr.Avg, hr.Max, hr.Effort)
+	}
+	if candidates := HighPointCandidates(points, *summitCandidates); len(candidates) > 1 {
+		fmt.Fprintf(w, "  High point candidates:\n")
+		for i, c := range candidates {
+			fmt.Fprintf(w, "    %d) %s\n", i+1, formatCandidate(c))
+		}
+	}
+	for _, s := range m.Splits {
+		fmt.Fprintf(w, "  Mile %d: %v, +%.0f/-%.0f ft\n", s.Mile, s.Duration.Round(time.Second), s.Gain/metersPerFoot, s.Loss/metersPerFoot)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	multiPeak = flag.Bool("multi_peak", false, "Log an ascent for every peak a track summits, not just its highest point")
+	// Bumps along a ridge smaller than this aren't separate summits.
+	minSummitProminence = flag.Float64("min_summit_prominence", 30, "Minimum rise and drop in meters around a high point for it to count as a separate summit with -multi_peak")
+)
+
+// Position of an ascent within a multi-peak track.
+type Leg struct {
+	Number int
+	Count  int
+	// Name of the previous summit, empty for the first leg.
+	PreviousSummit string
+	// Distance along the track from the previous summit, or from the start of
+	// the track for the first leg, in meters.
+	Distance float64
+	// Descent from the previous summit to the intervening col and the climb
+	// back up to this summit, in meters. Zero for the first leg.
+	Drop   float64
+	Regain float64
+}
+
+// Returns the indices of high points with at least prom meters of rise before
+// and drop after them within the track.
+func trackSummits(points []gpx.GPXPoint, prom float64) []int {
+	var summits []int
+	climbing := false
+	low, high := -1, -1
+	for i := range points {
+		if points[i].Elevation.Null() {
+			continue
+		}
+		e := points[i].Elevation.Value()
+		if low < 0 {
+			low = i
+			continue
+		}
+		if climbing {
+			if e > points[high].Elevation.Value() {
+				high = i
+			} else if points[high].Elevation.Value()-e >= prom {
+				summits = append(summits, high)
+				climbing, low = false, i
+			}
+		} else {
+			if e < points[low].Elevation.Value() {
+				low = i
+			} else if e-points[low].Elevation.Value() >= prom {
+				climbing, high = true, i
+			}
+		}
+	}
+	// A track may end on a summit, e.g. a ride down.
+	if climbing {
+		summits = append(summits, high)
+	}
+	return summits
+}
+
+// Index of the lowest point between two indices.
+func lowestBetween(points []gpx.GPXPoint, from, to int) int {
+	low := from
+	for i := from; i <= to; i++ {
+		if points[i].Elevation.NotNull() && points[i].Elevation.Value() < points[low].Elevation.Value() {
+			low = i
+		}
+	}
+	return low
+}
+
+// Splits a track into one leg per summited peak, cutting at the lowest point
+// between consecutive summits, so each ascent's gain and times only cover
+// that peak's climb. Tracks with a single peak are returned whole with a nil
+// leg.
+func (u *Uploader) SplitAtPeaks(t gpx.GPXTrack) ([]gpx.GPXTrack, []*Leg, error) {
+	whole := []gpx.GPXTrack{t}
+	if !*multiPeak {
+		return whole, []*Leg{nil}, nil
+	}
+	t, _ = orientTrack(t)
+	t, _ = repairTimestamps(t)
+	points := trackPoints(&t)
+
+	type summit struct {
+		index  int
+		peakID string
+		name   string
+	}
+	var summits []summit
+	for _, i := range trackSummits(points, *minSummitProminence) {
+		peaks, err := u.CandidatePeaks(points[i].Latitude, points[i].Longitude)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(peaks) == 0 {
+			continue
+		}
+		s := summit{index: i, peakID: fmt.Sprint(peaks[0].PeakID), name: peaks[0].Name}
+		// Repeated summits of the same peak, e.g. a false summit dip, count once.
+		if n := len(summits); n > 0 && summits[n-1].peakID == s.peakID {
+			if points[i].Elevation.Value() > points[summits[n-1].index].Elevation.Value() {
+				summits[n-1] = s
+			}
+			continue
+		}
+		summits = append(summits, s)
+	}
+	if len(summits) < 2 {
+		return whole, []*Leg{nil}, nil
+	}
+
+	var tracks []gpx.GPXTrack
+	var legs []*Leg
+	start, from := 0, 0
+	for k, s := range summits {
+		end := len(points) - 1
+		if k+1 < len(summits) {
+			end = lowestBetween(points, s.index, summits[k+1].index)
+		}
+
+		leg := &Leg{Number: k + 1, Count: len(summits)}
+		if k > 0 {
+			leg.PreviousSummit = summits[k-1].name
+			col := points[start].Elevation.Value()
+			leg.Drop = points[summits[k-1].index].Elevation.Value() - col
+			leg.Regain = points[s.index].Elevation.Value() - col
+		}
+		for i := from + 1; i <= s.index; i++ {
+			leg.Distance += pointDistance(&points[i-1], &points[i])
+		}
+
+		lt := t
+		lt.Name = fmt.Sprintf("%s (%s)", t.Name, s.name)
+		lt.Segments = []gpx.GPXTrackSegment{{Points: append([]gpx.GPXPoint(nil), points[start:end+1]...)}}
+		tracks = append(tracks, lt)
+		legs = append(legs, leg)
+		start, from = end, s.index
+	}
+	log.Infof("Track %q summits %d peaks, logging each separately", t.Name, len(summits))
+	return tracks, legs, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Normalizes a peak name scraped from Peakbagger into clean UTF-8. Names can
+// arrive HTML-escaped, in Windows-1252, or as UTF-8 that was mis-decoded as
+// Windows-1252 ("HÃ¸gste" instead of "Høgste").
+func CleanName(s string) string {
+	s = html.UnescapeString(s)
+
+	if !utf8.ValidString(s) {
+		if d, err := charmap.Windows1252.NewDecoder().String(s); err == nil {
+			s = d
+		}
+	}
+
+	// Repair mojibake by re-encoding to the original bytes, keeping the result
+	// only if it is valid UTF-8 containing multi-byte characters.
+	if strings.ContainsAny(s, "ÃÂÅÆÐÑØ") {
+		if b, err := charmap.Windows1252.NewEncoder().String(s); err == nil && utf8.ValidString(b) && len(b) > utf8.RuneCountInString(b) {
+			s = b
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func CleanPeakNames(peaks peakbagger.PeakList) {
+	for i := range peaks {
+		peaks[i].Name = CleanName(peaks[i].Name)
+	}
+}
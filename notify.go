@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ntfyTopic     = flag.String("ntfy_topic", "", "Send push notifications to this ntfy.sh topic, or a full topic URL on another ntfy server")
+	pushoverToken = flag.String("pushover_token", "", "Pushover application token for push notifications")
+	pushoverUser  = flag.String("pushover_user", "", "Pushover user key for push notifications")
+
+	// The approval server usually listens on a LAN address that isn't what the
+	// phone should open, e.g. behind a reverse proxy or VPN.
+	approvalURL = flag.String("approval_url", "", "Approval page URL used as the link in notifications (default derived from -approval_addr)")
+)
+
+// Sends a push notification to every configured service. The link is opened
+// when the notification is tapped. Failures are logged, not returned, since a
+// missed notification shouldn't stop uploads.
+func Notify(title, message, link string) {
+	if *ntfyTopic != "" {
+		if err := notifyNtfy(title, message, link); err != nil {
+			log.Warnf("Failed to send ntfy notification: %v", err)
+		}
+	}
+	if *pushoverToken != "" || *pushoverUser != "" {
+		if err := notifyPushover(title, message, link); err != nil {
+			log.Warnf("Failed to send Pushover notification: %v", err)
+		}
+	}
+}
+
+func notifyNtfy(title, message, link string) error {
+	u := *ntfyTopic
+	if !strings.Contains(u, "://") {
+		u = "https://ntfy.sh/" + u
+	}
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	if link != "" {
+		req.Header.Set("Click", link)
+	}
+	resp, err := apiClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ntfy: %s", resp.Status)
+	}
+	return nil
+}
+
+func notifyPushover(title, message, link string) error {
+	if *pushoverToken == "" || *pushoverUser == "" {
+		return fmt.Errorf("both -pushover_token and -pushover_user are required")
+	}
+	form := url.Values{
+		"token":   {*pushoverToken},
+		"user":    {*pushoverUser},
+		"title":   {title},
+		"message": {message},
+	}
+	if link != "" {
+		form.Set("url", link)
+	}
+	resp, err := apiClient().PostForm("https://api.pushover.net/1/messages.json", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pushover: %s", resp.Status)
+	}
+	return nil
+}
+
+// Link to the approval page for notifications, if it is being served.
+func approvalLink() string {
+	if *approvalURL != "" {
+		return *approvalURL
+	}
+	if *approvalAddr == "" {
+		return ""
+	}
+	addr := *approvalAddr
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr + "/"
+}
+
+// Notifies about tracks that failed after the report had the given number of
+// entries, and ascents queued for approval beyond pendingBefore.
+func (u *Uploader) NotifyResults(since int, pendingBefore int) {
+	var failed []string
+	for _, e := range u.report.entriesSince(since) {
+		if e.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", e.File, e.Error))
+		}
+	}
+	if len(failed) > 0 {
+		Notify(fmt.Sprintf("%d tracks failed to upload", len(failed)), strings.Join(failed, "\n"), "")
+	}
+
+	if u.approvals == nil {
+		return
+	}
+	if n := u.approvals.added() - pendingBefore; n > 0 {
+		Notify(fmt.Sprintf("%d new ascents awaiting approval", n), "Tap to approve or skip them.", approvalLink())
+	}
+}
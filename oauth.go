@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	oauthTokensFile = flag.String("oauth_tokens", "", "File storing OAuth tokens for cloud sources (default tokens.json in the user config directory)")
+	oauthPort       = flag.Int("oauth_port", 8976, "Local port receiving the OAuth redirect during the auth command; register http://127.0.0.1:<port>/callback with the provider")
+
+	stravaClientID     = flag.String("strava_client_id", "", "Strava API application client ID")
+	stravaClientSecret = flag.String("strava_client_secret", "", "Strava API application client secret")
+	dropboxAppKey      = flag.String("dropbox_app_key", "", "Dropbox app key")
+	garminClientID     = flag.String("garmin_client_id", "", "Garmin Connect developer program consumer key")
+	garminClientSecret = flag.String("garmin_client_secret", "", "Garmin Connect developer program consumer secret")
+	suuntoClientID     = flag.String("suunto_client_id", "", "Suunto API Zone application client ID")
+	suuntoClientSecret = flag.String("suunto_client_secret", "", "Suunto API Zone application client secret")
+	polarClientID      = flag.String("polar_client_id", "", "Polar AccessLink client ID")
+	polarClientSecret  = flag.String("polar_client_secret", "", "Polar AccessLink client secret")
+)
+
+// An OAuth 2 authorization code provider.
+type oauthProvider struct {
+	AuthURL  string
+	TokenURL string
+	Scope    string
+
+	ClientID     *string
+	ClientSecret *string
+	ClientFlag   string
+
+	// Public clients prove possession with PKCE instead of a secret.
+	PKCE bool
+	// Send the client credentials with HTTP basic auth rather than in the
+	// token request form.
+	BasicAuth bool
+	// Extra authorization parameters, e.g. to request a refresh token.
+	AuthParams url.Values
+}
+
+var oauthProviders = map[string]*oauthProvider{
+	"strava": {
+		AuthURL:      "https://www.strava.com/oauth/authorize",
+		TokenURL:     "https://www.strava.com/oauth/token",
+		Scope:        "read,activity:read_all",
+		ClientID:     stravaClientID,
+		ClientSecret: stravaClientSecret,
+		ClientFlag:   "-strava_client_id and -strava_client_secret",
+		AuthParams:   url.Values{"approval_prompt": {"auto"}},
+	},
+	"dropbox": {
+		AuthURL:      "https://www.dropbox.com/oauth2/authorize",
+		TokenURL:     "https://api.dropboxapi.com/oauth2/token",
+		Scope:        "files.content.read",
+		ClientID:     dropboxAppKey,
+		ClientSecret: new(string),
+		ClientFlag:   "-dropbox_app_key",
+		PKCE:         true,
+		AuthParams:   url.Values{"token_access_type": {"offline"}},
+	},
+	"garmin": {
+		AuthURL:      "https://connect.garmin.com/oauth2Confirm",
+		TokenURL:     "https://diauth.garmin.com/di-oauth2-service/oauth/token",
+		ClientID:     garminClientID,
+		ClientSecret: garminClientSecret,
+		ClientFlag:   "-garmin_client_id and -garmin_client_secret",
+		PKCE:         true,
+	},
+	"suunto": {
+		AuthURL:      "https://cloudapi-oauth.suunto.com/oauth/authorize",
+		TokenURL:     "https://cloudapi-oauth.suunto.com/oauth/token",
+		Scope:        "workout",
+		ClientID:     suuntoClientID,
+		ClientSecret: suuntoClientSecret,
+		ClientFlag:   "-suunto_client_id and -suunto_client_secret",
+	},
+	"polar": {
+		AuthURL:      "https://flow.polar.com/oauth2/authorization",
+		TokenURL:     "https://polarremote.com/v2/oauth2/token",
+		Scope:        "accesslink.read_all",
+		ClientID:     polarClientID,
+		ClientSecret: polarClientSecret,
+		ClientFlag:   "-polar_client_id and -polar_client_secret",
+		BasicAuth:    true,
+	},
+}
+
+type OAuthToken struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+	// ID of the authorized user, for providers that return one with the
+	// token, e.g. Polar.
+	UserID string `json:",omitempty"`
+}
+
+// Serializes token file access, since cloud sources may refresh tokens
+// concurrently.
+var oauthTokensMu sync.Mutex
+
+func oauthTokensPath() (string, error) {
+	if *oauthTokensFile != "" {
+		return *oauthTokensFile, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "peakbagger-bulk-uploader", "tokens.json"), nil
+}
+
+func loadOAuthTokens() (map[string]*OAuthToken, error) {
+	p, err := oauthTokensPath()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]*OAuthToken)
+	b, err := os.ReadFile(longPath(p))
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("parse %s %w", p, err)
+	}
+	for _, t := range tokens {
+		RegisterSecret(t.AccessToken)
+		RegisterSecret(t.RefreshToken)
+	}
+	return tokens, nil
+}
+
+// Tokens are only readable by the owner, like an ssh key.
+func saveOAuthTokens(tokens map[string]*OAuthToken) error {
+	p, err := oauthTokensPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(p)), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(tokens, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(longPath(p), b, 0600)
+}
+
+func lookupOAuthProvider(name string) (*oauthProvider, error) {
+	p, ok := oauthProviders[name]
+	if !ok {
+		var names []string
+		for n := range oauthProviders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown auth provider %q, available providers: %s", name, strings.Join(names, ", "))
+	}
+	if *p.ClientID == "" {
+		return nil, fmt.Errorf("%s auth requires %s", name, p.ClientFlag)
+	}
+	return p, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Debugf("Failed to open browser: %v", err)
+	}
+}
+
+// Runs the browser authorization flow for a provider and stores the
+// resulting tokens: auth <provider>.
+func RunAuthCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: auth <provider>")
+	}
+	name := args[0]
+	p, err := lookupOAuthProvider(name)
+	if err != nil {
+		return err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *oauthPort))
+	if err != nil {
+		return fmt.Errorf("listen for oauth redirect %w", err)
+	}
+	redirect := fmt.Sprintf("http://127.0.0.1:%d/callback", *oauthPort)
+
+	q := url.Values{
+		"client_id":     {*p.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {redirect},
+		"scope":         {p.Scope},
+		"state":         {state},
+	}
+	for k, v := range p.AuthParams {
+		q[k] = v
+	}
+	if p.PKCE {
+		sum := sha256.Sum256([]byte(verifier))
+		q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+		q.Set("code_challenge_method", "S256")
+	}
+	authURL := p.AuthURL + "?" + q.Encode()
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		if e := r.FormValue("error"); e != "" {
+			fmt.Fprintf(w, "Authorization failed: %s. You can close this window.", e)
+			errs <- fmt.Errorf("authorization denied: %s", e)
+			return
+		}
+		fmt.Fprintf(w, "Authorized peakbagger-bulk-uploader for %s. You can close this window.", name)
+		codes <- r.FormValue("code")
+	})}
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+
+	fmt.Printf("Open this URL to authorize access to %s:\n\n%s\n\n", name, authURL)
+	openBrowser(authURL)
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-time.After(10 * time.Minute):
+		return fmt.Errorf("timed out waiting for authorization")
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirect},
+	}
+	if p.PKCE {
+		form.Set("code_verifier", verifier)
+	}
+	tok, err := p.exchange(form)
+	if err != nil {
+		return err
+	}
+
+	oauthTokensMu.Lock()
+	defer oauthTokensMu.Unlock()
+	tokens, err := loadOAuthTokens()
+	if err != nil {
+		return err
+	}
+	tokens[name] = tok
+	if err := saveOAuthTokens(tokens); err != nil {
+		return err
+	}
+	log.Infof("Stored %s tokens", name)
+	return nil
+}
+
+// Posts a token request, returning the token from the response.
+func (p *oauthProvider) exchange(form url.Values) (*OAuthToken, error) {
+	RegisterSecret(form.Get("code"))
+	if !p.BasicAuth {
+		form.Set("client_id", *p.ClientID)
+		if *p.ClientSecret != "" {
+			form.Set("client_secret", *p.ClientSecret)
+		}
+	}
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.BasicAuth {
+		req.SetBasicAuth(*p.ClientID, *p.ClientSecret)
+	}
+	resp, err := apiClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		// Strava also returns an absolute expiry.
+		ExpiresAt int64  `json:"expires_at"`
+		Error     string `json:"error"`
+		// Polar identifies the user.
+		UserID json.Number `json:"x_user_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("token response %s %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || r.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed: %s %s", resp.Status, r.Error)
+	}
+
+	RegisterSecret(r.AccessToken)
+	RegisterSecret(r.RefreshToken)
+	tok := &OAuthToken{AccessToken: r.AccessToken, RefreshToken: r.RefreshToken, UserID: r.UserID.String()}
+	switch {
+	case r.ExpiresAt > 0:
+		tok.Expiry = time.Unix(r.ExpiresAt, 0)
+	case r.ExpiresIn > 0:
+		tok.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// Returns a valid access token for a provider, refreshing and storing it
+// when it is about to expire.
+func OAuthAccessToken(name string) (string, error) {
+	p, err := lookupOAuthProvider(name)
+	if err != nil {
+		return "", err
+	}
+
+	oauthTokensMu.Lock()
+	defer oauthTokensMu.Unlock()
+	tokens, err := loadOAuthTokens()
+	if err != nil {
+		return "", err
+	}
+	tok, ok := tokens[name]
+	if !ok {
+		return "", fmt.Errorf("not authorized for %s, run the auth %s command first", name, name)
+	}
+	if tok.Expiry.IsZero() || time.Until(tok.Expiry) > time.Minute {
+		return tok.AccessToken, nil
+	}
+	if tok.RefreshToken == "" {
+		return "", fmt.Errorf("%s token expired, run the auth %s command again", name, name)
+	}
+
+	log.Infof("Refreshing %s token", name)
+	fresh, err := p.exchange(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tok.RefreshToken},
+	})
+	if err != nil {
+		return "", fmt.Errorf("refresh %s token %w", name, err)
+	}
+	// Some providers only issue the refresh token once.
+	if fresh.RefreshToken == "" {
+		fresh.RefreshToken = tok.RefreshToken
+	}
+	if fresh.UserID == "" {
+		fresh.UserID = tok.UserID
+	}
+	tokens[name] = fresh
+	if err := saveOAuthTokens(tokens); err != nil {
+		return "", err
+	}
+	return fresh.AccessToken, nil
+}
+
+// Returns the ID of the user authorized for a provider that identifies
+// them with the token.
+func OAuthUserID(name string) (string, error) {
+	oauthTokensMu.Lock()
+	defer oauthTokensMu.Unlock()
+	tokens, err := loadOAuthTokens()
+	if err != nil {
+		return "", err
+	}
+	tok, ok := tokens[name]
+	if !ok {
+		return "", fmt.Errorf("not authorized for %s, run the auth %s command first", name, name)
+	}
+	if tok.UserID == "" {
+		return "", fmt.Errorf("no %s user ID stored, run the auth %s command again", name, name)
+	}
+	return tok.UserID, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	inputOrder   = flag.String("order", "name", "Order to process files in -directory: name, newest (track first, by the archive index or file time) or smallest")
+	priorityFile = flag.String("priority_file", "", "File listing file names or glob patterns, one per line, to process first in the listed order, before -order applies to the rest")
+)
+
+// Sorts input files by -order, then moves files matching -priority_file to
+// the front.
+func OrderInputFiles(dir string, files []os.FileInfo) ([]os.FileInfo, error) {
+	files = append([]os.FileInfo(nil), files...)
+	switch *inputOrder {
+	case "name":
+		// Already sorted by ListInputFiles.
+	case "newest":
+		idx, err := LoadIndex(dir)
+		if err != nil {
+			log.Warnf("Failed to load index, ordering by file time: %v", err)
+			idx = &Index{Files: make(map[string]*IndexFile), dir: dir}
+		}
+		start := make(map[string]time.Time)
+		for _, fi := range files {
+			start[fi.Name()] = trackStartTime(idx, fi)
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			return start[files[i].Name()].After(start[files[j].Name()])
+		})
+	case "smallest":
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Size() < files[j].Size()
+		})
+	default:
+		return nil, fmt.Errorf("unknown -order %q", *inputOrder)
+	}
+
+	if *priorityFile == "" {
+		return files, nil
+	}
+	patterns, err := loadPriorities(*priorityFile)
+	if err != nil {
+		return nil, err
+	}
+	var ordered []os.FileInfo
+	taken := make(map[string]bool)
+	for _, pattern := range patterns {
+		for _, fi := range files {
+			if taken[fi.Name()] {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern, fi.Name()); ok {
+				ordered = append(ordered, fi)
+				taken[fi.Name()] = true
+			}
+		}
+	}
+	log.Infof("Processing %d files from %s first", len(ordered), *priorityFile)
+	for _, fi := range files {
+		if !taken[fi.Name()] {
+			ordered = append(ordered, fi)
+		}
+	}
+	return ordered, nil
+}
+
+// When the newest track in a file starts, from the archive index if it has
+// the file, otherwise the file's modification time.
+func trackStartTime(idx *Index, fi os.FileInfo) time.Time {
+	var start time.Time
+	if f := idx.Lookup(fi); f != nil {
+		for _, t := range f.Tracks {
+			if t.Start.After(start) {
+				start = t.Start
+			}
+		}
+	}
+	if start.IsZero() {
+		start = fi.ModTime()
+	}
+	return start
+}
+
+// Reads a priority list, skipping blank lines and # comments.
+func loadPriorities(filename string) ([]string, error) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return nil, fmt.Errorf("priority file %w", err)
+	}
+	defer f.Close()
+	var patterns []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("priority file pattern %q %w", line, err)
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, s.Err()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	fetchPeakInfo = flag.Bool("fetch_peak_info", false, "Fetch each matched peak's page for sanity checks and trip report context")
+
+	// Matched summit elevations further than this from the listed peak
+	// elevation suggest a bad match or bad elevation data.
+	peakElevationTolerance = flag.Float64("peak_elevation_tolerance", 100, "Warn when the track's high point differs from the peak's listed elevation by more than this many meters")
+)
+
+const peakbaggerURL = "https://www.peakbagger.com"
+
+// Details scraped from a peak's page. Fields the page doesn't list are zero.
+type PeakInfo struct {
+	PeakID    string
+	URL       string
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// Elevation and prominence in meters.
+	Elevation  float64
+	Prominence float64
+
+	// Free text route context, when the page lists it.
+	Route     string
+	Trailhead string
+	// Typical round trip gain in meters and duration, when the page lists it.
+	TypicalGain float64
+	TypicalTime string
+}
+
+var (
+	peakPageRowRe  = regexp.MustCompile(`(?is)<tr[^>]*>\s*<td[^>]*>(.*?)</td>\s*<td[^>]*>(.*?)</td>`)
+	peakPageTagRe  = regexp.MustCompile(`(?s)<[^>]*>`)
+	peakPageNameRe = regexp.MustCompile(`(?is)<h1[^>]*>(.*?)</h1>`)
+	feetRe         = regexp.MustCompile(`([\d,]+)\s*(?:ft|feet)`)
+	decDegRe       = regexp.MustCompile(`(-?\d+\.\d+),\s*(-?\d+\.\d+)`)
+)
+
+func stripTags(s string) string {
+	return strings.TrimSpace(html.UnescapeString(peakPageTagRe.ReplaceAllString(s, " ")))
+}
+
+func parseFeet(s string) float64 {
+	m := feetRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+	return v * metersPerFoot
+}
+
+// Parses the label/value table of a peak page.
+func parsePeakPage(peakID, page string) *PeakInfo {
+	info := &PeakInfo{
+		PeakID: peakID,
+		URL:    fmt.Sprintf("%s/peak.aspx?pid=%s", peakbaggerURL, peakID),
+	}
+	if m := peakPageNameRe.FindStringSubmatch(page); m != nil {
+		info.Name = CleanName(stripTags(m[1]))
+	}
+
+	for _, m := range peakPageRowRe.FindAllStringSubmatch(page, -1) {
+		label := strings.ToLower(strings.TrimSuffix(stripTags(m[1]), ":"))
+		value := stripTags(m[2])
+		switch {
+		case label == "elevation":
+			info.Elevation = parseFeet(value)
+		case strings.Contains(label, "prominence") && info.Prominence == 0:
+			info.Prominence = parseFeet(value)
+		case strings.HasPrefix(label, "latitude/longitude"):
+			if dm := decDegRe.FindStringSubmatch(value); dm != nil {
+				info.Latitude, _ = strconv.ParseFloat(dm[1], 64)
+				info.Longitude, _ = strconv.ParseFloat(dm[2], 64)
+			}
+		case strings.Contains(label, "route"):
+			info.Route = value
+		case strings.Contains(label, "trailhead"):
+			info.Trailhead = value
+		case strings.Contains(label, "gain"):
+			info.TypicalGain = parseFeet(value)
+		case strings.Contains(label, "time"):
+			info.TypicalTime = value
+		}
+	}
+	return info
+}
+
+// Fetches and parses a peak's page.
+func FetchPeakInfo(peakID string) (*PeakInfo, error) {
+	url := fmt.Sprintf("%s/peak.aspx?pid=%s", peakbaggerURL, peakID)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", url, resp.Status)
+	}
+	// Pages aren't always UTF-8, decode using the declared charset.
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parsePeakPage(peakID, string(b)), nil
+}
+
+// Returns the peak page info for a peak, cached for the run.
+func (u *Uploader) PeakInfo(peakID string) (*PeakInfo, error) {
+	if info, ok := u.peakInfo[peakID]; ok {
+		return info, nil
+	}
+	info, err := FetchPeakInfo(peakID)
+	if err != nil {
+		return nil, err
+	}
+	u.peakInfo[peakID] = info
+	return info, nil
+}
+
+// Logs warnings when a track looks inconsistent with the matched peak.
+func CheckPeakInfo(info *PeakInfo, summitElevation, gain float64) {
+	if info.Elevation > 0 {
+		if d := summitElevation - info.Elevation; d > *peakElevationTolerance || -d > *peakElevationTolerance {
+			log.Warnf("Track high point %.0fm differs from %q elevation %.0fm by %.0fm, check the match",
+				summitElevation, info.Name, info.Elevation, d)
+		}
+	}
+	if info.TypicalGain > 0 && gain > 3*info.TypicalGain {
+		log.Warnf("Gain %.0fm is %.1fx the typical %.0fm for %q, check the match",
+			gain, gain/info.TypicalGain, info.TypicalGain, info.Name)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tkrajina/gpxgo/gpx"
+	"golang.org/x/net/html/charset"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+// Implemented by drivers that can search peaks by name. Other drivers fall
+// back to Peakbagger's public search page.
+type PeakSearcher interface {
+	// Returns peaks whose name contains name, optionally limited to a state
+	// or region. Locations may be zero if the driver doesn't have them.
+	SearchPeaks(name, state string) (peakbagger.PeakList, error)
+}
+
+// A result found by name search, with the state or region it is listed in.
+type PeakSearchResult struct {
+	*peakbagger.Peak
+	Location string
+}
+
+var (
+	searchRowRe  = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	searchCellRe = regexp.MustCompile(`(?is)<td[^>]*>(.*?)</td>`)
+	searchPeakRe = regexp.MustCompile(`(?is)<a[^>]*href="[^"]*peak\.aspx\?pid=(-?\d+)"[^>]*>(.*?)</a>`)
+)
+
+// Searches peaks by name, and by state if set, e.g. "Rainier" and "WA".
+func (u *Uploader) SearchPeaks(name, state string) ([]PeakSearchResult, error) {
+	if s, ok := u.client.(PeakSearcher); ok {
+		peaks, err := s.SearchPeaks(name, state)
+		if err != nil {
+			return nil, err
+		}
+		var results []PeakSearchResult
+		for _, p := range peaks {
+			results = append(results, PeakSearchResult{Peak: p})
+		}
+		return results, nil
+	}
+	return searchPeakbagger(name, state)
+}
+
+// Searches Peakbagger's public peak search page, which lists matching peaks
+// with their location but not their coordinates.
+func searchPeakbagger(name, state string) ([]PeakSearchResult, error) {
+	u := fmt.Sprintf("%s/search.aspx?tid=S&ss=%s", peakbaggerURL, url.QueryEscape(name))
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search peaks: %s", resp.Status)
+	}
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parsePeakSearch(string(b), state), nil
+}
+
+// Parses search result rows: a peak link followed by location columns. Rows
+// without the state among their regions are dropped.
+func parsePeakSearch(page, state string) []PeakSearchResult {
+	var results []PeakSearchResult
+	seen := make(map[peakbagger.PeakID]bool)
+	for _, row := range searchRowRe.FindAllStringSubmatch(page, -1) {
+		m := searchPeakRe.FindStringSubmatch(row[1])
+		if m == nil {
+			continue
+		}
+		id, err := strconv.Atoi(m[1])
+		if err != nil || seen[peakbagger.PeakID(id)] {
+			continue
+		}
+		var location []string
+		for _, cell := range searchCellRe.FindAllStringSubmatch(row[1], -1) {
+			if text := stripTags(cell[1]); text != "" && !searchPeakRe.MatchString(cell[1]) {
+				location = append(location, text)
+			}
+		}
+		loc := strings.Join(location, ", ")
+		if state != "" && !hasRegion(location, state) {
+			continue
+		}
+		seen[peakbagger.PeakID(id)] = true
+		results = append(results, PeakSearchResult{
+			Peak:     &peakbagger.Peak{PeakID: peakbagger.PeakID(id), Name: CleanName(stripTags(m[2]))},
+			Location: loc,
+		})
+	}
+	return results
+}
+
+// Whether location cells name a region, as a whole cell or one of its
+// parts, e.g. WA in "USA-WA". Parts are compared whole, so WA doesn't match
+// Hawaii.
+func hasRegion(location []string, region string) bool {
+	region = strings.TrimSpace(region)
+	for _, cell := range location {
+		parts := strings.FieldsFunc(cell, func(r rune) bool {
+			return r == ',' || r == '-' || r == '/' || r == ';'
+		})
+		for _, part := range append(parts, cell) {
+			if strings.EqualFold(strings.TrimSpace(part), region) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *PeakSearchResult) String() string {
+	s := fmt.Sprintf("%s (%d)", r.Name, r.PeakID)
+	if r.Location != "" {
+		s += ", " + r.Location
+	}
+	return s
+}
+
+// Asks which peak was climbed when the track doesn't match exactly one, with
+// a fact sheet per candidate and the option to search by name instead.
+func (u *Uploader) choosePeak(src *Source, peaks peakbagger.PeakList, highest *gpx.GPXPoint) (*peakbagger.Peak, error) {
+	if len(peaks) > 0 {
+		var options []string
+		for _, p := range peaks {
+			options = append(options, u.peakFactSheet(p, highest.Latitude, highest.Longitude))
+		}
+		options = append(options, "Another peak, search by name")
+		i := choose(fmt.Sprintf("Candidate peaks in %s:", src.Filename), options)
+		if i < len(peaks) {
+			return peaks[i], nil
+		}
+	} else {
+		fmt.Printf("No peaks found near the high point of %s.\n", src.Filename)
+	}
+
+	name := ask("Peak name to search for, empty to give up", "")
+	if name == "" {
+		return nil, fmt.Errorf("no peaks found")
+	}
+	state := ask("State or region, optional", "")
+	results, err := u.SearchPeaks(name, state)
+	if err != nil {
+		return nil, fmt.Errorf("search peaks %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no peaks named %q found", name)
+	}
+	var options []string
+	for i := range results {
+		options = append(options, results[i].String())
+	}
+	r := results[choose("Matching peaks:", options)]
+	return u.locateResult(r)
+}
+
+// Fills in the location of a search result if the search didn't have it.
+func (u *Uploader) locateResult(r PeakSearchResult) (*peakbagger.Peak, error) {
+	if r.Latitude != 0 || r.Longitude != 0 {
+		return r.Peak, nil
+	}
+	_, lat, lng, err := u.locatePeak(fmt.Sprint(r.PeakID))
+	if err != nil {
+		return nil, fmt.Errorf("locate peak %d %w", r.PeakID, err)
+	}
+	p := *r.Peak
+	p.Latitude, p.Longitude = lat, lng
+	return &p, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	enrichmentsFlag = flag.String("enrichments", "", "Comma separated enrichment steps to run on each ascent (default: "+strings.Join(defaultEnrichments, ",")+"). Prefix a step with + or - to add it to or remove it from the defaults")
+)
+
+// Optional steps that add context to an ascent beyond the peak match and
+// stats Peakbagger requires, keyed by -enrichments name.
+var enrichmentSteps = map[string]string{
+	"conditions": "infer snow conditions from the season and elevations",
+	"daylight":   "compute daylight used and starts before sunrise or finishes after sunset",
+	"heart_rate": "summarize heart rate and effort",
+	"movement":   "compute distance, moving time and pace",
+	"peak_info":  "fetch the matched peak's page for sanity checks (network)",
+	"route":      "compare the track against standard routes in -route_library",
+	"ski":        "compute descent stats for ski tours",
+	"snotel":     "look up snow depth at the nearest SNOTEL station (network)",
+}
+
+// Steps that run without network access.
+var defaultEnrichments = []string{"conditions", "daylight", "heart_rate", "movement", "route", "ski"}
+
+var enrichments map[string]bool
+
+// Parses -enrichments, rejecting unknown step names. -fetch_peak_info and
+// -snotel still enable their steps.
+func LoadEnrichments() error {
+	enrichments = make(map[string]bool)
+	for _, s := range defaultEnrichments {
+		enrichments[s] = true
+	}
+
+	var unknown []string
+	replaced := false
+	for _, s := range strings.Split(*enrichmentsFlag, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		op := s[0]
+		name := strings.TrimLeft(s, "+-")
+		if _, ok := enrichmentSteps[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		switch op {
+		case '+':
+			enrichments[name] = true
+		case '-':
+			delete(enrichments, name)
+		default:
+			if !replaced {
+				enrichments = make(map[string]bool)
+				replaced = true
+			}
+			enrichments[name] = true
+		}
+	}
+	if len(unknown) > 0 {
+		var known []string
+		for name := range enrichmentSteps {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown enrichment steps %s, known steps are %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+	}
+
+	if *fetchPeakInfo {
+		enrichments["peak_info"] = true
+	}
+	if *snotel {
+		enrichments["snotel"] = true
+	}
+
+	var enabled []string
+	for name := range enrichments {
+		enabled = append(enabled, name)
+	}
+	sort.Strings(enabled)
+	log.Debugf("Enrichment steps: %s", strings.Join(enabled, ", "))
+	return nil
+}
+
+// Whether an enrichment step is enabled.
+func Enriching(step string) bool {
+	return enrichments[step]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	skipPlausibility = flag.Bool("skip_plausibility_check", false, "Upload tracks even if their stats look physically impossible")
+	maxClimbRate     = flag.Float64("max_climb_rate", 3000, "Maximum plausible sustained climb rate in meters per hour")
+	maxSpeed         = flag.Float64("max_speed", 250, "Maximum plausible sustained horizontal speed in km/h")
+	maxGainRatio     = flag.Float64("max_gain_ratio", 0.5, "Maximum plausible ratio of total gain to horizontal distance")
+	maxOutingTime    = flag.Duration("max_outing_duration", 30*24*time.Hour, "Reject ascents whose time up or down is longer than this")
+)
+
+// Window over which speeds are averaged, so a single noisy point doesn't
+// trip the check.
+const plausibilityWindow = 5 * time.Minute
+
+var (
+	ErrImplausible = errors.New("implausible track")
+	ErrBadDuration = errors.New("bad ascent duration")
+)
+
+// Checks that a track's stats are physically possible. Failures usually mean
+// unit confusion (feet parsed as meters) or corrupt timestamps in conversion.
+// Motorized stretches found by ComputeAssisted, if any, are left out of the
+// climb rate and gain checks, since lifts and trams climb faster than feet.
+func CheckPlausibility(points []gpx.GPXPoint, gain float64, assisted *AssistedStats) error {
+	if *skipPlausibility {
+		return nil
+	}
+
+	distance := 0.0
+	j := 0
+	for i := 1; i < len(points); i++ {
+		distance += pointDistance(&points[i-1], &points[i])
+
+		// Advance the window start until it spans at most plausibilityWindow.
+		for j < i && points[i].Timestamp.Sub(points[j+1].Timestamp) >= plausibilityWindow {
+			j++
+		}
+		dt := points[i].Timestamp.Sub(points[j].Timestamp)
+		if dt < plausibilityWindow {
+			continue
+		}
+		hours := dt.Hours()
+
+		if points[i].Elevation.NotNull() && points[j].Elevation.NotNull() && !assisted.covers(j, i) {
+			if rate := (points[i].Elevation.Value() - points[j].Elevation.Value()) / hours; rate > *maxClimbRate {
+				return fmt.Errorf("%w: climbing %.0fm/h at %v", ErrImplausible, rate, points[i].Timestamp)
+			}
+		}
+		d := pointDistance(&points[j], &points[i])
+		if speed := d / 1000 / hours; speed > *maxSpeed {
+			return fmt.Errorf("%w: moving %.0fkm/h at %v", ErrImplausible, speed, points[i].Timestamp)
+		}
+	}
+
+	if assisted != nil {
+		gain = assisted.OwnGain
+	}
+	if distance > 0 && gain/distance > *maxGainRatio {
+		return fmt.Errorf("%w: %.0fm gain over %.0fm distance", ErrImplausible, gain, distance)
+	}
+	return nil
+}
+
+// Checks the time up and down computed from the track's timestamps. Negative
+// or absurdly long durations come from corrupt timestamps or files joining
+// unrelated recordings, and would be logged as garbage on Peakbagger.
+func CheckDurations(start, summit, end time.Time) error {
+	switch up, down := summit.Sub(start), end.Sub(summit); {
+	case up < 0:
+		return fmt.Errorf("%w: summit at %v is before the track start at %v, check the device clock or timestamps in the file", ErrBadDuration, summit, start)
+	case down < 0:
+		return fmt.Errorf("%w: summit at %v is after the track end at %v, check the device clock or timestamps in the file", ErrBadDuration, summit, end)
+	case up > *maxOutingTime || down > *maxOutingTime:
+		return fmt.Errorf("%w: %v up and %v down exceeds -max_outing_duration, the file may join separate outings, try -split_gap", ErrBadDuration, up, down)
+	}
+	return nil
+}
+
+// Categorizes a processing error for history and reports, so data problems
+// can be told apart from transient failures.
+func ErrorCategory(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrImplausible):
+		return "implausible"
+	case errors.Is(err, ErrBadDuration):
+		return "bad_duration"
+	case errors.Is(err, ErrAttempt):
+		return "attempt"
+	case errors.Is(err, ErrDriveUp):
+		return "drive_up"
+	case IsUnavailable(err):
+		return "unavailable"
+	}
+	return "error"
+}
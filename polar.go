@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	polarSports = flag.String("polar_sports", "HIKING,RUNNING,TRAIL_RUNNING,WALKING,MOUNTAINEERING,BACKCOUNTRY_SKIING,SNOWSHOE_TREKKING", "Comma separated Polar sports to upload with -source=polar, matching the detailed or general sport of an exercise")
+)
+
+var polarAPIURL = "https://www.polaraccesslink.com"
+
+// Syncs exercises from Polar Flow with the AccessLink API, see -source. The
+// API only lists the last 30 days of exercises.
+type polarSource struct {
+	token string
+	// Polar user ID the token was issued for.
+	userID string
+}
+
+func newPolarSource() (ActivitySource, error) {
+	token, err := OAuthAccessToken("polar")
+	if err != nil {
+		return nil, err
+	}
+	userID, err := OAuthUserID("polar")
+	if err != nil {
+		return nil, err
+	}
+	p := &polarSource{token: token, userID: userID}
+	if err := p.register(); err != nil {
+		return nil, fmt.Errorf("register with polar accesslink %w", err)
+	}
+	return p, nil
+}
+
+// AccessLink only serves data of users registered with the client, under a
+// member ID unique to each user. Already registered users get a conflict,
+// which is only taken as registered when the user's details can be read.
+func (p *polarSource) register() error {
+	body, err := json.Marshal(map[string]string{"member-id": programName + "-" + p.userID})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", polarAPIURL+"/v3/users", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := apiClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		user, err := bearerGet(fmt.Sprintf("%s/v3/users/%s", polarAPIURL, url.PathEscape(p.userID)), p.token)
+		if err != nil {
+			return fmt.Errorf("%s, but user %s is not registered %w", resp.Status, p.userID, err)
+		}
+		user.Body.Close()
+		return nil
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+// Start times are local with a separate offset from UTC in minutes.
+const polarTimeLayout = "2006-01-02T15:04:05"
+
+func (p *polarSource) Activities(limit int) ([]*RemoteActivity, error) {
+	resp, err := bearerGet(polarAPIURL+"/v3/exercises", p.token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list []struct {
+		ID                string `json:"id"`
+		StartTime         string `json:"start_time"`
+		StartUTCOffset    int    `json:"start_time_utc_offset"`
+		Sport             string `json:"sport"`
+		DetailedSportInfo string `json:"detailed_sport_info"`
+		HasRoute          bool   `json:"has_route"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("parse exercise list %w", err)
+	}
+	var activities []*RemoteActivity
+	for _, l := range list {
+		if !l.HasRoute {
+			continue
+		}
+		a := &RemoteActivity{
+			Source: "polar",
+			ID:     l.ID,
+			// Both sports, matched by Selected.
+			Type: l.DetailedSportInfo + "," + l.Sport,
+		}
+		if t, err := time.Parse(polarTimeLayout, strings.SplitN(l.StartTime, ".", 2)[0]); err == nil {
+			a.Start = t.Add(-time.Duration(l.StartUTCOffset) * time.Minute)
+		}
+		activities = append(activities, a)
+	}
+	// The list isn't paged, keep the newest.
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].Start.After(activities[j].Start)
+	})
+	if len(activities) > limit {
+		activities = activities[:limit]
+	}
+	return activities, nil
+}
+
+func (p *polarSource) Selected(a *RemoteActivity) bool {
+	for _, s := range strings.Split(*polarSports, ",") {
+		s = strings.TrimSpace(s)
+		for _, t := range strings.Split(a.Type, ",") {
+			if s != "" && strings.EqualFold(s, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Downloads the exercise as a FIT file.
+func (p *polarSource) Download(a *RemoteActivity, dir string) (string, error) {
+	resp, err := bearerGet(fmt.Sprintf("%s/v3/exercises/%s/fit", polarAPIURL, a.ID), p.token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	filename := filepath.Join(dir, a.ID+".fit")
+	if err := saveDownload(resp.Body, filename); err != nil {
+		return "", fmt.Errorf("download exercise %s %w", a.ID, err)
+	}
+	return filename, nil
+}
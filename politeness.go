@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+var (
+	userAgent    = flag.String("user_agent", "", "User-Agent for Peakbagger requests (default identifies this tool, its version and project URL)")
+	requestDelay = flag.Duration("request_delay", time.Second, "Minimum time between Peakbagger requests")
+	maxPerMinute = flag.Int("max_requests_per_minute", 0, "Cap on Peakbagger requests per minute from all features combined (0 for only -request_delay); serve splits it between concurrent scans")
+)
+
+func defaultUserAgent() string {
+	return fmt.Sprintf("peakbagger-bulk-uploader/%s (+https://github.com/jheidel/peakbagger-bulk-uploader)", version)
+}
+
+// The shared budget for all Peakbagger requests, whichever feature makes them.
+// Holding it serializes requests so at most one is in flight at a time.
+type requestBudget struct {
+	mu   sync.Mutex
+	last time.Time
+	// Start times of requests in the last minute, for -max_requests_per_minute.
+	recent []time.Time
+	// Set from Retry-After when Peakbagger asks us to back off.
+	pausedUntil time.Time
+}
+
+// Waits until a request fits within -request_delay, -max_requests_per_minute
+// and any Retry-After pause, then holds the budget until release.
+func (b *requestBudget) acquire() {
+	b.mu.Lock()
+	if wait := time.Until(b.pausedUntil); wait > 0 {
+		time.Sleep(wait)
+	}
+	if wait := *requestDelay - time.Since(b.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	if *maxPerMinute > 0 {
+		for len(b.recent) > 0 && time.Since(b.recent[0]) >= time.Minute {
+			b.recent = b.recent[1:]
+		}
+		if len(b.recent) >= *maxPerMinute {
+			time.Sleep(time.Minute - time.Since(b.recent[0]))
+			b.recent = b.recent[1:]
+		}
+		b.recent = append(b.recent, time.Now())
+	}
+}
+
+// Releases the budget after a request, pausing further requests if it was
+// answered with Retry-After.
+func (b *requestBudget) release(err error) {
+	b.last = time.Now()
+	var ue *UnavailableError
+	if errors.As(err, &ue) && ue.RetryAfter > 0 {
+		b.pausedUntil = b.last.Add(ue.RetryAfter)
+	}
+	b.mu.Unlock()
+}
+
+var peakbaggerBudget requestBudget
+
+// Identifies the tool on Peakbagger requests and spaces them out through
+// peakbaggerBudget so bulk runs behave like a polite client.
+func InstallPoliteness() {
+	ua := *userAgent
+	if ua == "" {
+		ua = defaultUserAgent()
+	}
+
+	wrapDefaultTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !isPeakbagger(req) {
+				return next.RoundTrip(req)
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set("User-Agent", ua)
+
+			peakbaggerBudget.acquire()
+			resp, err := next.RoundTrip(req)
+			peakbaggerBudget.release(err)
+			return resp, err
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	hideGPX        = flag.Bool("hide_gpx", false, "Log ascents with their computed stats but without uploading the GPS track")
+	hideGPXRegion  = flag.String("hide_gpx_region", "", "Only upload tracks without their GPS track when they enter the polygons of this GeoJSON file, for sensitive locations")
+	privateAscents = flag.Bool("private_ascents", false, "Mark added ascents private. Ascents aren't added on drivers that can't make them private")
+)
+
+// Implemented by drivers that can change an ascent's visibility.
+type AscentVisibility interface {
+	SetAscentPrivate(id peakbagger.AscentID, private bool) error
+}
+
+var hiddenRegion *Region
+
+// Loads -hide_gpx_region, if set.
+func LoadHiddenRegion() error {
+	if *hideGPXRegion == "" {
+		return nil
+	}
+	r, err := LoadRegion(*hideGPXRegion)
+	if err != nil {
+		return fmt.Errorf("load hidden region %w", err)
+	}
+	log.Infof("Hiding tracks entering %d polygons from %s", len(r.polygons), *hideGPXRegion)
+	hiddenRegion = r
+	return nil
+}
+
+// Whether a track should be logged without its GPX.
+func HideGPX(t *gpx.GPXTrack) bool {
+	return *hideGPX || InHiddenRegion(t)
+}
+
+// Whether a track enters -hide_gpx_region.
+func InHiddenRegion(t *gpx.GPXTrack) bool {
+	return hiddenRegion != nil && hiddenRegion.Enters(t)
+}
+
+// Marks an added ascent private when -private_ascents is set. The driver's
+// support is checked by checkAscentFlags before the ascent is added.
+func (u *Uploader) applyVisibility(id peakbagger.AscentID) error {
+	if !*privateAscents {
+		return nil
+	}
+	return u.client.(AscentVisibility).SetAscentPrivate(id, true)
+}
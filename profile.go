@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfile = flag.String("memprofile", "", "Write a heap profile to this file on exit")
+)
+
+// Starts any requested profiling. The returned function stops profiling and
+// writes the heap profile, and must be called before exit.
+func StartProfiling() (func(), error) {
+	var cpu *os.File
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("create cpu profile %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("start cpu profile %w", err)
+		}
+		cpu = f
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if *memProfile != "" {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				log.Errorf("Failed to create heap profile: %v", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Errorf("Failed to write heap profile: %v", err)
+			}
+		}
+	}, nil
+}
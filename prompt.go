@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Asks a yes/no question on the terminal, defaulting to no. Returns false when
+// stdin is closed so unattended runs never make unconfirmed changes.
+func confirm(format string, args ...interface{}) bool {
+	fmt.Printf(format+" [y/N] ", args...)
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// Asks for a line of text on the terminal, returning def on empty input or
+// when stdin is closed.
+func ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return def
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// Asks to pick one of several options on the terminal, returning its index.
+// Defaults to the first option on empty input or when stdin is closed.
+func choose(question string, options []string) int {
+	fmt.Println(question)
+	for i, o := range options {
+		fmt.Printf("  %d) %s\n", i+1, o)
+	}
+	for {
+		fmt.Printf("Choice [1]: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return 0
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return 0
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+	}
+}
+
+// Asks for a password on the terminal without echoing it. Input that isn't
+// a terminal is read as is.
+func askPassword(question string) (string, error) {
+	fmt.Printf("%s: ", question)
+	if err := setEcho(false); err == nil {
+		defer fmt.Println()
+		defer setEcho(true)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	offlineQueue = flag.Bool("offline", false, "Analyze without network access, matching peaks against -peak_db, and queue finished ascents in -directory for the sync command to upload once back online")
+)
+
+const QueueFilename = "queue.json"
+
+// Folder in the input directory holding the tracks of queued ascents, which
+// are kept out of the queue file to keep it small.
+const QueuedGPXDir = "queued_gpx"
+
+// An ascent prepared by -offline, waiting for sync.
+type QueuedAscent struct {
+	Prep  *PreparedAscent
+	Entry *ReportEntry
+	// Track file under QueuedGPXDir, empty for ascents without a track.
+	GPX    string `json:",omitempty"`
+	Queued time.Time
+}
+
+// Ascents queued in the input directory.
+type AscentQueue struct {
+	Ascents []*QueuedAscent
+
+	dir string
+}
+
+func LoadQueue(dir string) (*AscentQueue, error) {
+	q := &AscentQueue{dir: dir}
+	b, err := os.ReadFile(longPath(filepath.Join(dir, QueueFilename)))
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, q); err != nil {
+		return nil, fmt.Errorf("parse %s %w", QueueFilename, err)
+	}
+	return q, nil
+}
+
+func (q *AscentQueue) Save() error {
+	if *offlineSimulate {
+		return nil
+	}
+	b, err := json.MarshalIndent(q, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(longPath(filepath.Join(q.dir, QueueFilename)), b, 0644)
+}
+
+// Finds a queued ascent of a peak on the same local date.
+func (q *AscentQueue) Find(prep *PreparedAscent) *QueuedAscent {
+	day := ascentDay(prep.Ascent.Date.Local())
+	for _, qa := range q.Ascents {
+		if qa.Prep.Ascent.PeakID == prep.Ascent.PeakID && ascentDay(qa.Prep.Ascent.Date.Local()) == day {
+			return qa
+		}
+	}
+	return nil
+}
+
+// Queues an ascent instead of adding it, keeping its track alongside.
+func (u *Uploader) queueAscent(prep *PreparedAscent, entry *ReportEntry) error {
+	q, err := LoadQueue(u.dir)
+	if err != nil {
+		return err
+	}
+	if q.Find(prep) != nil {
+		log.Infof("Ascent of %q on %v is already queued", prep.PeakName, prep.Ascent.Date.Local().Format("2006-01-02"))
+		return nil
+	}
+
+	queued := *prep
+	qa := &QueuedAscent{Prep: &queued, Entry: entry, Queued: outputNow()}
+	if g := prep.Ascent.Gpx; g != nil && !*offlineSimulate {
+		dir := filepath.Join(q.dir, QueuedGPXDir)
+		if err := os.MkdirAll(longPath(dir), 0755); err != nil {
+			return err
+		}
+		b, err := g.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+		if err != nil {
+			return err
+		}
+		qa.GPX = fmt.Sprintf("%v-%s.gpx", prep.Ascent.PeakID, prep.Ascent.Date.UTC().Format("20060102T150405Z"))
+		if err := os.WriteFile(longPath(filepath.Join(dir, qa.GPX)), b, 0644); err != nil {
+			return err
+		}
+	}
+	queued.Ascent.Gpx = nil
+
+	q.Ascents = append(q.Ascents, qa)
+	if err := q.Save(); err != nil {
+		return err
+	}
+	entry.Queued = true
+	log.Infof("Queued ascent of %q, run sync to upload it", prep.PeakName)
+	return nil
+}
+
+// Uploads the ascents queued by -offline, dropping any logged in the
+// meantime. The queue is saved after each ascent so an interrupted sync
+// resumes where it stopped.
+func (u *Uploader) Sync() error {
+	if *offlineQueue {
+		return fmt.Errorf("sync uploads the -offline queue, run it without -offline")
+	}
+	q, err := LoadQueue(u.dir)
+	if err != nil {
+		return err
+	}
+	if len(q.Ascents) == 0 {
+		log.Infof("No queued ascents")
+		return nil
+	}
+	ascents, err := u.Ascents()
+	if err != nil {
+		return err
+	}
+
+	pending := q.Ascents
+	var remaining []*QueuedAscent
+	synced := 0
+	for i, qa := range pending {
+		prep := qa.Prep
+		gpxFile := ""
+		if qa.GPX != "" {
+			gpxFile = filepath.Join(q.dir, QueuedGPXDir, qa.GPX)
+		}
+		if ascents.Has(prep.Ascent.PeakID, prep.Ascent.Date) {
+			log.Infof("Ascent of %q on %v is already logged, dropping it from the queue", prep.PeakName, prep.Ascent.Date.Local().Format("2006-01-02"))
+		} else {
+			if gpxFile != "" {
+				g, err := ReadGPX(gpxFile)
+				if err != nil {
+					return fmt.Errorf("queued track of %q %w", prep.PeakName, err)
+				}
+				prep.Ascent.Gpx = g
+			}
+			if err := u.addAscent(prep, qa.Entry); err != nil {
+				if IsUnavailable(err) {
+					return err
+				}
+				log.Warnf("Failed to sync ascent of %q, keeping it queued: %v", prep.PeakName, err)
+				remaining = append(remaining, qa)
+				continue
+			}
+			synced++
+		}
+		if *dryRun {
+			remaining = append(remaining, qa)
+			continue
+		}
+
+		q.Ascents = append(append([]*QueuedAscent(nil), remaining...), pending[i+1:]...)
+		if err := q.Save(); err != nil {
+			return err
+		}
+		if gpxFile != "" && !*offlineSimulate {
+			if err := os.Remove(longPath(gpxFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Warnf("Failed to remove queued track: %v", err)
+			}
+		}
+	}
+	log.Infof("Synced %d of %d queued ascents", synced, len(pending))
+	return nil
+}
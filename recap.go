@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A year of ascents from the manifest of -directory.
+type Recap struct {
+	Year    int
+	Ascents []*ManifestEntry
+
+	UniquePeaks int
+	// Peaks first climbed this year, in order.
+	NewPeaks []*ManifestEntry
+	// Days with at least one ascent.
+	Days int
+	// Total elevation gain in meters and total time up and down.
+	Gain float64
+	Time time.Duration
+	// Ascent with the most gain, nil if none recorded gain.
+	BiggestDay *ManifestEntry
+	// Ascents per month, January first.
+	Months [12]int
+
+	// SVG map of the year's tracks and summits.
+	Map template.HTML
+}
+
+// Pixel size of the recap map.
+const (
+	recapMapWidth  = 800
+	recapMapHeight = 500
+	// Points drawn per track, enough for the map's scale.
+	recapMapPoints = 300
+)
+
+// Writes a shareable HTML summary of a year: recap -year 2024 [-o file].
+func RunRecapCommand(args []string) error {
+	fs := flag.NewFlagSet("recap", flag.ContinueOnError)
+	year := fs.Int("year", time.Now().Year()-1, "Year to summarize")
+	out := fs.String("o", "", "Output HTML file (default recap-<year>.html)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("recap-%d.html", *year)
+	}
+
+	m, err := LoadManifest(*inputDirectory)
+	if err != nil {
+		return err
+	}
+	r := BuildRecap(m, *year)
+	if len(r.Ascents) == 0 {
+		return fmt.Errorf("no ascents in %d in the manifest of %q", *year, *inputDirectory)
+	}
+	r.Map = recapMap(r.Ascents)
+
+	f, err := os.Create(longPath(*out))
+	if err != nil {
+		return err
+	}
+	if err := recapPage.Execute(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Infof("Wrote %d recap with %d ascents to %s", *year, len(r.Ascents), *out)
+	return nil
+}
+
+// Computes the recap stats for a year. Peaks are new if no earlier ascent in
+// the manifest climbed them.
+func BuildRecap(m *Manifest, year int) *Recap {
+	ascents := append([]*ManifestEntry(nil), m.Ascents...)
+	sort.SliceStable(ascents, func(i, j int) bool {
+		return ascents[i].Date.Before(ascents[j].Date)
+	})
+
+	r := &Recap{Year: year}
+	climbed := make(map[string]bool)
+	peaks := make(map[string]bool)
+	days := make(map[string]bool)
+	for _, a := range ascents {
+		date := a.Date.Local()
+		if date.Year() != year {
+			climbed[a.PeakID] = true
+			continue
+		}
+		r.Ascents = append(r.Ascents, a)
+		if !climbed[a.PeakID] {
+			r.NewPeaks = append(r.NewPeaks, a)
+			climbed[a.PeakID] = true
+		}
+		peaks[a.PeakID] = true
+		days[ascentDay(date)] = true
+		r.Gain += a.Gain
+		r.Time += a.TimeUp + a.TimeDown
+		if a.Gain > 0 && (r.BiggestDay == nil || a.Gain > r.BiggestDay.Gain) {
+			r.BiggestDay = a
+		}
+		r.Months[date.Month()-1]++
+	}
+	r.UniquePeaks = len(peaks)
+	r.Days = len(days)
+	return r
+}
+
+// Draws the year's tracks and summits as an SVG. Tracks that would be
+// uploaded without their GPX, see -hide_gpx, are left out and only their
+// summit is marked.
+func recapMap(ascents []*ManifestEntry) template.HTML {
+	var lines [][]LatLng
+	var summits []LatLng
+	seen := make(map[string]bool)
+	for _, a := range ascents {
+		if a.Latitude != 0 || a.Longitude != 0 {
+			summits = append(summits, LatLng{a.Latitude, a.Longitude})
+		}
+		if seen[a.File] {
+			continue
+		}
+		seen[a.File] = true
+		g, err := LoadGPX(filepath.Join(*inputDirectory, a.File))
+		if err != nil {
+			log.Warnf("Leaving %q off the map: %v", a.File, err)
+			continue
+		}
+		for i := range g.Tracks {
+			t := &g.Tracks[i]
+			if HideGPX(t) {
+				continue
+			}
+			var line []LatLng
+			for _, p := range reducePoints(trackPoints(t), recapMapPoints) {
+				line = append(line, LatLng{p.Latitude, p.Longitude})
+			}
+			lines = append(lines, line)
+		}
+		releaseMemory()
+	}
+
+	all := append([]LatLng(nil), summits...)
+	for _, l := range lines {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return ""
+	}
+	minLat, maxLat, minLng, maxLng := all[0].Lat, all[0].Lat, all[0].Lng, all[0].Lng
+	for _, p := range all {
+		minLat, maxLat = math.Min(minLat, p.Lat), math.Max(maxLat, p.Lat)
+		minLng, maxLng = math.Min(minLng, p.Lng), math.Max(maxLng, p.Lng)
+	}
+
+	// Equirectangular, with longitude scaled at the middle latitude so shapes
+	// aren't stretched, fit into the map with a margin.
+	const margin = 20
+	kx := math.Cos((minLat + maxLat) / 2 * math.Pi / 180)
+	w, h := (maxLng-minLng)*kx, maxLat-minLat
+	scale := math.Min((recapMapWidth-2*margin)/math.Max(w, 1e-6), (recapMapHeight-2*margin)/math.Max(h, 1e-6))
+	xy := func(p LatLng) (float64, float64) {
+		return margin + (p.Lng-minLng)*kx*scale, margin + (maxLat-p.Lat)*scale
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d">`, recapMapWidth, recapMapHeight, recapMapWidth, recapMapHeight)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#f4f1ea"/>`)
+	for _, l := range lines {
+		b.WriteString(`<polyline fill="none" stroke="#c0392b" stroke-width="1.5" stroke-opacity="0.7" points="`)
+		for _, p := range l {
+			x, y := xy(p)
+			fmt.Fprintf(&b, "%.1f,%.1f ", x, y)
+		}
+		b.WriteString(`"/>`)
+	}
+	for _, p := range summits {
+		x, y := xy(p)
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="4" fill="#2c3e50"/>`, x, y)
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+var recapPage = template.Must(template.New("recap").Funcs(template.FuncMap{
+	"elev": formatElevation,
+	"hours": func(d time.Duration) string {
+		return fmt.Sprintf("%.0f", d.Hours())
+	},
+	"month": func(i int) string {
+		return time.Month(i + 1).String()[:3]
+	},
+	"date": func(t time.Time) string {
+		return t.Local().Format("Jan 2")
+	},
+	"url": ascentURL,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Year}} in the mountains</title>
+<style>
+body { font-family: sans-serif; max-width: 820px; margin: 2em auto; padding: 0 1em; color: #2c3e50; }
+.stats { display: flex; flex-wrap: wrap; gap: 1em; }
+.stat { flex: 1; min-width: 140px; background: #f4f1ea; padding: 1em; border-radius: 6px; }
+.stat b { display: block; font-size: 2em; }
+.months td { text-align: center; padding: 0 .4em; }
+svg { max-width: 100%; height: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Year}} in the mountains</h1>
+<div class="stats">
+<div class="stat"><b>{{len .Ascents}}</b>ascents</div>
+<div class="stat"><b>{{.UniquePeaks}}</b>peaks</div>
+<div class="stat"><b>{{len .NewPeaks}}</b>new peaks</div>
+<div class="stat"><b>{{elev .Gain}}</b>gained</div>
+<div class="stat"><b>{{.Days}}</b>days out</div>
+<div class="stat"><b>{{hours .Time}}</b>hours up and down</div>
+</div>
+{{with .BiggestDay}}<p>Biggest day: <a href="{{url .AscentID}}">{{.PeakName}}</a> on {{date .Date}}, {{elev .Gain}} of gain.</p>{{end}}
+{{.Map}}
+<table class="months"><tr>{{range $i, $n := .Months}}<td>{{month $i}}</td>{{end}}</tr><tr>{{range .Months}}<td>{{.}}</td>{{end}}</tr></table>
+{{if .NewPeaks}}<h2>New peaks</h2>
+<ul>
+{{range .NewPeaks}}<li><a href="{{url .AscentID}}">{{or .PeakName .PeakID}}</a>, {{date .Date}}</li>
+{{end}}</ul>{{end}}
+</body>
+</html>
+`))
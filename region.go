@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+)
+
+var (
+	regionFile = flag.String("region", "", "Only match peaks inside the polygons of this GeoJSON file, e.g. a state or country boundary. Takes a file path, not a state or country name")
+)
+
+// A linear ring of [longitude, latitude] positions, as in GeoJSON.
+type ring [][2]float64
+
+// A polygon with an outer ring and optional holes.
+type polygon []ring
+
+// An area made of polygons, with a bounding box for quick rejection.
+type Region struct {
+	polygons                       []polygon
+	minLat, maxLat, minLng, maxLng float64
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJSON struct {
+	Type        string           `json:"type"`
+	ID          string           `json:"id"`
+	Coordinates json.RawMessage  `json:"coordinates"`
+	Geometry    *geoJSONGeometry `json:"geometry"`
+	Features    []geoJSON        `json:"features"`
+	// Only read for track features, see ReadGeoJSON.
+	Properties geoJSONProps `json:"properties"`
+}
+
+// Loads the polygons of a GeoJSON file. Accepts a Polygon or MultiPolygon,
+// or a Feature or FeatureCollection of them.
+func LoadRegion(filename string) (*Region, error) {
+	b, err := os.ReadFile(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	var g geoJSON
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("parse geojson %w", err)
+	}
+	r := &Region{minLat: 90, maxLat: -90, minLng: 180, maxLng: -180}
+	if err := r.add(&g); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if len(r.polygons) == 0 {
+		return nil, fmt.Errorf("%s has no polygons", filename)
+	}
+	return r, nil
+}
+
+func (r *Region) add(g *geoJSON) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for i := range g.Features {
+			if err := r.add(&g.Features[i]); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return r.add(&geoJSON{Type: g.Geometry.Type, Coordinates: g.Geometry.Coordinates})
+		}
+	case "Polygon":
+		var p polygon
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return err
+		}
+		r.addPolygon(p)
+	case "MultiPolygon":
+		var mp []polygon
+		if err := json.Unmarshal(g.Coordinates, &mp); err != nil {
+			return err
+		}
+		for _, p := range mp {
+			r.addPolygon(p)
+		}
+	default:
+		log.Warnf("Ignoring %s in region", g.Type)
+	}
+	return nil
+}
+
+func (r *Region) addPolygon(p polygon) {
+	if len(p) == 0 {
+		return
+	}
+	for _, pos := range p[0] {
+		lng, lat := pos[0], pos[1]
+		if lat < r.minLat {
+			r.minLat = lat
+		}
+		if lat > r.maxLat {
+			r.maxLat = lat
+		}
+		if lng < r.minLng {
+			r.minLng = lng
+		}
+		if lng > r.maxLng {
+			r.maxLng = lng
+		}
+	}
+	r.polygons = append(r.polygons, p)
+}
+
+// Ray casting test of whether a point is inside a ring.
+func (rg ring) contains(lat, lng float64) bool {
+	in := false
+	for i, j := 0, len(rg)-1; i < len(rg); j, i = i, i+1 {
+		xi, yi := rg[i][0], rg[i][1]
+		xj, yj := rg[j][0], rg[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Whether the bounding box of the region overlaps the given box.
+func (r *Region) Overlaps(minLat, maxLat, minLng, maxLng float64) bool {
+	return minLat <= r.maxLat && maxLat >= r.minLat && minLng <= r.maxLng && maxLng >= r.minLng
+}
+
+// Whether a point is inside the region, outside any holes.
+func (r *Region) Contains(lat, lng float64) bool {
+	if !r.Overlaps(lat, lat, lng, lng) {
+		return false
+	}
+	for _, p := range r.polygons {
+		if !p[0].contains(lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range p[1:] {
+			if hole.contains(lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+var matchRegion *Region
+
+// Loads -region, if set.
+func LoadMatchRegion() error {
+	if *regionFile == "" {
+		return nil
+	}
+	// A bare name such as WA would otherwise fail as a missing file.
+	if _, err := os.Stat(longPath(*regionFile)); errors.Is(err, os.ErrNotExist) &&
+		filepath.Ext(*regionFile) == "" && !strings.ContainsAny(*regionFile, `/\`) {
+		return fmt.Errorf("-region %q is not a GeoJSON file; state and country names aren't built in, pass the path of a boundary file instead", *regionFile)
+	}
+	r, err := LoadRegion(*regionFile)
+	if err != nil {
+		return fmt.Errorf("load region %w", err)
+	}
+	log.Infof("Only matching peaks inside %d polygons from %s", len(r.polygons), *regionFile)
+	matchRegion = r
+	return nil
+}
+
+// Drops peaks outside -region.
+func FilterPeaksByRegion(peaks peakbagger.PeakList) peakbagger.PeakList {
+	if matchRegion == nil {
+		return peaks
+	}
+	var kept peakbagger.PeakList
+	for _, p := range peaks {
+		if matchRegion.Contains(p.Latitude, p.Longitude) {
+			kept = append(kept, p)
+		} else {
+			log.Debugf("Ignoring %q outside -region", p.Name)
+		}
+	}
+	return kept
+}
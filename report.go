@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	reportFile = flag.String("report", "", "Write a JSON report of every processed track to this file")
+)
+
+// Outcome of processing a single track.
+type ReportEntry struct {
+	File  string
+	Track string
+
+	PeakID   string     `json:",omitempty"`
+	PeakName string     `json:",omitempty"`
+	Date     *time.Time `json:",omitempty"`
+
+	TimeUp       time.Duration `json:",omitempty"`
+	TimeDown     time.Duration `json:",omitempty"`
+	TimeOnSummit time.Duration `json:",omitempty"`
+
+	Movement  *MovementStats  `json:",omitempty"`
+	HeartRate *HeartRateStats `json:",omitempty"`
+
+	// Estimated horizontal accuracy around the summit in meters.
+	SummitAccuracy float64 `json:",omitempty"`
+
+	Conditions string `json:",omitempty"`
+	// Name of the standard route the track followed, see -route_library.
+	Route    string    `json:",omitempty"`
+	Daylight *Daylight `json:",omitempty"`
+	// Elevation gain in meters.
+	Gain float64 `json:",omitempty"`
+	// Set when part of the gain was by vehicle or lift.
+	Assisted *AssistedStats `json:",omitempty"`
+
+	TripReport string `json:",omitempty"`
+
+	// Intervening drop and regain from the previous summit in meters, for
+	// legs of multi-peak tracks.
+	Drop   float64 `json:",omitempty"`
+	Regain float64 `json:",omitempty"`
+
+	// Set when the ascent was added, as opposed to a dry run or failure.
+	Uploaded bool
+	// Set when the ascent was queued by -offline for the sync command.
+	Queued bool   `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	// Optional enrichments that failed, the ascent was processed without them.
+	Warnings []string `json:",omitempty"`
+	// See ErrorCategory.
+	ErrorCategory string `json:",omitempty"`
+}
+
+// Report of all tracks processed in a run.
+type RunReport struct {
+	mu sync.Mutex
+
+	Started time.Time
+	Entries []*ReportEntry
+}
+
+func NewRunReport() *RunReport {
+	return &RunReport{Started: outputNow()}
+}
+
+// Starts a report entry for a track.
+func (r *RunReport) NewEntry(src *Source, t *gpx.GPXTrack) *ReportEntry {
+	e := &ReportEntry{File: src.Filename, Track: t.Name}
+	if src.Leg != nil {
+		e.Drop, e.Regain = src.Leg.Drop, src.Leg.Regain
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Entries = append(r.Entries, e)
+	return e
+}
+
+// Returns the entries started after the report had n entries.
+func (r *RunReport) entriesSince(n int) []*ReportEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*ReportEntry(nil), r.Entries[n:]...)
+}
+
+// Number of entries started so far.
+func (r *RunReport) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Entries)
+}
+
+// Records the outcome of processing.
+func (e *ReportEntry) Finish(err error) {
+	if err != nil {
+		e.Error = Redact(err.Error())
+		e.ErrorCategory = ErrorCategory(err)
+		Emit(e.event(EventError).withError(err))
+	}
+}
+
+// Runs an optional enrichment, such as a lookup in an external service.
+// Failures and panics are recorded as warnings instead of failing the track,
+// so an unavailable service only degrades the result.
+func (e *ReportEntry) Enrich(name string, f func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.degraded(name, fmt.Errorf("panic: %v", r))
+		}
+	}()
+	if err := f(); err != nil {
+		e.degraded(name, err)
+	}
+}
+
+func (e *ReportEntry) degraded(name string, err error) {
+	log.Warnf("%s failed, continuing without it: %v", name, err)
+	e.Warnings = append(e.Warnings, Redact(fmt.Sprintf("%s: %v", name, err)))
+}
+
+func (r *RunReport) Write(filename string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, b, 0644)
+}
+
+// Writes the run report if -report is set, and exports new entries to
+// -sheets_id. A failed export is logged, its rows are kept and retried by
+// the next export.
+func (u *Uploader) WriteReport() error {
+	if err := u.ExportSheet(); err != nil {
+		log.Errorf("Failed to export to Google Sheets: %v", err)
+	}
+	if *reportFile == "" {
+		return nil
+	}
+	return u.report.Write(*reportFile)
+}
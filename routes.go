@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	routeLibrary   = flag.String("route_library", "", "Directory of standard routes to compare tracks against, as <peak id>/<route name>.gpx, optionally grouped by area as <area>/<peak id>/<route name>.gpx")
+	routeCorridor  = flag.Float64("route_corridor", 100, "Distance in meters from a standard route within which a track counts as on it")
+	routeMatch     = flag.Float64("route_match", 0.8, "Fraction of a track within -route_corridor of a standard route for the trip report to name that route")
+	routeVariation = flag.Float64("route_variation", 300, "Minimum length in meters of a stretch off the standard route to mention in the trip report")
+	routeStray     = flag.Float64("route_stray", 1000, "Median distance in meters from every standard route above which the peak match is flagged for review")
+)
+
+// Points compared per track, enough to find variations of -route_variation.
+const routeComparePoints = 500
+
+// A standard route from -route_library.
+type StandardRoute struct {
+	Name   string
+	Points []LatLng
+}
+
+// How a track compares to the closest standard route of its peak.
+type RouteDeviation struct {
+	Route string
+	// Whether the track followed the route, see -route_match.
+	Matched bool
+	// Median and maximum distance of track points from the route in meters.
+	Median, Max float64
+	// Fraction of the track within -route_corridor of the route.
+	OnRoute float64
+	// Stretches off the route longer than -route_variation, in track order.
+	Variations []RouteVariation
+}
+
+// A stretch of track off the standard route.
+type RouteVariation struct {
+	// Track distance in meters.
+	Length float64
+	// Furthest distance from the route in meters.
+	MaxOff float64
+	// Elevations in meters where the track left and rejoined the route.
+	FromElevation, ToElevation float64
+}
+
+// Loads the standard routes of a peak, named after their files, from its
+// folder in the library or in an area of it. Returns none if there is no
+// folder for the peak.
+func LoadRoutes(peakID string) ([]*StandardRoute, error) {
+	var routes []*StandardRoute
+	for _, pattern := range []string{peakID, filepath.Join("*", peakID)} {
+		dirs, err := filepath.Glob(filepath.Join(*routeLibrary, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			r, err := loadRouteDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, r...)
+		}
+	}
+	return routes, nil
+}
+
+func loadRouteDir(dir string) ([]*StandardRoute, error) {
+	files, err := ioutil.ReadDir(longPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var routes []*StandardRoute
+	for _, fi := range files {
+		if fi.IsDir() || !trackFileSupported(fi.Name()) {
+			continue
+		}
+		points, err := loadRoutePoints(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("route %q %w", fi.Name(), err)
+		}
+		if len(points) < 2 {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".gz")
+		routes = append(routes, &StandardRoute{
+			Name:   strings.TrimSuffix(name, filepath.Ext(name)),
+			Points: points,
+		})
+	}
+	return routes, nil
+}
+
+// Reads the points of a route file. Planned routes are often drawn as GPX
+// routes rather than tracks, so both are read.
+func loadRoutePoints(filename string) ([]LatLng, error) {
+	var g *gpx.GPX
+	var err error
+	if strings.ToLower(filepath.Ext(filename)) == ".gpx" {
+		g, err = gpx.ParseFile(longPath(filename))
+	} else {
+		g, err = LoadGPX(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var points []LatLng
+	for i := range g.Tracks {
+		for _, p := range trackPoints(&g.Tracks[i]) {
+			points = append(points, LatLng{p.Latitude, p.Longitude})
+		}
+	}
+	for _, r := range g.Routes {
+		for _, p := range r.Points {
+			points = append(points, LatLng{p.Latitude, p.Longitude})
+		}
+	}
+	return points, nil
+}
+
+// Compares a track against each route, returning the closest by median
+// distance, or nil without routes.
+func CompareRoutes(points []gpx.GPXPoint, routes []*StandardRoute) *RouteDeviation {
+	points = reducePoints(points, routeComparePoints)
+	var best *RouteDeviation
+	for _, r := range routes {
+		d := compareRoute(points, r)
+		if best == nil || d.Median < best.Median {
+			best = d
+		}
+	}
+	return best
+}
+
+func compareRoute(points []gpx.GPXPoint, r *StandardRoute) *RouteDeviation {
+	d := &RouteDeviation{Route: r.Name}
+	offs := make([]float64, len(points))
+	on := 0
+	var cur *RouteVariation
+	for i := range points {
+		p := &points[i]
+		off := distanceToRoute(LatLng{p.Latitude, p.Longitude}, r.Points)
+		offs[i] = off
+		d.Max = math.Max(d.Max, off)
+		if off <= *routeCorridor {
+			on++
+			if cur != nil {
+				cur.ToElevation = p.Elevation.Value()
+				if cur.Length >= *routeVariation {
+					d.Variations = append(d.Variations, *cur)
+				}
+				cur = nil
+			}
+			continue
+		}
+		if cur == nil {
+			cur = &RouteVariation{FromElevation: p.Elevation.Value()}
+			if i > 0 {
+				cur.FromElevation = points[i-1].Elevation.Value()
+			}
+		} else {
+			cur.Length += pointDistance(&points[i-1], p)
+		}
+		cur.MaxOff = math.Max(cur.MaxOff, off)
+	}
+	if cur != nil && cur.Length >= *routeVariation {
+		// Never rejoined, e.g. a different descent to another trailhead.
+		cur.ToElevation = points[len(points)-1].Elevation.Value()
+		d.Variations = append(d.Variations, *cur)
+	}
+	if len(points) > 0 {
+		d.OnRoute = float64(on) / float64(len(points))
+		sort.Float64s(offs)
+		d.Median = offs[len(offs)/2]
+	}
+	d.Matched = d.OnRoute >= *routeMatch
+	return d
+}
+
+// Distance in meters from a point to the closest segment of a route.
+func distanceToRoute(p LatLng, route []LatLng) float64 {
+	best := math.Inf(1)
+	for i := 1; i < len(route); i++ {
+		best = math.Min(best, segmentDistance(p, route[i-1], route[i]))
+	}
+	return best
+}
+
+// Distance in meters from p to the segment ab, on a plane centered at p which
+// is accurate over the few kilometers routes deviate by.
+func segmentDistance(p, a, b LatLng) float64 {
+	ax, ay := projectFrom(p, a)
+	bx, by := projectFrom(p, b)
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// Position of q in meters east and north of p, on an azimuthal equidistant
+// projection centered at p. Unlike scaling degrees it holds across the
+// antimeridian and near the poles, where east has no fixed direction.
+func projectFrom(p, q LatLng) (float64, float64) {
+	rad := math.Pi / 180
+	sinP, cosP := math.Sincos(p.Lat * rad)
+	sinQ, cosQ := math.Sincos(q.Lat * rad)
+	sinL, cosL := math.Sincos((q.Lng - p.Lng) * rad)
+	bearing := math.Atan2(sinL*cosQ, cosP*sinQ-sinP*cosQ*cosL)
+	d := haversine(p.Lat, p.Lng, q.Lat, q.Lng)
+	return d * math.Sin(bearing), d * math.Cos(bearing)
+}
+
+func (d *RouteDeviation) String() string {
+	return fmt.Sprintf("%q: %.0f%% on route, median %.0fm and max %.0fm off, %d variations", d.Route, d.OnRoute*100, d.Median, d.Max, len(d.Variations))
+}
+
+// Compares a track against the standard routes of its matched peak. A track
+// far from every route is flagged, since it may be matched to the wrong peak.
+func CheckRoutes(points []gpx.GPXPoint, peakID string, entry *ReportEntry) (*RouteDeviation, error) {
+	if *routeLibrary == "" {
+		return nil, nil
+	}
+	routes, err := LoadRoutes(peakID)
+	if err != nil || len(routes) == 0 {
+		return nil, err
+	}
+	d := CompareRoutes(points, routes)
+	log.Infof("Closest standard route %v", d)
+	if d.Median > *routeStray {
+		msg := fmt.Sprintf("track is a median %.0fm from the closest standard route %q, check the peak match", d.Median, d.Route)
+		log.Warnf("Route check: %s", msg)
+		entry.Warnings = append(entry.Warnings, "Route check: "+msg)
+	}
+	if d.Matched {
+		entry.Route = d.Route
+	}
+	return d, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSegmentDistance(t *testing.T) {
+	// Meters per thousandth of a degree of latitude, and of longitude on
+	// the equator.
+	const milli = 111.2
+	tests := []struct {
+		name            string
+		p, a, b         LatLng
+		want, tolerance float64
+	}{
+		{"on the segment", LatLng{0, 0.0005}, LatLng{0, 0}, LatLng{0, 0.001}, 0, 0.01},
+		{"beside the segment", LatLng{0.001, 0.0005}, LatLng{0, 0}, LatLng{0, 0.001}, milli, 0.5},
+		{"past the end", LatLng{0, 0.002}, LatLng{0, 0}, LatLng{0, 0.001}, milli, 0.5},
+		{"degenerate segment", LatLng{0.001, 0}, LatLng{0, 0}, LatLng{0, 0}, milli, 0.5},
+		{"segment across the antimeridian", LatLng{0.001, 180}, LatLng{0, 179.999}, LatLng{0, -179.999}, milli, 0.5},
+		{"point across the antimeridian", LatLng{0.001, -179.9995}, LatLng{0, 179.999}, LatLng{0, 179.9995}, 2 * milli * 0.7071, 1},
+		{"along the antimeridian", LatLng{45.5, -179.9995}, LatLng{45, 180}, LatLng{46, 180}, milli * 0.7009 / 2, 0.5},
+		{"segment over the north pole", LatLng{90, 0}, LatLng{89.999, 0}, LatLng{89.999, 180}, 0, 0.01},
+		{"segment over the south pole", LatLng{-90, 0}, LatLng{-89.999, 45}, LatLng{-89.999, -135}, 0, 0.01},
+		{"beside the north pole", LatLng{89.999, 90}, LatLng{89.999, 0}, LatLng{89.999, 180}, milli, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := segmentDistance(tt.p, tt.a, tt.b); !near(got, tt.want, tt.tolerance) {
+				t.Errorf("segmentDistance(%v, %v, %v) = %.2f, want %.2f ±%v", tt.p, tt.a, tt.b, got, tt.want, tt.tolerance)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// Typically used with -dry_run to check an algorithm or flag change
+	// before re-uploading.
+	compareRun = flag.String("compare_run", "", "Compare this run against a previous -report file and print the tracks whose match or stats changed")
+)
+
+// Differences smaller than these are treated as noise.
+const (
+	compareGainTolerance = 1.0
+	compareTimeTolerance = time.Minute
+)
+
+// A track whose outcome differs between two runs.
+type RunChange struct {
+	File  string
+	Track string
+	// One of new, missing, peak, now_fails, now_matches, stats.
+	Kind   string
+	Detail string
+}
+
+// Loads the -compare_run report, nil when unset. Loaded before the run so a
+// bad path fails fast.
+func LoadCompareRun() (*RunReport, error) {
+	if *compareRun == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(longPath(*compareRun))
+	if err != nil {
+		return nil, err
+	}
+	r := &RunReport{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("parse %s %w", *compareRun, err)
+	}
+	return r, nil
+}
+
+// Keys entries by file and track. Legs of a multi-peak track share a track
+// name so repeats are numbered in order.
+func keyEntries(r *RunReport) (map[string]*ReportEntry, []string) {
+	m := make(map[string]*ReportEntry)
+	var keys []string
+	for _, e := range r.Entries {
+		base := e.File + "\x00" + e.Track
+		key := base
+		for n := 2; m[key] != nil; n++ {
+			key = fmt.Sprintf("%s\x00%d", base, n)
+		}
+		m[key] = e
+		keys = append(keys, key)
+	}
+	return m, keys
+}
+
+// Returns the changes from prev to cur, in the order of cur followed by
+// tracks only present in prev. Files not processed in either run, e.g.
+// skipped by history, are not compared.
+func DiffRuns(prev, cur *RunReport) []RunChange {
+	prevEntries, prevKeys := keyEntries(prev)
+	curEntries, curKeys := keyEntries(cur)
+
+	curFiles := make(map[string]bool)
+	for _, e := range cur.Entries {
+		curFiles[e.File] = true
+	}
+
+	var changes []RunChange
+	for _, k := range curKeys {
+		c, p := curEntries[k], prevEntries[k]
+		change := RunChange{File: c.File, Track: c.Track}
+		switch {
+		case p == nil:
+			change.Kind, change.Detail = "new", describeEntry(c)
+		case p.Error == "" && c.Error != "":
+			change.Kind, change.Detail = "now_fails", c.Error
+		case p.Error != "" && c.Error == "":
+			change.Kind, change.Detail = "now_matches", describeEntry(c)
+		case p.Error != "":
+			continue
+		case p.PeakID != c.PeakID:
+			change.Kind = "peak"
+			change.Detail = fmt.Sprintf("%s -> %s", p.PeakName, c.PeakName)
+		default:
+			d := diffStats(p, c)
+			if d == "" {
+				continue
+			}
+			change.Kind, change.Detail = "stats", d
+		}
+		changes = append(changes, change)
+	}
+
+	for _, k := range prevKeys {
+		p := prevEntries[k]
+		if curEntries[k] == nil && curFiles[p.File] {
+			changes = append(changes, RunChange{File: p.File, Track: p.Track, Kind: "missing", Detail: describeEntry(p)})
+		}
+	}
+	return changes
+}
+
+func describeEntry(e *ReportEntry) string {
+	if e.Error != "" {
+		return e.Error
+	}
+	return e.PeakName
+}
+
+// Describes stat changes for the same peak, empty when within tolerance.
+func diffStats(p, c *ReportEntry) string {
+	var d []string
+	if (p.Date == nil) != (c.Date == nil) || p.Date != nil && !p.Date.Equal(*c.Date) {
+		d = append(d, fmt.Sprintf("date %s -> %s", formatReportDate(p.Date), formatReportDate(c.Date)))
+	}
+	durations := []struct {
+		name string
+		p, c time.Duration
+	}{
+		{"up", p.TimeUp, c.TimeUp},
+		{"down", p.TimeDown, c.TimeDown},
+		{"on summit", p.TimeOnSummit, c.TimeOnSummit},
+	}
+	for _, v := range durations {
+		if delta := v.c - v.p; delta >= compareTimeTolerance || -delta >= compareTimeTolerance {
+			d = append(d, fmt.Sprintf("%s %v -> %v", v.name, v.p.Round(time.Minute), v.c.Round(time.Minute)))
+		}
+	}
+	if math.Abs(c.Gain-p.Gain) >= compareGainTolerance {
+		d = append(d, fmt.Sprintf("gain %.0fm -> %.0fm", p.Gain, c.Gain))
+	}
+	return strings.Join(d, ", ")
+}
+
+func formatReportDate(t *time.Time) string {
+	if t == nil {
+		return "none"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// Prints the changes between a previous report and this run.
+func PrintRunDiff(w io.Writer, prev, cur *RunReport) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	changes := DiffRuns(prev, cur)
+	fmt.Fprintf(w, "Compared %d tracks against %s: %d changed\n", len(cur.Entries), *compareRun, len(changes))
+	if len(changes) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Kind, c.File, c.Track, c.Detail)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A scan scheduled with a cron expression, optionally of its own directory
+// or activity source.
+type scheduledScan struct {
+	Spec      string
+	Directory string
+	// Activity source to sync, -source if empty.
+	Source string
+
+	cron  *cronSchedule
+	index indexWatcher
+	overlapGuard
+}
+
+// Describes what the scan reads, e.g. "sync of strava into /tracks".
+func (s *scheduledScan) describe() string {
+	source := s.Source
+	if source == "" {
+		source = *sourceName
+	}
+	if source != "" {
+		return fmt.Sprintf("sync of %s into %q", source, s.Directory)
+	}
+	return fmt.Sprintf("scan of %q", s.Directory)
+}
+
+// Tracks whether a scheduled job is in progress, so slow jobs are skipped
+// rather than piling up.
+type overlapGuard struct {
+	mu      sync.Mutex
+	running bool
+}
+
+// Marks the job running, returning false if it already was.
+func (g *overlapGuard) start() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return false
+	}
+	g.running = true
+	return true
+}
+
+func (g *overlapGuard) done() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running = false
+}
+
+// Repeatable -schedule flag.
+type scheduleList []*scheduledScan
+
+var schedules scheduleList
+
+func init() {
+	flag.Var(&schedules, "schedule", `In daemon mode, scan on a cron schedule such as "0 3 * * *" instead of every -daemon_interval. A source such as source=strava may follow the expression to sync it instead of -source, then a directory to scan or keep the source's history in instead of -directory, e.g. "0 3 * * * source=strava". May be repeated`)
+}
+
+func (l *scheduleList) String() string {
+	var specs []string
+	for _, s := range *l {
+		specs = append(specs, s.Spec)
+	}
+	return strings.Join(specs, ", ")
+}
+
+func (l *scheduleList) Set(v string) error {
+	s, err := parseScheduledScan(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// Parses "<cron expression> [source=<name>] [directory]".
+func parseScheduledScan(v string) (*scheduledScan, error) {
+	v = strings.TrimSpace(v)
+	n := 5
+	if strings.HasPrefix(v, "@") {
+		n = 1
+	}
+	rest := v
+	var fields []string
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("schedule %q: expected %d cron fields", v, n)
+		}
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+	spec := strings.Join(fields, " ")
+	c, err := parseCron(spec)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q: %w", v, err)
+	}
+	s := &scheduledScan{Spec: spec, Directory: strings.TrimSpace(rest), cron: c}
+	if strings.HasPrefix(s.Directory, "source=") {
+		end := strings.IndexAny(s.Directory, " \t")
+		if end < 0 {
+			end = len(s.Directory)
+		}
+		s.Source = strings.TrimPrefix(s.Directory[:end], "source=")
+		s.Directory = strings.TrimSpace(s.Directory[end:])
+		if _, err := lookupActivitySource(s.Source); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", v, err)
+		}
+	}
+	return s, nil
+}
+
+// A parsed five field cron expression (minute hour day-of-month month
+// day-of-week), matched in local time.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// Whether day-of-month or day-of-week is "*". As in cron, when both are
+	// restricted a day matching either runs.
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	if m, ok := cronMacros[spec]; ok {
+		spec = m
+	}
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("expected 5 cron fields, got %d", len(f))
+	}
+	c := &cronSchedule{domAny: f[2] == "*", dowAny: f[4] == "*"}
+	var err error
+	for _, field := range []struct {
+		set      *map[int]bool
+		spec     string
+		min, max int
+	}{
+		{&c.minute, f[0], 0, 59},
+		{&c.hour, f[1], 0, 23},
+		{&c.dom, f[2], 1, 31},
+		{&c.month, f[3], 1, 12},
+		{&c.dow, f[4], 0, 7},
+	} {
+		if *field.set, err = parseCronField(field.spec, field.min, field.max); err != nil {
+			return nil, err
+		}
+	}
+	// Both 0 and 7 mean Sunday.
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	return c, nil
+}
+
+// Parses a comma separated list of *, values, ranges and /steps.
+func parseCronField(spec string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step, part = s, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			r := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(r[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(r) == 2 {
+				if hi, err = strconv.Atoi(r[1]); err != nil {
+					return nil, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Returns the first matching minute after t, or the zero time if there is
+// none within five years (e.g. February 30th).
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Runs scans on their cron schedules forever. Scans share the uploader, so
+// they run one at a time; a schedule that fires while its previous scan is
+// still in progress is skipped.
+func (u *Uploader) RunSchedules() error {
+	for _, s := range schedules {
+		if s.Directory == "" {
+			if *inputDirectory == "" {
+				return fmt.Errorf("schedule %q has no directory and -directory is not set", s.Spec)
+			}
+			s.Directory = *inputDirectory
+		}
+	}
+
+	var crons []*cronSchedule
+	for _, s := range schedules {
+		crons = append(crons, s.cron)
+		log.Infof("Scheduled %s at %q, first at %v", s.describe(), s.Spec, s.cron.Next(time.Now()))
+	}
+	return runCron(crons, func(i int) {
+		u.runScheduledScan(schedules[i])
+	})
+}
+
+// Calls run(i) in a new goroutine each time crons[i] fires. Only returns
+// once no schedule can fire again.
+func runCron(crons []*cronSchedule, run func(i int)) error {
+	now := time.Now()
+	next := make([]time.Time, len(crons))
+	for i, c := range crons {
+		next[i] = c.Next(now)
+	}
+
+	for {
+		soonest := -1
+		for i, t := range next {
+			if !t.IsZero() && (soonest < 0 || t.Before(next[soonest])) {
+				soonest = i
+			}
+		}
+		if soonest < 0 {
+			return fmt.Errorf("no schedule will ever run")
+		}
+		time.Sleep(time.Until(next[soonest]))
+
+		now := time.Now()
+		for i, c := range crons {
+			if next[i].IsZero() || next[i].After(now) {
+				continue
+			}
+			next[i] = c.Next(now)
+			go run(i)
+		}
+	}
+}
+
+func (u *Uploader) runScheduledScan(s *scheduledScan) {
+	if !s.start() {
+		log.Warnf("Previous %s for %q still running, skipping", s.describe(), s.Spec)
+		return
+	}
+	defer s.done()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	log.Infof("Scheduled %s", s.describe())
+	u.dir, u.source = s.Directory, s.Source
+	if u.source == "" {
+		u.source = *sourceName
+	}
+	if err := s.index.Update(u.dir); err != nil {
+		log.Warnf("Failed to update index: %v", err)
+	}
+	since, pendingBefore := u.report.Len(), u.approvals.added()
+	err := u.Run()
+	if IsUnavailable(err) {
+		log.Warnf("%v, waiting for the next scheduled scan", err)
+		return
+	}
+	u.NotifyResults(since, pendingBefore)
+	if err != nil {
+		log.Errorf("Scheduled %s failed: %v", s.describe(), err)
+	}
+	if err := u.WriteReport(); err != nil {
+		log.Errorf("Failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// Known secret values, redacted wherever they appear.
+var secrets struct {
+	sync.Mutex
+	values []string
+}
+
+// Secrets in well known places, redacted even when the value wasn't
+// registered, e.g. a session cookie set by the server.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:password|passwd|pwd|token|secret|code_verifier)=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)("(?:password|access_token|refresh_token|client_secret|AccessToken|RefreshToken|Password)"\s*:\s*")[^"]*`),
+	regexp.MustCompile(`(?im)^((?:authorization|cookie|set-cookie):\s*).*$`),
+}
+
+// Registers a value that must never be logged or written out.
+func RegisterSecret(s string) {
+	// Very short values would redact unrelated text.
+	if len(s) < 4 {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range secrets.values {
+		if v == s {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, s)
+}
+
+// Replaces registered secrets and secret-looking values in s.
+func Redact(s string) string {
+	secrets.Lock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	secrets.Unlock()
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// Redacts secrets from all log output.
+type redactingFormatter struct {
+	log.Formatter
+}
+
+func (f *redactingFormatter) Format(e *log.Entry) ([]byte, error) {
+	b, err := f.Formatter.Format(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Redact(string(b))), nil
+}
+
+// Registers secrets passed as flags or through the environment.
+func RegisterFlagSecrets() {
+	for _, s := range []string{
+		*passwordPB,
+		os.Getenv(passwordEnv),
+		*pushoverToken,
+		*pushoverUser,
+		*stravaClientSecret,
+		*garminClientSecret,
+		*suuntoClientSecret,
+		*suuntoSubscriptionKey,
+		*corosPassword,
+		*polarClientSecret,
+		os.Getenv(corosPasswordEnv),
+		*dropboxToken,
+		os.Getenv(dropboxTokenEnv),
+	} {
+		RegisterSecret(s)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	sheetsID          = flag.String("sheets_id", "", "Append a summary row per processed track to this Google Sheets spreadsheet ID (from its URL) whenever the report is written")
+	sheetsCredentials = flag.String("sheets_credentials", "", "Google service account JSON key for -sheets_id; share the spreadsheet with the service account's email as an editor")
+	sheetsTab         = flag.String("sheets_tab", "Sheet1", "Name of the sheet (tab) rows are appended to")
+)
+
+const (
+	sheetsScope  = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsAPIURL = "https://sheets.googleapis.com/v4/spreadsheets"
+)
+
+var sheetsHeader = []string{"Processed", "File", "Track", "Peak", "Peak ID", "Date", "Time up", "Time down", "Gain (m)", "Status", "Error"}
+
+// The fields of a service account key file used for the JWT bearer grant.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Appends report entries to a spreadsheet. Entries are exported once, when
+// finished, so a daemon can export after every scan.
+type sheetExporter struct {
+	mu       sync.Mutex
+	exported map[*ReportEntry]bool
+	header   bool
+
+	token  string
+	expiry time.Time
+}
+
+var sheets = &sheetExporter{exported: make(map[*ReportEntry]bool)}
+
+// Appends rows for entries not yet exported if -sheets_id is set. Entries
+// awaiting approval are exported once resolved. Rows that fail to export are
+// kept in the input directory and appended by a later export, even by
+// another run.
+func (u *Uploader) ExportSheet() error {
+	if *sheetsID == "" {
+		return nil
+	}
+	if *offlineSimulate {
+		log.Debugf("Not exporting to Google Sheets with -offline_simulate")
+		return nil
+	}
+
+	sheets.mu.Lock()
+	defer sheets.mu.Unlock()
+
+	pending, err := loadPendingSheetRows(u.dir)
+	if err != nil {
+		return err
+	}
+	rows := pending
+	n := 0
+	for _, e := range u.report.entriesSince(0) {
+		if sheets.exported[e] || u.approvals.HasEntry(e) {
+			continue
+		}
+		rows = append(rows, sheetRow(u.report.Started, e))
+		// From here on the row is either appended or pending.
+		sheets.exported[e] = true
+		n++
+	}
+	if n == 0 && len(pending) == 0 {
+		return nil
+	}
+
+	if err := sheets.appendRows(rows); err != nil {
+		if serr := savePendingSheetRows(u.dir, rows); serr != nil {
+			log.Errorf("Failed to keep %d rows for Google Sheets: %v", len(rows), serr)
+		}
+		return err
+	}
+	if len(pending) > 0 {
+		if err := savePendingSheetRows(u.dir, nil); err != nil {
+			return err
+		}
+	}
+	log.Infof("Exported %d rows to Google Sheets", len(rows))
+	return nil
+}
+
+// Appends rows to the sheet, after a header row if it is empty.
+func (s *sheetExporter) appendRows(rows [][]string) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return fmt.Errorf("google auth %w", err)
+	}
+	if !s.header {
+		empty, err := sheetEmpty(token)
+		if err != nil {
+			return err
+		}
+		if empty {
+			rows = append([][]string{sheetsHeader}, rows...)
+		}
+		s.header = true
+	}
+	return appendSheetRows(token, rows)
+}
+
+// Rows that failed to export, waiting in the input directory.
+const SheetsPendingFilename = "sheets_pending.json"
+
+func loadPendingSheetRows(dir string) ([][]string, error) {
+	b, err := os.ReadFile(longPath(filepath.Join(dir, SheetsPendingFilename)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return nil, fmt.Errorf("parse %s %w", SheetsPendingFilename, err)
+	}
+	return rows, nil
+}
+
+// Saves the rows still to export, removing the file once there are none.
+func savePendingSheetRows(dir string, rows [][]string) error {
+	p := longPath(filepath.Join(dir, SheetsPendingFilename))
+	if len(rows) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(rows, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, b, 0644)
+}
+
+func sheetRow(started time.Time, e *ReportEntry) []string {
+	date := ""
+	if e.Date != nil {
+		date = e.Date.Local().Format("2006-01-02")
+	}
+	gain := ""
+	if e.Gain > 0 {
+		gain = fmt.Sprintf("%.0f", e.Gain)
+	}
+	status := "dry run"
+	switch {
+	case e.Uploaded:
+		status = "uploaded"
+	case e.Queued:
+		status = "queued"
+	case e.Error != "":
+		status = e.ErrorCategory
+		if status == "" {
+			status = "skipped"
+		}
+	}
+	return []string{
+		started.Local().Format("2006-01-02 15:04"),
+		e.File,
+		e.Track,
+		e.PeakName,
+		e.PeakID,
+		date,
+		formatSheetDuration(e.TimeUp),
+		formatSheetDuration(e.TimeDown),
+		gain,
+		status,
+		e.Error,
+	}
+}
+
+// Formats as h:mm, which sheets parse as a duration.
+func formatSheetDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	m := int(d.Round(time.Minute).Minutes())
+	return fmt.Sprintf("%d:%02d", m/60, m%60)
+}
+
+// Returns an access token for the service account, cached until shortly
+// before it expires.
+func (s *sheetExporter) accessToken() (string, error) {
+	if s.token != "" && time.Now().Before(s.expiry.Add(-time.Minute)) {
+		return s.token, nil
+	}
+	if *sheetsCredentials == "" {
+		return "", errors.New("-sheets_id requires -sheets_credentials")
+	}
+	b, err := os.ReadFile(longPath(*sheetsCredentials))
+	if err != nil {
+		return "", err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(b, &key); err != nil {
+		return "", fmt.Errorf("parse %s %w", *sheetsCredentials, err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return "", fmt.Errorf("%s is not a service account key", *sheetsCredentials)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	assertion, err := signServiceAccountJWT(&key, time.Now())
+	if err != nil {
+		return "", err
+	}
+	resp, err := apiClient().PostForm(key.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token request: %s %s", resp.Status, body)
+	}
+	var r struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", err
+	}
+	RegisterSecret(r.AccessToken)
+	s.token = r.AccessToken
+	s.expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// Builds the signed JWT asserting the service account's identity.
+func signServiceAccountJWT(key *serviceAccountKey, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New("service account private key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("parse service account private key %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account private key is not RSA")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+func sheetsRequest(token, method, u string, body interface{}, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := apiClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("google sheets: %s %s", resp.Status, b)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Whether the sheet has nothing in its first cell, so needs a header row.
+func sheetEmpty(token string) (bool, error) {
+	u := fmt.Sprintf("%s/%s/values/%s", sheetsAPIURL, url.PathEscape(*sheetsID), url.PathEscape(*sheetsTab+"!A1:A1"))
+	var r struct {
+		Values [][]string `json:"values"`
+	}
+	if err := sheetsRequest(token, http.MethodGet, u, nil, &r); err != nil {
+		return false, err
+	}
+	return len(r.Values) == 0, nil
+}
+
+func appendSheetRows(token string, rows [][]string) error {
+	u := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		sheetsAPIURL, url.PathEscape(*sheetsID), url.PathEscape(*sheetsTab))
+	return sheetsRequest(token, http.MethodPost, u, map[string]interface{}{"values": rows}, nil)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"peakbagger-tools/pbtools/peakbagger"
+	"peakbagger-tools/pbtools/track"
+)
+
+var (
+	offlineSimulate = flag.Bool("offline_simulate", false, "Run the whole pipeline against a local peak database and a fake Peakbagger, without any network access. The ascents it would add are recorded in "+SimulatedManifestFilename+" instead of the manifest")
+	peakDBFile      = flag.String("peak_db", "", "Local peak database (JSON list of peaks with PeakID, Name, Latitude and Longitude) for -offline_simulate")
+)
+
+var errNetworkDisabled = errors.New("network access disabled by -offline_simulate or -offline")
+
+// A fake Peakbagger backed by a local peak database. Added ascents are kept
+// in memory so repeat detection works within a run. Also matches peaks for
+// -offline, which queues ascents before they reach the client.
+type simulatedClient struct {
+	peaks   peakbagger.PeakList
+	ascents peakbagger.AscentList
+	nextID  peakbagger.AscentID
+}
+
+func newSimulatedClient() (Client, error) {
+	if *peakDBFile == "" {
+		return nil, fmt.Errorf("-offline_simulate and -offline require -peak_db")
+	}
+	b, err := os.ReadFile(*peakDBFile)
+	if err != nil {
+		return nil, fmt.Errorf("read peak db %w", err)
+	}
+	c := &simulatedClient{}
+	if err := json.Unmarshal(b, &c.peaks); err != nil {
+		return nil, fmt.Errorf("parse peak db %w", err)
+	}
+	if *offlineSimulate {
+		log.Infof("Simulating Peakbagger with %d local peaks", len(c.peaks))
+	} else {
+		log.Infof("Working offline with %d local peaks", len(c.peaks))
+	}
+
+	// Guarantee nothing leaks out to the network.
+	wrapDefaultTransport(func(http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errNetworkDisabled
+		})
+	})
+	return c, nil
+}
+
+func (c *simulatedClient) FindPeaks(b *track.Bounds) (peakbagger.PeakList, error) {
+	var found peakbagger.PeakList
+	for _, p := range c.peaks {
+		if p.Latitude >= b.MinLat && p.Latitude <= b.MaxLat && p.Longitude >= b.MinLng && p.Longitude <= b.MaxLng {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+func (c *simulatedClient) ListAscents() (peakbagger.AscentList, error) {
+	return c.ascents, nil
+}
+
+func (c *simulatedClient) AddAscent(a peakbagger.Ascent) (peakbagger.AscentID, error) {
+	a.Gpx = nil
+	c.ascents = append(c.ascents, &a)
+	c.nextID++
+	log.Infof("SIMULATED ascent %v added", c.nextID)
+	return c.nextID, nil
+}
+
+// Unsuccessful ascents aren't listed, so they don't count as repeats.
+func (c *simulatedClient) AddAttempt(a peakbagger.Ascent, elevationReached, distanceShort float64, reason string) (peakbagger.AscentID, error) {
+	c.nextID++
+	log.Infof("SIMULATED unsuccessful ascent %v added, reached %.0fm, %.0fm short: %s", c.nextID, elevationReached, distanceShort, reason)
+	return c.nextID, nil
+}
+
+func (c *simulatedClient) UpdateAscent(existing *peakbagger.Ascent, a peakbagger.Ascent) error {
+	*existing = a
+	log.Infof("SIMULATED ascent of %v on %v updated", a.PeakID, a.Date)
+	return nil
+}
+
+func (c *simulatedClient) SetAscentPrivate(id peakbagger.AscentID, private bool) error {
+	log.Infof("SIMULATED ascent %v private: %v", id, private)
+	return nil
+}
+
+func (c *simulatedClient) SetAscentAssisted(id peakbagger.AscentID, assistedGain, ownGain float64) error {
+	log.Infof("SIMULATED ascent %v motorized, %.0fm by machine and %.0fm under own power", id, assistedGain, ownGain)
+	return nil
+}
+
+func (c *simulatedClient) LocatePeak(peakID string) (string, float64, float64, error) {
+	for _, p := range c.peaks {
+		if fmt.Sprint(p.PeakID) == peakID {
+			return p.Name, p.Latitude, p.Longitude, nil
+		}
+	}
+	return "", 0, 0, fmt.Errorf("peak %s not in -peak_db", peakID)
+}
+
+// Matches names in the -peak_db, which has no states so state is ignored.
+func (c *simulatedClient) SearchPeaks(name, state string) (peakbagger.PeakList, error) {
+	var found peakbagger.PeakList
+	for _, p := range c.peaks {
+		if containsFold([]string{p.Name}, name) {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Files this tool keeps in the input directory, never reported as skipped.
+var uploaderFiles = map[string]bool{
+	HistoryFilename:           true,
+	ManifestFilename:          true,
+	SimulatedManifestFilename: true,
+	AttemptsFilename:          true,
+	QueueFilename:             true,
+	IndexFilename:             true,
+	UploaderLogFilename:       true,
+	SheetsPendingFilename:     true,
+}
+
+// Suggestions for extensions of formats that aren't read, by extension.
+var skippedFormatHints = map[string]string{
+	".plt":  "OziExplorer track, convert it to GPX with gpsbabel -i ozi",
+	".csv":  "convert it to GPX with gpsbabel -i unicsv if it has latitude, longitude and time columns",
+	".loc":  "Geocaching waypoints, not a track",
+	".gpz":  "compressed GPX, unzip it",
+	".pwx":  "TrainingPeaks workout, export it from TrainingPeaks as FIT or TCX instead",
+	".sml":  "Suunto Moveslink log, export it from Suunto as FIT instead",
+	".json": "only GeoJSON tracks are read, add a " + formatSidecarExt + " sidecar holding geojson if it is one",
+	".jpg":  "a photo, not a track",
+	".jpeg": "a photo, not a track",
+	".png":  "an image, not a track",
+	".heic": "a photo, not a track",
+	".pdf":  "a document, not a track",
+}
+
+// A kind of file skipped by directory mode.
+type skippedKind struct {
+	Ext        string
+	Content    string
+	Suggestion string
+}
+
+// Logs a summary of the files in an input directory that aren't read, with
+// what they look like and what to do about them, so nothing is ignored
+// silently. Files of formats that need gpsbabel are reported too when it
+// isn't installed, since they fail to read.
+func ReportSkippedFiles(dir string) error {
+	files, err := ioutil.ReadDir(longPath(dir))
+	if err != nil {
+		return err
+	}
+	_, gpsbabelErr := exec.LookPath("gpsbabel")
+
+	skipped := make(map[skippedKind][]string)
+	var needGPSBabel []string
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || uploaderFiles[name] || strings.HasPrefix(name, ".") ||
+			strings.HasSuffix(name, formatSidecarExt) || strings.EqualFold(filepath.Ext(name), ".zip") {
+			continue
+		}
+		if inputFileSupported(dir, name) {
+			if gpsbabelErr != nil && needsGPSBabel(dir, name) {
+				needGPSBabel = append(needGPSBabel, name)
+			}
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		kind := skippedKind{Ext: ext, Content: describeContent(filepath.Join(dir, name))}
+		kind.Suggestion = skippedSuggestion(kind)
+		skipped[kind] = append(skipped[kind], name)
+	}
+
+	var kinds []skippedKind
+	for k := range skipped {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].Ext != kinds[j].Ext {
+			return kinds[i].Ext < kinds[j].Ext
+		}
+		return kinds[i].Content < kinds[j].Content
+	})
+	for _, k := range kinds {
+		names := skipped[k]
+		ext := k.Ext
+		if ext == "" {
+			ext = "extensionless"
+		}
+		log.Warnf("Skipped %d %s file(s) like %q that look like %s: %s", len(names), ext, names[0], k.Content, k.Suggestion)
+		if len(names) > 1 {
+			log.Debugf("Skipped %s files: %s", ext, strings.Join(names, ", "))
+		}
+	}
+	if len(needGPSBabel) > 0 {
+		log.Warnf("%d file(s) like %q need gpsbabel, which isn't installed: install it from https://www.gpsbabel.org or convert them to GPX", len(needGPSBabel), needGPSBabel[0])
+	}
+	return nil
+}
+
+// Whether a supported file is only read through gpsbabel, going by its
+// format override, content or extension like prepareTrack.
+func needsGPSBabel(dir, name string) bool {
+	filename := filepath.Join(dir, name)
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(name), ".gz")))
+	if format, err := formatOverride(filename); err == nil && format != "" {
+		ext = "." + format
+	} else if sniffed := sniffFormat(filename); sniffed != "" {
+		ext = sniffed
+	}
+	_, native := nativeReaders[ext]
+	return !native
+}
+
+// Describes what a file's first bytes look like, for formats sniffFormat
+// doesn't recognize.
+func describeContent(filename string) string {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return "an unreadable file"
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "an empty file"
+	}
+	head = head[:n]
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "a zip archive"
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return "gzipped data"
+	case bytes.HasPrefix(text, []byte("<")):
+		if root := xmlRootElement(text); root != "" {
+			return fmt.Sprintf("XML with root <%s>", root)
+		}
+		return "XML"
+	case bytes.HasPrefix(text, []byte("{")), bytes.HasPrefix(text, []byte("[")):
+		return "JSON"
+	case utf8.Valid(head) && !bytes.ContainsRune(head, 0):
+		return "text"
+	}
+	return "binary data"
+}
+
+func skippedSuggestion(k skippedKind) string {
+	if hint, ok := skippedFormatHints[k.Ext]; ok {
+		return hint
+	}
+	switch {
+	case k.Content == "a zip archive":
+		return "rename it to .zip to upload the tracks inside"
+	case k.Content == "gzipped data":
+		return "name it <file>.<format>.gz, e.g. track.gpx.gz"
+	case strings.HasPrefix(k.Content, "XML"):
+		return "not a GPX, KML or TCX track; convert it to GPX if it holds one"
+	}
+	return fmt.Sprintf("not a known track format; give its format in a <file>%s sidecar if it is one, or convert it to GPX", formatSidecarExt)
+}
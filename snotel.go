@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	snotel            = flag.Bool("snotel", false, "Look up snow depth at the nearest SNOTEL station for ascents in the western US")
+	snotelMaxDistance = flag.Float64("snotel_max_distance", 50000, "Maximum distance in meters to a SNOTEL station")
+)
+
+const snotelAPI = "https://wcc.sc.egov.usda.gov/awdbRestApi/services/v1"
+
+// Snow depth reported by a SNOTEL station on an ascent date.
+type SnotelObservation struct {
+	Station string
+	// Station elevation and distance from the summit in meters.
+	Elevation float64
+	Distance  float64
+	// Snow depth in inches.
+	Depth float64
+}
+
+func (o *SnotelObservation) String() string {
+	return fmt.Sprintf("SNOTEL %s (%.0f ft, %.0f mi away) reported %.0f in of snow",
+		o.Station, o.Elevation/metersPerFoot, o.Distance/metersPerMile, o.Depth)
+}
+
+type snotelStation struct {
+	StationTriplet string  `json:"stationTriplet"`
+	Name           string  `json:"name"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	// In feet.
+	Elevation float64 `json:"elevation"`
+}
+
+// Station list, fetched on first use.
+var snotelStations []snotelStation
+
+// SNOTEL only covers the western US and Alaska.
+func inSnotelCoverage(lat, lng float64) bool {
+	west := lat > 31 && lat < 49.5 && lng > -125 && lng < -102
+	alaska := lat > 51 && lat < 72 && lng > -170 && lng < -129
+	return west || alaska
+}
+
+func snotelGet(path string, q url.Values, v interface{}) error {
+	u := snotelAPI + path + "?" + q.Encode()
+	resp, err := apiClient().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("snotel %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Returns the snow depth at the nearest SNOTEL station on the given date, or
+// nil if there's no station in range or no reading that day.
+func NearestSnotel(lat, lng float64, date time.Time) (*SnotelObservation, error) {
+	if !inSnotelCoverage(lat, lng) {
+		return nil, nil
+	}
+	if snotelStations == nil {
+		err := snotelGet("/stations", url.Values{
+			"stationTriplets":       {"*:*:SNTL"},
+			"returnStationElements": {"false"},
+			"activeOnly":            {"false"},
+		}, &snotelStations)
+		if err != nil {
+			return nil, fmt.Errorf("snotel stations %w", err)
+		}
+	}
+
+	var nearest *snotelStation
+	best := *snotelMaxDistance
+	for i := range snotelStations {
+		s := &snotelStations[i]
+		if d := Distance(lat, lng, s.Latitude, s.Longitude); d <= best {
+			nearest, best = s, d
+		}
+	}
+	if nearest == nil {
+		return nil, nil
+	}
+
+	day := date.Format("2006-01-02")
+	var data []struct {
+		Data []struct {
+			Values []struct {
+				Date  string   `json:"date"`
+				Value *float64 `json:"value"`
+			} `json:"values"`
+		} `json:"data"`
+	}
+	err := snotelGet("/data", url.Values{
+		"stationTriplets": {nearest.StationTriplet},
+		"elements":        {"SNWD"},
+		"duration":        {"DAILY"},
+		"beginDate":       {day},
+		"endDate":         {day},
+	}, &data)
+	if err != nil {
+		return nil, fmt.Errorf("snotel data %w", err)
+	}
+	for _, d := range data {
+		for _, e := range d.Data {
+			for _, v := range e.Values {
+				if v.Value != nil {
+					return &SnotelObservation{
+						Station:   nearest.Name,
+						Elevation: nearest.Elevation * metersPerFoot,
+						Distance:  best,
+						Depth:     *v.Value,
+					}, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"time"
+)
+
+var (
+	daylightInTripReport = flag.Bool("trip_report_daylight", false, "Include daylight used and starts before sunrise or finishes after sunset in trip reports")
+)
+
+// Sunrise and sunset in UTC for the solar day containing t at a location,
+// using the NOAA approximation (accurate to about a minute). ok is false
+// during polar day or night, when up reports whether the sun stays up.
+func SunTimes(t time.Time, lat, lng float64) (sunrise, sunset time.Time, ok, up bool) {
+	// The solar day at the location, by approximate local solar time.
+	local := t.UTC().Add(time.Duration(lng / 15 * float64(time.Hour)))
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+
+	g := 2 * math.Pi / 365 * float64(day.YearDay()-1)
+	eqtime := 229.18 * (0.000075 + 0.001868*math.Cos(g) - 0.032077*math.Sin(g) -
+		0.014615*math.Cos(2*g) - 0.040849*math.Sin(2*g))
+	decl := 0.006918 - 0.399912*math.Cos(g) + 0.070257*math.Sin(g) -
+		0.006758*math.Cos(2*g) + 0.000907*math.Sin(2*g) -
+		0.002697*math.Cos(3*g) + 0.00148*math.Sin(3*g)
+
+	phi := lat * math.Pi / 180
+	cosHA := math.Cos(90.833*math.Pi/180)/(math.Cos(phi)*math.Cos(decl)) - math.Tan(phi)*math.Tan(decl)
+	if cosHA < -1 || cosHA > 1 {
+		return time.Time{}, time.Time{}, false, cosHA < -1
+	}
+	ha := math.Acos(cosHA) * 180 / math.Pi
+
+	minutes := func(m float64) time.Time {
+		return day.Add(time.Duration(m * float64(time.Minute)))
+	}
+	return minutes(720 - 4*(lng+ha) - eqtime), minutes(720 - 4*(lng-ha) - eqtime), true, false
+}
+
+// Daylight available and used on an outing.
+type Daylight struct {
+	Sunrise time.Time `json:",omitempty"`
+	Sunset  time.Time `json:",omitempty"`
+
+	// Daylight on the day, and the part of it spent on the outing.
+	Available time.Duration
+	Used      time.Duration
+	// Time on the outing before sunrise or after sunset.
+	Dark time.Duration
+
+	StartedBeforeSunrise bool
+	FinishedAfterSunset  bool
+}
+
+// Computes the daylight budget of an outing from start to end at a location.
+func ComputeDaylight(start, end time.Time, lat, lng float64) *Daylight {
+	d := &Daylight{}
+	sunrise, sunset, ok, up := SunTimes(start, lat, lng)
+	total := end.Sub(start)
+	switch {
+	case !ok && up:
+		d.Available, d.Used = 24*time.Hour, total
+		return d
+	case !ok:
+		d.Dark = total
+		return d
+	}
+
+	d.Sunrise, d.Sunset = sunrise.Round(time.Second), sunset.Round(time.Second)
+	d.Available = sunset.Sub(sunrise).Round(time.Minute)
+	from, to := start, end
+	if from.Before(sunrise) {
+		from = sunrise
+	}
+	if to.After(sunset) {
+		to = sunset
+	}
+	if to.After(from) {
+		d.Used = to.Sub(from).Round(time.Minute)
+	}
+	d.Dark = (total - d.Used).Round(time.Minute)
+	d.StartedBeforeSunrise = start.Before(sunrise)
+	d.FinishedAfterSunset = end.After(sunset)
+	return d
+}
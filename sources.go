@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	sourceName  = flag.String("source", "", "Cloud service to sync recent activities from instead of reading files: caltopo, coros, dropbox, garmin, polar, strava or suunto. Authorize first with the auth command, except for caltopo which reads a shared -caltopo_map; history is kept in -directory")
+	sourceLimit = flag.Int("source_limit", 50, "Number of most recent activities to check on each -source sync")
+)
+
+// Metadata about where an input came from, applied to its trip report
+// source.
+type activityMetadata interface {
+	apply(src *Source)
+}
+
+// An activity in a cloud service.
+type RemoteActivity struct {
+	// Source name, e.g. garmin.
+	Source string
+	ID     string
+	Name   string
+	Type   string
+	Start  time.Time
+	URL    string
+}
+
+func (a *RemoteActivity) apply(src *Source) {
+	src.Type = a.Source
+	src.ActivityName = a.Name
+	src.URL = a.URL
+}
+
+// A cloud service activities can be synced from.
+type ActivitySource interface {
+	// Lists up to limit of the most recent activities.
+	Activities(limit int) ([]*RemoteActivity, error)
+	// Whether an activity is of a type to upload.
+	Selected(a *RemoteActivity) bool
+	// Downloads an activity's track into dir, returning the file.
+	Download(a *RemoteActivity, dir string) (string, error)
+}
+
+var activitySources = map[string]func() (ActivitySource, error){
+	"garmin":  newGarminSource,
+	"strava":  newStravaSource,
+	"suunto":  newSuuntoSource,
+	"coros":   newCorosSource,
+	"polar":   newPolarSource,
+	"caltopo": newCaltopoSource,
+	"dropbox": newDropboxSource,
+}
+
+func lookupActivitySource(name string) (func() (ActivitySource, error), error) {
+	s, ok := activitySources[name]
+	if !ok {
+		var names []string
+		for n := range activitySources {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown -source %q, available sources: %s", name, strings.Join(names, ", "))
+	}
+	return s, nil
+}
+
+// Uploads the recent activities of the source, see -source. Each is recorded in the history
+// as "<source>/<activity id>" so repeated syncs skip activities already
+// processed, however the service names their files.
+func (u *Uploader) RunSource() error {
+	if u.dir == "" {
+		return fmt.Errorf("-source requires -directory to keep its history in")
+	}
+	newSource, err := lookupActivitySource(u.source)
+	if err != nil {
+		return err
+	}
+	source, err := newSource()
+	if err != nil {
+		return err
+	}
+	if err := u.LoadHistory(); err != nil {
+		return err
+	}
+
+	activities, err := source.Activities(*sourceLimit)
+	if err != nil {
+		return fmt.Errorf("list %s activities %w", u.source, err)
+	}
+
+	tmp, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	u.activities = make(map[string]activityMetadata)
+	selected := 0
+	for _, a := range activities {
+		if !inDateRange(a.Start) || !source.Selected(a) {
+			continue
+		}
+		selected++
+		a := a
+		name := a.Source + "/" + a.ID
+		u.activities[name] = a
+		err := u.processInput(name, nil, func() error {
+			filename, err := source.Download(a, tmp)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(filename)
+			return u.uploadRecovering(filename, name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Processed %d of %d recent %s activities", selected, len(activities), u.source)
+	return nil
+}
+
+// Requests a cloud API with an OAuth access token, failing on error
+// statuses. The caller closes the body.
+func bearerGet(url, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doAPIRequest(req)
+}
+
+// Sends a cloud API request, failing on error statuses. The caller closes
+// the body.
+func doAPIRequest(req *http.Request) (*http.Response, error) {
+	resp, err := apiClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// Writes a downloaded file, removing it if the download fails part way.
+func saveDownload(r io.Reader, filename string) error {
+	w, err := os.Create(longPath(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(filename)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(filename)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	splitGap = flag.Duration("split_gap", 4*time.Hour, "Split tracks into separate outings at recording gaps longer than this (0 disables)")
+)
+
+// Splits a track into separate outings wherever consecutive points are more
+// than gap apart. Some devices append each day's recording to the same track,
+// and each outing needs its own date and peak. Nights at camp, see
+// -stitch_gap, don't split.
+func SplitTrack(t gpx.GPXTrack, gap time.Duration) []gpx.GPXTrack {
+	if gap <= 0 {
+		return []gpx.GPXTrack{t}
+	}
+
+	var tracks []gpx.GPXTrack
+	cur := t
+	cur.Segments = nil
+	var last *gpx.GPXPoint
+
+	for _, segment := range t.Segments {
+		seg := segment
+		seg.Points = nil
+		for i := range segment.Points {
+			p := &segment.Points[i]
+			if last != nil && !last.Timestamp.IsZero() && p.Timestamp.Sub(last.Timestamp) > gap && !campGap(last, p) {
+				if len(seg.Points) > 0 {
+					cur.Segments = append(cur.Segments, seg)
+				}
+				tracks = append(tracks, cur)
+				cur = t
+				cur.Segments = nil
+				seg.Points = nil
+			}
+			seg.Points = append(seg.Points, *p)
+			last = p
+		}
+		if len(seg.Points) > 0 {
+			cur.Segments = append(cur.Segments, seg)
+		}
+	}
+	tracks = append(tracks, cur)
+
+	if len(tracks) > 1 {
+		log.Infof("Split track %q into %d outings at gaps over %v", t.Name, len(tracks), gap)
+		for i := range tracks {
+			tracks[i].Name = fmt.Sprintf("%s (part %d)", t.Name, i+1)
+		}
+	}
+	return tracks
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	skiMode = flag.Bool("ski", false, "Treat all tracks as ski tours and compute descent stats (otherwise detected from the track type or name)")
+
+	// Minimum horizontal distance in meters over which slope is measured,
+	// to avoid GPS jitter producing absurd angles.
+	slopeWindow = flag.Float64("slope_window", 30, "Horizontal distance in meters over which ski slope angles are measured")
+)
+
+const (
+	metersPerFoot = 0.3048
+	metersPerMile = 1609.344
+)
+
+// Flattens all points of a track in order.
+func trackPoints(t *gpx.GPXTrack) []gpx.GPXPoint {
+	var points []gpx.GPXPoint
+	for _, segment := range t.Segments {
+		points = append(points, segment.Points...)
+	}
+	return points
+}
+
+// Whether any point of the track has a time.
+func hasTimestamps(t *gpx.GPXTrack) bool {
+	for _, segment := range t.Segments {
+		for _, p := range segment.Points {
+			if !p.Timestamp.IsZero() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reduces points to at most max by dropping points closer than the average
+// spacing, always keeping the highest point so the summit is preserved.
+func reducePoints(points []gpx.GPXPoint, max int) []gpx.GPXPoint {
+	if len(points) <= max || max < 3 {
+		return points
+	}
+
+	highest := 0
+	length := 0.0
+	for i := range points {
+		if points[i].Elevation.Value() > points[highest].Elevation.Value() {
+			highest = i
+		}
+		if i > 0 {
+			length += pointDistance(&points[i-1], &points[i])
+		}
+	}
+	if length == 0 {
+		// Recorded standing still, no spacing thins it out.
+		reduced := []gpx.GPXPoint{points[0]}
+		if highest != 0 {
+			reduced = append(reduced, points[highest])
+		}
+		if highest != len(points)-1 {
+			reduced = append(reduced, points[len(points)-1])
+		}
+		return reduced
+	}
+
+	for spacing := length / float64(max-1); ; spacing *= 1.1 {
+		reduced := []gpx.GPXPoint{points[0]}
+		for i := 1; i < len(points); i++ {
+			prev := &reduced[len(reduced)-1]
+			d := pointDistance(prev, &points[i])
+			if d >= spacing || i == highest || i == len(points)-1 {
+				reduced = append(reduced, points[i])
+			}
+		}
+		if len(reduced) <= max {
+			return reduced
+		}
+	}
+}
+
+// Returns the index of the given point, matched by timestamp and position.
+func pointIndex(points []gpx.GPXPoint, p *gpx.GPXPoint) int {
+	for i := range points {
+		if points[i].Timestamp.Equal(p.Timestamp) && points[i].Latitude == p.Latitude && points[i].Longitude == p.Longitude {
+			return i
+		}
+	}
+	return -1
+}
+
+// Stats describing the descent of a ski tour.
+type SkiStats struct {
+	// Vertical from the summit to the lowest point of the descent, in meters.
+	DescentVertical float64
+	DescentDuration time.Duration
+	// Steepest descending slope in degrees.
+	MaxSlope float64
+}
+
+// Whether a track should be treated as a ski tour.
+func IsSkiTrack(src *Source, t *gpx.GPXTrack) bool {
+	if *skiMode {
+		return true
+	}
+	for _, s := range []string{t.Type, t.Name, src.ActivityName} {
+		words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+		for _, w := range words {
+			// Matches e.g. "ski", "skiing", "skimo" and Strava's "BackcountrySki".
+			if w == "skiing" || w == "skimo" || strings.HasSuffix(w, "ski") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DEM samples along a ski descent, spaced closer than -slope_window on
+// typical descents.
+const demSlopeSamples = 1000
+
+// Computes descent stats from the summit to the lowest subsequent point.
+// Slopes use DEM elevations when an -elevation_provider is set, since
+// recorded elevations along the track are too noisy over short distances. If
+// the DEM fails the stats use recorded elevations and the error is returned
+// alongside them.
+func ComputeSkiStats(t *gpx.GPXTrack, summit *gpx.GPXPoint) (*SkiStats, error) {
+	points := trackPoints(t)
+	start := pointIndex(points, summit)
+	if start < 0 {
+		return nil, nil
+	}
+
+	lowest := start
+	for i := start; i < len(points); i++ {
+		if points[i].Elevation.NotNull() && points[i].Elevation.Value() < points[lowest].Elevation.Value() {
+			lowest = i
+		}
+	}
+	if lowest == start {
+		return nil, nil
+	}
+
+	stats := &SkiStats{
+		DescentVertical: summit.Elevation.Value() - points[lowest].Elevation.Value(),
+		DescentDuration: points[lowest].Timestamp.Sub(summit.Timestamp),
+	}
+
+	descent := points[start : lowest+1]
+	var demErr error
+	if *elevationProviderName != "none" {
+		// Thinned like for gain, providers may be queried point by point.
+		dem, err := demElevations(reducePoints(descent, demSlopeSamples))
+		if err != nil {
+			demErr = fmt.Errorf("DEM slopes, using recorded elevations: %w", err)
+		} else {
+			descent = dem
+		}
+	}
+
+	for i := 0; i < len(descent)-1; i++ {
+		if descent[i].Elevation.Null() {
+			continue
+		}
+		for j := i + 1; j < len(descent); j++ {
+			if descent[j].Elevation.Null() {
+				continue
+			}
+			d := pointDistance(&descent[i], &descent[j])
+			if d < *slopeWindow {
+				continue
+			}
+			drop := descent[i].Elevation.Value() - descent[j].Elevation.Value()
+			if slope := math.Atan2(drop, d) * 180 / math.Pi; slope > stats.MaxSlope {
+				stats.MaxSlope = slope
+			}
+			break
+		}
+	}
+
+	return stats, demErr
+}
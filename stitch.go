@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	stitchGap  = flag.Duration("stitch_gap", 0, "Treat recording gaps up to this long that resume where they stopped as a night at camp: files are stitched into one outing and tracks aren't split there at -split_gap, e.g. 12h for an approach bivy before an alpine start (0 disables)")
+	campRadius = flag.Float64("camp_radius", 200, "Distance in meters within which a track must resume after a gap for the gap to count as a camp, see -stitch_gap")
+)
+
+// Whether the gap between consecutive points is a stop at camp: no longer
+// than -stitch_gap and resuming within -camp_radius.
+func campGap(a, b *gpx.GPXPoint) bool {
+	if *stitchGap <= 0 || a.Timestamp.IsZero() || b.Timestamp.IsZero() {
+		return false
+	}
+	gap := b.Timestamp.Sub(a.Timestamp)
+	return gap >= 0 && gap <= *stitchGap && pointDistance(a, b) <= *campRadius
+}
+
+// First and last points of a file.
+type fileEnds struct {
+	fi          os.FileInfo
+	first, last gpx.GPXPoint
+}
+
+func loadFileEnds(dir string, fi os.FileInfo) (*fileEnds, error) {
+	g, err := LoadGPX(filepath.Join(dir, fi.Name()))
+	if err != nil {
+		return nil, err
+	}
+	ends := &fileEnds{fi: fi}
+	found := false
+	for _, t := range g.Tracks {
+		for _, s := range t.Segments {
+			for _, p := range s.Points {
+				if p.Timestamp.IsZero() {
+					continue
+				}
+				if !found || p.Timestamp.Before(ends.first.Timestamp) {
+					ends.first = p
+				}
+				if !found || p.Timestamp.After(ends.last.Timestamp) {
+					ends.last = p
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no timestamps")
+	}
+	return ends, nil
+}
+
+// Groups files whose track resumes from a camp where the previous file's
+// track stopped, e.g. an approach to a bivy and the summit day after it.
+// Groups are in time order within and in the order of their first file in
+// files otherwise. Without -stitch_gap every file is its own group.
+//
+// Files the history says are done aren't read or stitched, so a new file
+// doesn't upload an outing again with one already processed.
+func (u *Uploader) StitchInputFiles(files []os.FileInfo) [][]os.FileInfo {
+	var groups [][]os.FileInfo
+	if *stitchGap <= 0 {
+		for _, fi := range files {
+			groups = append(groups, []os.FileInfo{fi})
+		}
+		return groups
+	}
+
+	var ends []*fileEnds
+	for _, fi := range files {
+		if u.processed(fi.Name()) {
+			continue
+		}
+		e, err := loadFileEnds(u.dir, fi)
+		if err != nil {
+			// Processed alone, reporting the error then.
+			log.Debugf("Not stitching %q: %v", fi.Name(), err)
+			continue
+		}
+		ends = append(ends, e)
+	}
+	sort.SliceStable(ends, func(i, j int) bool {
+		return ends[i].first.Timestamp.Before(ends[j].first.Timestamp)
+	})
+
+	// Files map to the group they were stitched into.
+	joined := make(map[string][]os.FileInfo)
+	leads := make(map[string]bool)
+	for i := 0; i < len(ends); {
+		group := []os.FileInfo{ends[i].fi}
+		j := i + 1
+		for ; j < len(ends) && campGap(&ends[j-1].last, &ends[j].first); j++ {
+			group = append(group, ends[j].fi)
+		}
+		if len(group) > 1 {
+			log.Infof("Stitching %s into one outing, resuming from camp after %v", stitchedName(group), ends[i+1].first.Timestamp.Sub(ends[i].last.Timestamp).Round(time.Minute))
+			leads[group[0].Name()] = true
+			for _, fi := range group {
+				joined[fi.Name()] = group
+			}
+		}
+		i = j
+	}
+
+	for _, fi := range files {
+		group, ok := joined[fi.Name()]
+		switch {
+		case !ok:
+			groups = append(groups, []os.FileInfo{fi})
+		case leads[group[0].Name()]:
+			groups = append(groups, group)
+			delete(leads, group[0].Name())
+		}
+	}
+	return groups
+}
+
+// Names a stitched outing in reports and the history.
+func stitchedName(group []os.FileInfo) string {
+	var names []string
+	for _, fi := range group {
+		names = append(names, fi.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+// Uploads files stitched into one outing as a single track. Each file is
+// recorded in the history with the outcome. StitchInputFiles leaves out
+// files already processed, so none of the group has been uploaded.
+func (u *Uploader) processStitched(group []os.FileInfo) error {
+	name := stitchedName(group)
+	delete(u.FilenameHistory, name)
+
+	err := u.processInput(name, nil, func() error {
+		filename, err := stitchFiles(u.dir, group)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(filename))
+		return u.uploadRecovering(filename, name)
+	})
+	if err != nil {
+		return err
+	}
+	h, ok := u.FilenameHistory[name]
+	if !ok {
+		// Awaiting approval.
+		return nil
+	}
+	for _, fi := range group {
+		u.FilenameHistory[fi.Name()] = h
+	}
+	return u.SaveHistory()
+}
+
+// Joins the tracks of files, in order, into one track of a temporary GPX
+// file. The first file's metadata is kept.
+func stitchFiles(dir string, group []os.FileInfo) (string, error) {
+	var stitched *gpx.GPX
+	var track gpx.GPXTrack
+	for _, fi := range group {
+		g, err := LoadGPX(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return "", fmt.Errorf("%s %w", fi.Name(), err)
+		}
+		if stitched == nil {
+			stitched = g
+			if len(g.Tracks) > 0 {
+				track = g.Tracks[0]
+				track.Segments = nil
+			}
+		}
+		for _, t := range g.Tracks {
+			track.Segments = append(track.Segments, t.Segments...)
+		}
+	}
+	stitched.Tracks = []gpx.GPXTrack{track}
+	return writeTempGPX(stitched, "stitched.gpx")
+}
+
+// Writes a GPX file under name in a new temporary directory, which the
+// caller removes.
+func writeTempGPX(g *gpx.GPX, name string) (string, error) {
+	dir, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	b, err := g.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(longPath(filename), b, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	stravaExport = flag.String("strava_export", "", "Upload from a Strava account export, the zip or its extracted folder, using activities.csv to pick activities. History is kept in -directory, by default next to the export")
+	stravaTypes  = flag.String("strava_types", "Hike,Run,Walk,Alpine Ski,Backcountry Ski,Snowshoe", "Comma separated Strava activity types to upload from -strava_export or -source=strava, spaces are ignored")
+)
+
+// An activity listed in a Strava export's activities.csv.
+type StravaActivity struct {
+	ID   string
+	Name string
+	Type string
+	Date time.Time
+	// Path of the track within the export, e.g. activities/123.gpx.gz. Empty
+	// for manual activities.
+	Filename string
+}
+
+func (a *StravaActivity) apply(src *Source) {
+	src.Type = "strava"
+	src.ActivityName = a.Name
+	src.URL = "https://www.strava.com/activities/" + a.ID
+}
+
+// Dates in activities.csv, in UTC.
+const stravaDateLayout = "Jan 2, 2006, 3:04:05 PM"
+
+// Reads activities.csv. Columns are found by header since Strava adds
+// columns over time, and some names repeat so the first is used.
+func parseStravaActivities(r io.Reader) ([]*StravaActivity, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read activities.csv %w", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if _, ok := col[h]; !ok {
+			col[h] = i
+		}
+	}
+	for _, h := range []string{"Activity ID", "Activity Date", "Activity Name", "Activity Type", "Filename"} {
+		if _, ok := col[h]; !ok {
+			return nil, fmt.Errorf("activities.csv has no %q column", h)
+		}
+	}
+	field := func(rec []string, h string) string {
+		if i := col[h]; i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var activities []*StravaActivity
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read activities.csv %w", err)
+		}
+		a := &StravaActivity{
+			ID:       field(rec, "Activity ID"),
+			Name:     field(rec, "Activity Name"),
+			Type:     field(rec, "Activity Type"),
+			Filename: field(rec, "Filename"),
+		}
+		if d, err := time.Parse(stravaDateLayout, field(rec, "Activity Date")); err == nil {
+			a.Date = d
+		}
+		activities = append(activities, a)
+	}
+	return activities, nil
+}
+
+// Whether an activity has a track of a type in -strava_types, within -after
+// and -before.
+func stravaActivitySelected(a *StravaActivity) bool {
+	if a.Filename == "" || !trackFileSupported(a.Filename) {
+		return false
+	}
+	return inDateRange(a.Date) && stravaTypeSelected(a.Type)
+}
+
+// Whether an activity type is in -strava_types. Exports name types like
+// "Backcountry Ski" and the API like "BackcountrySki", so spaces are ignored.
+func stravaTypeSelected(activityType string) bool {
+	activityType = strings.ReplaceAll(activityType, " ", "")
+	for _, t := range strings.Split(*stravaTypes, ",") {
+		if strings.EqualFold(strings.ReplaceAll(t, " ", ""), activityType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Uploads the selected activities of -strava_export. Each is recorded in the
+// history as "<export>/activities/<file>", the same as when the export zip is
+// processed as an archive, so activities aren't uploaded twice either way.
+func (u *Uploader) RunStravaExport() error {
+	if u.dir == "" {
+		if fi, err := os.Stat(longPath(*stravaExport)); err == nil && fi.IsDir() {
+			u.dir = *stravaExport
+		} else {
+			u.dir = filepath.Dir(*stravaExport)
+		}
+	}
+	if err := u.LoadHistory(); err != nil {
+		return err
+	}
+
+	// Exports are read the same whether zipped or extracted: open reads a
+	// path within the export, extract returns a local file for it.
+	var open func(name string) (io.ReadCloser, error)
+	var extract func(name, dir string) (string, error)
+	if strings.EqualFold(filepath.Ext(*stravaExport), ".zip") {
+		zr, err := zip.OpenReader(longPath(*stravaExport))
+		if err != nil {
+			return fmt.Errorf("open strava export %w", err)
+		}
+		defer zr.Close()
+		entries := make(map[string]*zip.File)
+		for _, f := range zr.File {
+			entries[f.Name] = f
+		}
+		open = func(name string) (io.ReadCloser, error) {
+			f, ok := entries[name]
+			if !ok {
+				return nil, fmt.Errorf("%s not in export: %w", name, os.ErrNotExist)
+			}
+			return f.Open()
+		}
+		extract = func(name, dir string) (string, error) {
+			f, ok := entries[name]
+			if !ok {
+				return "", fmt.Errorf("%s not in export: %w", name, os.ErrNotExist)
+			}
+			return extractArchiveEntry(f, dir)
+		}
+	} else {
+		open = func(name string) (io.ReadCloser, error) {
+			return os.Open(longPath(filepath.Join(*stravaExport, filepath.FromSlash(name))))
+		}
+		extract = func(name, dir string) (string, error) {
+			return filepath.Join(*stravaExport, filepath.FromSlash(name)), nil
+		}
+	}
+
+	r, err := open("activities.csv")
+	if err != nil {
+		return fmt.Errorf("strava export %w", err)
+	}
+	activities, err := parseStravaActivities(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir("", "peakbagger-bulk-uploader.*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	u.activities = make(map[string]activityMetadata)
+	selected := 0
+	for _, a := range activities {
+		if !stravaActivitySelected(a) {
+			continue
+		}
+		selected++
+		a := a
+		name := path.Join(filepath.Base(*stravaExport), a.Filename)
+		u.activities[name] = a
+		err := u.processInput(name, nil, func() error {
+			filename, err := extract(a.Filename, tmp)
+			if err != nil {
+				return err
+			}
+			if filepath.Dir(filename) == tmp {
+				defer os.Remove(filename)
+			}
+			return u.uploadRecovering(filename, name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Processed %d of %d activities in the Strava export", selected, len(activities))
+	return nil
+}
+
+var stravaAPIURL = "https://www.strava.com/api/v3"
+
+// Syncs activities with the Strava API, see -source.
+type stravaSource struct {
+	token string
+}
+
+func newStravaSource() (ActivitySource, error) {
+	token, err := OAuthAccessToken("strava")
+	if err != nil {
+		return nil, err
+	}
+	return &stravaSource{token: token}, nil
+}
+
+// Strava pages at most 200 activities per request.
+func (s *stravaSource) Activities(limit int) ([]*RemoteActivity, error) {
+	var activities []*RemoteActivity
+	for page := 1; len(activities) < limit; page++ {
+		n := limit - len(activities)
+		if n > 200 {
+			n = 200
+		}
+		resp, err := bearerGet(fmt.Sprintf("%s/athlete/activities?page=%d&per_page=%d", stravaAPIURL, page, n), s.token)
+		if err != nil {
+			return nil, err
+		}
+		var list []struct {
+			ID        int64     `json:"id"`
+			Name      string    `json:"name"`
+			SportType string    `json:"sport_type"`
+			Type      string    `json:"type"`
+			StartDate time.Time `json:"start_date"`
+			Manual    bool      `json:"manual"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse activity list %w", err)
+		}
+		for _, l := range list {
+			if l.Manual {
+				continue
+			}
+			t := l.SportType
+			if t == "" {
+				t = l.Type
+			}
+			id := fmt.Sprint(l.ID)
+			activities = append(activities, &RemoteActivity{
+				Source: "strava",
+				ID:     id,
+				Name:   l.Name,
+				Type:   t,
+				Start:  l.StartDate,
+				URL:    "https://www.strava.com/activities/" + id,
+			})
+		}
+		if len(list) < n {
+			break
+		}
+	}
+	return activities, nil
+}
+
+func (s *stravaSource) Selected(a *RemoteActivity) bool {
+	return stravaTypeSelected(a.Type)
+}
+
+// Downloads the activity's streams and writes them as a GPX track. The API
+// doesn't serve original files, and streams keep every recorded point.
+func (s *stravaSource) Download(a *RemoteActivity, dir string) (string, error) {
+	resp, err := bearerGet(fmt.Sprintf("%s/activities/%s/streams?keys=latlng,altitude,time&key_by_type=true", stravaAPIURL, a.ID), s.token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var streams struct {
+		LatLng struct {
+			Data [][2]float64 `json:"data"`
+		} `json:"latlng"`
+		Altitude struct {
+			Data []float64 `json:"data"`
+		} `json:"altitude"`
+		// Seconds since the start.
+		Time struct {
+			Data []int64 `json:"data"`
+		} `json:"time"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&streams); err != nil {
+		return "", fmt.Errorf("parse streams of activity %s %w", a.ID, err)
+	}
+	latlng, times := streams.LatLng.Data, streams.Time.Data
+	if len(latlng) == 0 || len(times) != len(latlng) {
+		return "", fmt.Errorf("activity %s has no GPS track", a.ID)
+	}
+
+	seg := gpx.GPXTrackSegment{}
+	for i, ll := range latlng {
+		p := gpx.GPXPoint{Point: gpx.Point{Latitude: ll[0], Longitude: ll[1]}, Timestamp: a.Start.Add(time.Duration(times[i]) * time.Second)}
+		if i < len(streams.Altitude.Data) {
+			p.Elevation = *gpx.NewNullableFloat64(streams.Altitude.Data[i])
+		}
+		seg.Points = append(seg.Points, p)
+	}
+	g := &gpx.GPX{Creator: "Strava", Tracks: []gpx.GPXTrack{{Name: a.Name, Segments: []gpx.GPXTrackSegment{seg}}}}
+	b, err := g.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, a.ID+".gpx")
+	if err := os.WriteFile(longPath(filename), b, 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+var (
+	summitTiebreak   = flag.String("summit_tiebreak", "midpoint", "How to choose the summit moment among points on a flat summit: first, midpoint or closest (to the matched peak)")
+	plateauTolerance = flag.Float64("plateau_tolerance", 1, "Points within this many meters of the highest elevation are considered part of the summit plateau")
+	summitRadius     = flag.Float64("summit_radius", 50, "Distance in meters from the summit within which time counts as time on the summit")
+	summitCandidates = flag.Int("summit_candidates", 3, "Number of candidate high points shown by analyze and offered in -interactive mode")
+)
+
+// Points further than this from the highest point aren't part of its
+// plateau, so a second pass over a similar elevation elsewhere is ignored.
+const plateauRadius = 100
+
+// Returns the indices of points on the summit plateau around the highest point.
+func summitPlateau(points []gpx.GPXPoint, highest *gpx.GPXPoint) []int {
+	var plateau []int
+	for i := range points {
+		p := &points[i]
+		if p.Elevation.Null() || highest.Elevation.Value()-p.Elevation.Value() > *plateauTolerance {
+			continue
+		}
+		if pointDistance(p, highest) > plateauRadius {
+			continue
+		}
+		plateau = append(plateau, i)
+	}
+	return plateau
+}
+
+// Chooses the canonical summit point among points on the summit plateau,
+// since on flat summits the first maximum is arbitrary and skews up/down times.
+func ResolveSummit(points []gpx.GPXPoint, highest *gpx.GPXPoint, peakLat, peakLng float64) (*gpx.GPXPoint, error) {
+	plateau := summitPlateau(points, highest)
+	if len(plateau) <= 1 {
+		return highest, nil
+	}
+
+	best := plateau[0]
+	switch *summitTiebreak {
+	case "first":
+		return highest, nil
+	case "midpoint":
+		first, last := points[plateau[0]].Timestamp, points[plateau[len(plateau)-1]].Timestamp
+		mid := first.Add(last.Sub(first) / 2)
+		bestDiff := time.Duration(1<<63 - 1)
+		for _, i := range plateau {
+			diff := points[i].Timestamp.Sub(mid)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff < bestDiff {
+				best, bestDiff = i, diff
+			}
+		}
+	case "closest":
+		bestDist := -1.0
+		for _, i := range plateau {
+			d := Distance(points[i].Latitude, points[i].Longitude, peakLat, peakLng)
+			if bestDist < 0 || d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown summit tiebreak %q", *summitTiebreak)
+	}
+
+	summit := points[best]
+	return &summit, nil
+}
+
+// High points closer than this in time and within plateauRadius are the same
+// candidate.
+const candidateSeparation = 10 * time.Minute
+
+// Returns up to n distinct high points, highest first. With noisy elevations
+// the single maximum may be a spike rather than the real summit moment.
+func HighPointCandidates(points []gpx.GPXPoint, n int) []*gpx.GPXPoint {
+	// Only local maxima are candidates, otherwise the points just below the
+	// highest on the way up crowd out other high points.
+	var order []int
+	for i := range points {
+		if points[i].Elevation.NotNull() && localMaximum(points, i) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return points[order[a]].Elevation.Value() > points[order[b]].Elevation.Value()
+	})
+
+	var candidates []*gpx.GPXPoint
+	for _, i := range order {
+		if len(candidates) >= n {
+			break
+		}
+		p := &points[i]
+		distinct := true
+		for _, c := range candidates {
+			dt := p.Timestamp.Sub(c.Timestamp)
+			if dt < 0 {
+				dt = -dt
+			}
+			if dt < candidateSeparation && pointDistance(p, c) <= plateauRadius {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates
+}
+
+// Whether no point within candidateSeparation of point i is higher.
+func localMaximum(points []gpx.GPXPoint, i int) bool {
+	e, t := points[i].Elevation.Value(), points[i].Timestamp
+	for j := i - 1; j >= 0 && t.Sub(points[j].Timestamp) < candidateSeparation; j-- {
+		if points[j].Elevation.Value() > e {
+			return false
+		}
+	}
+	for j := i + 1; j < len(points) && points[j].Timestamp.Sub(t) < candidateSeparation; j++ {
+		if points[j].Elevation.Value() > e {
+			return false
+		}
+	}
+	return true
+}
+
+func formatCandidate(p *gpx.GPXPoint) string {
+	return fmt.Sprintf("%.0f ft at %s (%.5f, %.5f)", p.Elevation.Value()/metersPerFoot,
+		p.Timestamp.Local().Format("15:04:05"), p.Latitude, p.Longitude)
+}
+
+// Returns how long the track stays within -summit_radius of the summit,
+// counting the continuous stretch around the summit point so a later pass
+// nearby doesn't count.
+func TimeOnSummit(points []gpx.GPXPoint, summit *gpx.GPXPoint) time.Duration {
+	i := pointIndex(points, summit)
+	if i < 0 {
+		return 0
+	}
+	first, last := i, i
+	for first > 0 && pointDistance(&points[first-1], summit) <= *summitRadius {
+		first--
+	}
+	for last < len(points)-1 && pointDistance(&points[last+1], summit) <= *summitRadius {
+		last++
+	}
+	return points[last].Timestamp.Sub(points[first].Timestamp)
+}
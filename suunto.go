@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	suuntoSubscriptionKey = flag.String("suunto_subscription_key", "", "Suunto API Zone subscription key, sent with every -source=suunto request")
+	suuntoActivities      = flag.String("suunto_activities", "0,1,11,22", "Comma separated Suunto activity IDs to upload with -source=suunto (default walking, running, hiking and trail running)")
+)
+
+var suuntoAPIURL = "https://cloudapi.suunto.com"
+
+// Syncs workouts from the Suunto app cloud API, see -source.
+type suuntoSource struct {
+	token string
+}
+
+func newSuuntoSource() (ActivitySource, error) {
+	if *suuntoSubscriptionKey == "" {
+		return nil, fmt.Errorf("-source=suunto requires -suunto_subscription_key")
+	}
+	token, err := OAuthAccessToken("suunto")
+	if err != nil {
+		return nil, err
+	}
+	return &suuntoSource{token: token}, nil
+}
+
+// Requests the API with the access token and subscription key.
+func (s *suuntoSource) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Ocp-Apim-Subscription-Key", *suuntoSubscriptionKey)
+	return doAPIRequest(req)
+}
+
+func (s *suuntoSource) Activities(limit int) ([]*RemoteActivity, error) {
+	resp, err := s.get(fmt.Sprintf("%s/v2/workouts?limit=%d", suuntoAPIURL, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Error   *string `json:"error"`
+		Payload []struct {
+			WorkoutKey string `json:"workoutKey"`
+			ActivityID int    `json:"activityId"`
+			// Milliseconds since the epoch.
+			StartTime   int64  `json:"startTime"`
+			Description string `json:"description"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("parse workout list %w", err)
+	}
+	if r.Error != nil && *r.Error != "" {
+		return nil, fmt.Errorf("workout list: %s", *r.Error)
+	}
+	var activities []*RemoteActivity
+	for _, w := range r.Payload {
+		activities = append(activities, &RemoteActivity{
+			Source: "suunto",
+			ID:     w.WorkoutKey,
+			Name:   w.Description,
+			Type:   strconv.Itoa(w.ActivityID),
+			Start:  time.Unix(0, w.StartTime*int64(time.Millisecond)).UTC(),
+		})
+	}
+	return activities, nil
+}
+
+func (s *suuntoSource) Selected(a *RemoteActivity) bool {
+	for _, id := range strings.Split(*suuntoActivities, ",") {
+		if strings.TrimSpace(id) == a.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Downloads the workout as a FIT file.
+func (s *suuntoSource) Download(a *RemoteActivity, dir string) (string, error) {
+	resp, err := s.get(fmt.Sprintf("%s/v2/workout/exportFit/%s", suuntoAPIURL, a.ID))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	filename := filepath.Join(dir, a.ID+".fit")
+	if err := saveDownload(resp.Body, filename); err != nil {
+		return "", fmt.Errorf("download workout %s %w", a.ID, err)
+	}
+	return filename, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// The parts of a Garmin Training Center (TCX) file needed for tracks. Both
+// activities and courses hold their points in Track elements.
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Notes string `xml:"Notes"`
+		Laps  []struct {
+			Tracks []tcxTrack `xml:"Track"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+	Courses []struct {
+		Name   string     `xml:"Name"`
+		Tracks []tcxTrack `xml:"Track"`
+	} `xml:"Courses>Course"`
+}
+
+type tcxTrack struct {
+	Points []struct {
+		Time     string `xml:"Time"`
+		Position *struct {
+			Lat float64 `xml:"LatitudeDegrees"`
+			Lng float64 `xml:"LongitudeDegrees"`
+		} `xml:"Position"`
+		Altitude  *float64 `xml:"AltitudeMeters"`
+		HeartRate float64  `xml:"HeartRateBpm>Value"`
+	} `xml:"Trackpoint"`
+}
+
+// Decodes a TCX file into a GPX with a track per activity or course. Each
+// Track element, which devices start after a pause, becomes a segment.
+func ReadTCX(filename string) (*gpx.GPX, error) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tcx tcxFile
+	if err := xml.NewDecoder(f).Decode(&tcx); err != nil {
+		return nil, fmt.Errorf("decode tcx %w", err)
+	}
+
+	g := &gpx.GPX{Creator: "TCX"}
+	for _, a := range tcx.Activities {
+		t := gpx.GPXTrack{Name: a.Notes, Type: a.Sport}
+		for _, lap := range a.Laps {
+			t.Segments = append(t.Segments, tcxSegments(lap.Tracks)...)
+		}
+		if len(t.Segments) > 0 {
+			g.Tracks = append(g.Tracks, t)
+		}
+	}
+	for _, c := range tcx.Courses {
+		t := gpx.GPXTrack{Name: c.Name, Segments: tcxSegments(c.Tracks)}
+		if len(t.Segments) > 0 {
+			g.Tracks = append(g.Tracks, t)
+		}
+	}
+	if len(g.Tracks) == 0 {
+		return nil, fmt.Errorf("tcx file has no track points")
+	}
+	return g, nil
+}
+
+// Converts Track elements to segments, skipping points without a position
+// such as treadmill samples. Points without a time, as in courses, are kept
+// untimed like GPX points without one.
+func tcxSegments(tracks []tcxTrack) []gpx.GPXTrackSegment {
+	var segs []gpx.GPXTrackSegment
+	for _, tt := range tracks {
+		seg := gpx.GPXTrackSegment{}
+		for _, tp := range tt.Points {
+			if tp.Position == nil {
+				continue
+			}
+			p := gpx.GPXPoint{}
+			if ts, err := time.Parse(time.RFC3339, tp.Time); err == nil {
+				p.Timestamp = ts
+			}
+			p.Latitude = tp.Position.Lat
+			p.Longitude = tp.Position.Lng
+			if tp.Altitude != nil {
+				p.Elevation = *gpx.NewNullableFloat64(*tp.Altitude)
+			}
+			if tp.HeartRate > 0 {
+				setPointHeartRate(&p, tp.HeartRate)
+			}
+			seg.Points = append(seg.Points, p)
+		}
+		if len(seg.Points) > 0 {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
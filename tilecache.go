@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	cacheDir     = flag.String("cache_dir", defaultCacheDir(), "Directory for cached DEM tiles")
+	cacheMaxSize = flag.Int64("cache_max_size", 2048, "Maximum size of the tile cache in MB, least recently used tiles are evicted first")
+	cacheOffline = flag.Bool("cache_offline", false, "Only use tiles already in the cache, never download")
+)
+
+var errNotCached = errors.New("tile not cached and -cache_offline is set")
+
+// Kinds of tiles in the cache, each in its own folder. Only DEM tiles are
+// downloaded so far.
+const srtmTiles = "srtm"
+
+var cacheKinds = []string{srtmTiles}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "peakbagger-bulk-uploader")
+}
+
+// An on-disk cache of downloaded tiles of each of cacheKinds, kept under
+// -cache_max_size by evicting the least recently used tiles.
+type TileCache struct {
+	mu sync.Mutex
+}
+
+var tileCache TileCache
+
+func (c *TileCache) path(kind, name string) string {
+	return filepath.Join(*cacheDir, kind, filepath.FromSlash(name))
+}
+
+// Returns a tile of the given kind, downloading it from url on a miss.
+func (c *TileCache) Get(kind, name, url string) ([]byte, error) {
+	if *cacheDir == "" {
+		return nil, fmt.Errorf("no -cache_dir")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.path(kind, name)
+	if b, err := os.ReadFile(longPath(p)); err == nil {
+		// Modification time doubles as last use for eviction.
+		now := time.Now()
+		os.Chtimes(longPath(p), now, now)
+		return b, nil
+	}
+	if *cacheOffline {
+		return nil, fmt.Errorf("%w: %s/%s", errNotCached, kind, name)
+	}
+
+	log.Infof("Downloading %s tile %s", kind, name)
+	resp, err := apiClient().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(longPath(filepath.Dir(p)), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(longPath(p), b, 0644); err != nil {
+		return nil, err
+	}
+	if err := c.evict(); err != nil {
+		log.Warnf("Failed to evict cached tiles: %v", err)
+	}
+	return b, nil
+}
+
+type cachedTile struct {
+	path string
+	info fs.FileInfo
+}
+
+func (c *TileCache) list(kind string) ([]cachedTile, error) {
+	var tiles []cachedTile
+	root := filepath.Join(*cacheDir, kind)
+	err := filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			tiles = append(tiles, cachedTile{path, info})
+		}
+		return nil
+	})
+	return tiles, err
+}
+
+// Deletes the least recently used tiles until the cache fits -cache_max_size.
+func (c *TileCache) evict() error {
+	tiles, err := c.list("")
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, t := range tiles {
+		size += t.info.Size()
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].info.ModTime().Before(tiles[j].info.ModTime())
+	})
+	limit := *cacheMaxSize << 20
+	for _, t := range tiles {
+		if size <= limit {
+			break
+		}
+		log.Debugf("Evicting cached tile %s", t.path)
+		if err := os.Remove(longPath(t.path)); err != nil {
+			return err
+		}
+		size -= t.info.Size()
+	}
+	return nil
+}
+
+// Runs the cache subcommand: "cache" summarizes the cache by kind and
+// "cache clear [kind]" deletes all tiles or those of one kind.
+func RunCacheCommand(args []string) error {
+	if *cacheDir == "" {
+		return fmt.Errorf("no -cache_dir")
+	}
+	if len(args) == 0 || args[0] == "list" {
+		tiles, err := tileCache.list("")
+		if err != nil {
+			return err
+		}
+		counts := make(map[string]int)
+		sizes := make(map[string]int64)
+		var total int64
+		for _, t := range tiles {
+			rel, _ := filepath.Rel(*cacheDir, t.path)
+			kind := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+			counts[kind]++
+			sizes[kind] += t.info.Size()
+			total += t.info.Size()
+		}
+		var kinds []string
+		for k := range counts {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		fmt.Printf("%s (%.1f of %d MB)\n", *cacheDir, float64(total)/(1<<20), *cacheMaxSize)
+		for _, k := range kinds {
+			fmt.Printf("  %s: %d tiles, %.1f MB\n", k, counts[k], float64(sizes[k])/(1<<20))
+		}
+		return nil
+	}
+	if args[0] == "clear" {
+		if len(args) == 1 {
+			// Only the cache's own folders, -cache_dir may be shared.
+			for _, kind := range cacheKinds {
+				if err := clearCacheKind(kind); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, kind := range cacheKinds {
+			if args[1] == kind {
+				return clearCacheKind(kind)
+			}
+		}
+		return fmt.Errorf("unknown cache kind %q, want one of %s", args[1], strings.Join(cacheKinds, ", "))
+	}
+	return fmt.Errorf("unknown cache command %q", args[0])
+}
+
+func clearCacheKind(kind string) error {
+	dir := filepath.Join(*cacheDir, kind)
+	log.Infof("Clearing %s", dir)
+	return os.RemoveAll(longPath(dir))
+}
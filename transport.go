@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	debugHTTP  = flag.Bool("debug_http", false, "Log full HTTP requests and responses, with secrets redacted")
+	uploadKbps = flag.Int("upload_kbps", 0, "Limit request uploads such as GPX files to this many kilobits per second, for slow or metered connections (0 for no limit)")
+	apiTimeout = flag.Duration("api_timeout", 2*time.Minute, "Timeout of each request to services other than Peakbagger, such as activity sources, OAuth, Google Sheets, map tiles and elevation lookups, including reading the response")
+)
+
+// The peakbagger client uses the default transport, so behaviour for all
+// Peakbagger traffic is layered onto http.DefaultTransport.
+func wrapDefaultTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	http.DefaultTransport = wrap(http.DefaultTransport)
+}
+
+// Client for services other than Peakbagger, so a stalled service fails the
+// request instead of hanging the run or daemon. It uses the default
+// transport, keeping -debug_http and offline modes.
+func apiClient() *http.Client {
+	return &http.Client{Timeout: *apiTimeout}
+}
+
+// Whether a request is to Peakbagger, as opposed to other services.
+func isPeakbagger(req *http.Request) bool {
+	host := strings.ToLower(req.URL.Hostname())
+	return host == "peakbagger.com" || strings.HasSuffix(host, ".peakbagger.com")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Dumps every request and response to the log when -debug_http is set.
+// Dumps go through the log formatter, which redacts secrets.
+func InstallHTTPDebug() {
+	if !*debugHTTP {
+		return
+	}
+	wrapDefaultTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if b, err := httputil.DumpRequestOut(req, true); err == nil {
+				log.Infof("HTTP request:\n%s", b)
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				log.Infof("HTTP error: %v", err)
+				return nil, err
+			}
+			if b, err := httputil.DumpResponse(resp, true); err == nil {
+				log.Infof("HTTP response:\n%s", b)
+			}
+			return resp, nil
+		})
+	})
+}
+
+// Limits the rate request bodies are sent at when -upload_kbps is set.
+func InstallUploadThrottle() {
+	if *uploadKbps <= 0 {
+		return
+	}
+	bytesPerSecond := float64(*uploadKbps) * 1000 / 8
+	wrapDefaultTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil && req.Body != http.NoBody {
+				req = req.Clone(req.Context())
+				req.Body = &throttledReader{r: req.Body, rate: bytesPerSecond, start: time.Now()}
+			}
+			return next.RoundTrip(req)
+		})
+	})
+}
+
+// Sleeps between reads to keep the average rate at or under rate bytes per
+// second.
+type throttledReader struct {
+	r     io.ReadCloser
+	rate  float64
+	start time.Time
+	n     int
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the rate smooth rather than bursting a buffer at a time.
+	if max := int(t.rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	t.n += n
+	if wait := time.Duration(float64(t.n)/t.rate*float64(time.Second)) - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
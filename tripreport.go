@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	tripReportTemplate    = flag.String("trip_report_template", "", "Trip report template file (Go text/template), used when no per-source template matches")
+	tripReportTemplateDir = flag.String("trip_report_template_dir", "", "Directory of per-source trip report templates named <source>.tmpl (e.g. inreach.tmpl, kml.tmpl, default.tmpl)")
+	sourceTypeOverride    = flag.String("source_type", "", "Force the source type used for trip report template selection")
+
+	tripReportHeader = flag.String("trip_report_header", "", "Template file rendered at the top of every trip report")
+	tripReportFooter = flag.String("trip_report_footer", "", "Template file rendered at the bottom of every trip report")
+	attribution      = flag.Bool("attribution", true, "End trip reports with a line crediting this tool")
+	attributionText  = flag.String("attribution_text", defaultAttribution, "Template for the attribution line")
+	units            = flag.String("units", "imperial", "Units for the elev, dist, speed and rate trip report template functions: imperial or metric")
+
+	// Maps a lowercase substring of the GPX creator to a source type.
+	creatorToSourceType = []struct {
+		substr     string
+		sourceType string
+	}{
+		{"inreach", "inreach"},
+		{"strava", "strava"},
+		{"gaia", "gaia"},
+		{"caltopo", "caltopo"},
+		{"garmin", "garmin"},
+	}
+)
+
+const defaultTripReportTemplate = `{{if .PRNote}}[b]Personal record![/b] {{.Comparison}}
+
+{{end}}{{with .Source.Leg}}Peak {{.Number}} of {{.Count}} on this outing, {{dist .Distance}} from {{or .PreviousSummit "the start"}}{{if .PreviousSummit}}, dropping {{elev .Drop}} and regaining {{elev .Regain}}{{end}}.
+
+{{end}}{{if ge .OnSummit.Minutes 1.0}}Time on summit: {{.OnSummit}}.
+
+{{end}}{{if .Pace}}{{with .Movement}}{{dist .Distance}} in {{.Duration}}, ascent pace {{rate .AscentRate}}, descent {{speed .DescentSpeed}}.
+
+{{end}}{{end}}{{if .ShowHR}}{{with .HeartRate}}Heart rate avg {{printf "%.0f" .Avg}}, max {{printf "%.0f" .Max}} bpm, effort {{printf "%.0f" .Effort}}.
+
+{{end}}{{end}}{{if .ShowConditions}}{{with .Conditions}}Conditions: {{.}}.
+
+{{end}}{{end}}{{if .ShowDaylight}}{{with .Daylight}}Used {{.Used}} of {{.Available}} daylight{{if .StartedBeforeSunrise}}, started before sunrise{{end}}{{if .FinishedAfterSunset}}, finished after sunset{{end}}.
+
+{{end}}{{end}}{{with .Assisted}}{{if .DriveUp}}Drive-up or lift-served summit, {{elev .OwnGain}} of gain under own power.{{else}}{{elev .AssistedGain}} of the gain by vehicle or lift, {{elev .OwnGain}} under own power.{{end}}
+
+{{end}}{{if .FlownDown}}Flew down from launch.
+
+{{end}}{{with .Traverse}}Traverse: up from {{elev .StartElevation}}, down to {{elev .EndElevation}}.
+
+{{end}}{{with .Route}}{{if or .Matched .Variations}}{{if .Matched}}Route: {{.Route}}{{else}}Closest standard route: {{.Route}}{{end}}{{range .Variations}}, off route for {{dist .Length}} from {{elev .FromElevation}} to {{elev .ToElevation}}, up to {{offset .MaxOff}} away{{end}}.
+
+{{end}}{{end}}{{with .Ski}}Ski descent: {{elev .DescentVertical}} in {{.DescentDuration}}, max slope {{printf "%.0f" .MaxSlope}}°
+
+{{end}}`
+
+const defaultAttribution = `[i]Uploaded by [a href="https://github.com/jheidel/peakbagger-bulk-uploader"]peakbagger-bulk-uploader[/a] on {{rfc3339 .Uploaded}}[/i]`
+
+// Describes where a track came from.
+type Source struct {
+	// Type selects the trip report template, e.g. "inreach" or "gpx".
+	Type string
+	// Format is the gpsbabel input format of the original file.
+	Format   string
+	Filename string
+
+	// Metadata from the original file, if available.
+	Device       string
+	ActivityName string
+	URL          string
+
+	// Set when the track is one leg of a multi-peak track, see -multi_peak.
+	Leg *Leg
+}
+
+// Builds source metadata for an input file. Metadata is only available when
+// the original file is GPX since gpsbabel conversion discards the creator.
+func NewSource(filename string) *Source {
+	ext := strings.ToLower(filepath.Ext(filename))
+	src := &Source{
+		Format:   extToGPSBabelFormat[ext],
+		Filename: filepath.Base(filename),
+	}
+
+	if ext == ".gpx" {
+		readGPXMetadata(filename, src)
+	}
+
+	src.Type = src.Format
+	creator := strings.ToLower(src.Device)
+	for _, c := range creatorToSourceType {
+		if strings.Contains(creator, c.substr) {
+			src.Type = c.sourceType
+			break
+		}
+	}
+	if *sourceTypeOverride != "" {
+		src.Type = *sourceTypeOverride
+	}
+	return src
+}
+
+// Fills source metadata from the header of a GPX file, stopping at the first
+// track so large files aren't parsed twice.
+func readGPXMetadata(filename string, src *Source) {
+	f, err := os.Open(longPath(filename))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	d.CharsetReader = charset.NewReaderLabel
+	var inMetadata bool
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "gpx":
+				for _, a := range el.Attr {
+					if a.Name.Local == "creator" {
+						src.Device = a.Value
+					}
+				}
+			case "metadata":
+				inMetadata = true
+			case "name":
+				if inMetadata {
+					var name string
+					if d.DecodeElement(&name, &el) == nil {
+						src.ActivityName = name
+					}
+				}
+			case "link":
+				if inMetadata {
+					for _, a := range el.Attr {
+						if a.Name.Local == "href" {
+							src.URL = a.Value
+						}
+					}
+				}
+			case "trk", "rte", "wpt":
+				return
+			}
+		case xml.EndElement:
+			if el.Name.Local == "metadata" {
+				inMetadata = false
+			}
+		}
+	}
+}
+
+// Returns a copy of the source for one leg of a multi-peak track.
+func (s *Source) ForLeg(leg *Leg) *Source {
+	c := *s
+	c.Leg = leg
+	return &c
+}
+
+// Returns a copy of the source specialized for a single track.
+func (s *Source) ForTrack(t *gpx.GPXTrack) *Source {
+	c := *s
+	if t.Name != "" {
+		c.ActivityName = t.Name
+	}
+	return &c
+}
+
+// Fields available to trip report templates.
+type TripReportData struct {
+	Source   *Source
+	PeakName string
+	Date     time.Time
+	Uploaded time.Time
+
+	// Details from the peak's page, only set with -fetch_peak_info.
+	PeakInfo *PeakInfo
+
+	// Comparison against previous ascents of the peak, nil for a first ascent.
+	Comparison *Comparison
+	// Set when -pr_note is enabled and this ascent is a personal record.
+	PRNote bool
+
+	// Total elevation gain in meters, see -gain_algorithm.
+	Gain float64
+
+	// Descent stats, only set for ski tours.
+	Ski *SkiStats
+	// Only set for point-to-point tracks.
+	Traverse *Traverse
+	// Set for IGC logs trimmed at takeoff, see -igc_trim_flight.
+	FlownDown bool
+	// Set when part of the gain was by vehicle or lift, see -assisted.
+	Assisted *AssistedStats
+	// Time spent within -summit_radius of the summit.
+	OnSummit time.Duration
+
+	Movement *MovementStats
+	// Set when -trip_report_pace is enabled.
+	Pace bool
+
+	// Nil when the track has no heart rate.
+	HeartRate *HeartRateStats
+	// Set when -trip_report_hr is enabled.
+	ShowHR bool
+
+	Conditions *Conditions
+	// Set when -trip_report_conditions is enabled.
+	ShowConditions bool
+
+	Daylight *Daylight
+	// Set when -trip_report_daylight is enabled.
+	ShowDaylight bool
+
+	// Comparison against the closest route in -route_library, if any.
+	Route *RouteDeviation
+}
+
+var tripReportFuncs = template.FuncMap{
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339Nano)
+	},
+	"feet": func(meters float64) string {
+		return fmt.Sprintf("%.0f", meters/metersPerFoot)
+	},
+	"miles": func(meters float64) string {
+		return fmt.Sprintf("%.1f", meters/metersPerMile)
+	},
+	"mph": func(metersPerSecond float64) string {
+		return fmt.Sprintf("%.1f", metersPerSecond*3600/metersPerMile)
+	},
+
+	// Formatted with unit labels according to -units.
+	"elev":   formatElevation,
+	"offset": formatDistance,
+	"dist": func(meters float64) string {
+		if *units == "metric" {
+			return fmt.Sprintf("%.1f km", meters/1000)
+		}
+		return fmt.Sprintf("%.1f mi", meters/metersPerMile)
+	},
+	"speed": func(metersPerSecond float64) string {
+		if *units == "metric" {
+			return fmt.Sprintf("%.1f km/h", metersPerSecond*3.6)
+		}
+		return fmt.Sprintf("%.1f mph", metersPerSecond*3600/metersPerMile)
+	},
+	"rate": func(metersPerHour float64) string {
+		if *units == "metric" {
+			return fmt.Sprintf("%.0f m/hr", metersPerHour)
+		}
+		return fmt.Sprintf("%.0f ft/hr", metersPerHour/metersPerFoot)
+	},
+}
+
+// Formats an elevation or gain with its unit label according to -units.
+func formatElevation(meters float64) string {
+	if *units == "metric" {
+		return fmt.Sprintf("%.0f m", meters)
+	}
+	return fmt.Sprintf("%.0f ft", meters/metersPerFoot)
+}
+
+// Formats a horizontal distance in -units, in feet or meters when short.
+func formatDistance(meters float64) string {
+	switch {
+	case *units == "metric" && meters >= 1000:
+		return fmt.Sprintf("%.1f km", meters/1000)
+	case *units == "metric":
+		return fmt.Sprintf("%.0f m", meters)
+	case meters >= metersPerMile/4:
+		return fmt.Sprintf("%.1f mi", meters/metersPerMile)
+	}
+	return fmt.Sprintf("%.0f ft", meters/metersPerFoot)
+}
+
+// Selects the trip report template for a source. Per-source templates take
+// precedence over the -trip_report_template file, which takes precedence over
+// the built-in default.
+func loadTripReportTemplate(src *Source) (*template.Template, error) {
+	if *tripReportTemplateDir != "" {
+		for _, name := range []string{src.Type, src.Format, "default"} {
+			if name == "" {
+				continue
+			}
+			fn := filepath.Join(*tripReportTemplateDir, name+".tmpl")
+			b, err := os.ReadFile(fn)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return template.New(filepath.Base(fn)).Funcs(tripReportFuncs).Parse(string(b))
+		}
+	}
+	if *tripReportTemplate != "" {
+		return parseTemplateFile(*tripReportTemplate)
+	}
+	return template.New("default").Funcs(tripReportFuncs).Parse(defaultTripReportTemplate)
+}
+
+// Checks the trip report template files given by flag exist, so a typo
+// fails the run before any ascent is logged with the wrong report.
+func CheckTripReportFiles() error {
+	for _, f := range []struct{ flag, path string }{
+		{"-trip_report_template", *tripReportTemplate},
+		{"-trip_report_header", *tripReportHeader},
+		{"-trip_report_footer", *tripReportFooter},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return fmt.Errorf("%s %w", f.flag, err)
+		}
+	}
+	if *tripReportTemplateDir != "" {
+		if fi, err := os.Stat(*tripReportTemplateDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("-trip_report_template_dir %q is not a directory", *tripReportTemplateDir)
+		}
+	}
+	return nil
+}
+
+func parseTemplateFile(fn string) (*template.Template, error) {
+	return template.New(filepath.Base(fn)).Funcs(tripReportFuncs).ParseFiles(fn)
+}
+
+// Renders the trip report for an ascent: the optional header, the source's
+// template, the optional footer and the attribution line, separated by blank
+// lines.
+func RenderTripReport(data *TripReportData) (string, error) {
+	tmpl, err := loadTripReportTemplate(data.Source)
+	if err != nil {
+		return "", fmt.Errorf("load trip report template %w", err)
+	}
+	var templates []*template.Template
+	if *tripReportHeader != "" {
+		header, err := parseTemplateFile(*tripReportHeader)
+		if err != nil {
+			return "", fmt.Errorf("load trip report header %w", err)
+		}
+		templates = append(templates, header)
+	}
+	templates = append(templates, tmpl)
+	if *tripReportFooter != "" {
+		footer, err := parseTemplateFile(*tripReportFooter)
+		if err != nil {
+			return "", fmt.Errorf("load trip report footer %w", err)
+		}
+		templates = append(templates, footer)
+	}
+
+	if *attribution {
+		t, err := template.New("attribution").Funcs(tripReportFuncs).Parse(*attributionText)
+		if err != nil {
+			return "", fmt.Errorf("parse attribution template %w", err)
+		}
+		templates = append(templates, t)
+	}
+
+	var parts []string
+	for _, t := range templates {
+		var sb strings.Builder
+		if err := t.Execute(&sb, data); err != nil {
+			return "", fmt.Errorf("execute trip report template %w", err)
+		}
+		part := strings.TrimSpace(sb.String())
+		if t == tmpl {
+			part = withRoute(part, data.Route)
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// Names a matched standard route in the report if its template doesn't.
+// Peakbagger's route field isn't set, so the report is where the route goes.
+func withRoute(report string, d *RouteDeviation) string {
+	if d == nil || !d.Matched || strings.Contains(report, d.Route) {
+		return report
+	}
+	route := fmt.Sprintf("Route: %s.", d.Route)
+	if report == "" {
+		return route
+	}
+	return report + "\n\n" + route
+}
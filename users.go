@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	usersFile        = flag.String("users_file", "users.json", "User list for the serve and users commands")
+	usersSecretsFile = flag.String("users_secrets_file", "users.secrets.json", "Passwords of the users in -users_file, only readable by its owner. $"+passwordEnv+"_<NAME> overrides a user's password")
+	serveConcurrency = flag.Int("serve_concurrency", 1, "Maximum number of users scanned at once by the serve command, to limit load on Peakbagger")
+)
+
+// Environment variable used to pass a password to child processes without
+// exposing it on the command line.
+const passwordEnv = "PEAKBAGGER_PASSWORD"
+
+// Log of a user's scans, kept in their directory. Not an NMEA log despite the
+// extension.
+const UploaderLogFilename = "uploader.log"
+
+// A user of a shared instance. Each user's scans run in a separate process
+// with their own credentials, directory (and so history), report and log.
+type UserConfig struct {
+	Name string
+
+	Username string
+	// Kept in -users_secrets_file, only read from users files written
+	// before passwords moved there.
+	Password string `json:",omitempty"`
+
+	Directory string
+	// Cron schedule for scans, default hourly.
+	Schedule string `json:",omitempty"`
+	// Minimum time between this user's Peakbagger requests, default
+	// -request_delay.
+	RequestDelay string `json:",omitempty"`
+	// Additional flags for this user's scans, e.g. ["-fetch_peak_info"].
+	Args []string `json:",omitempty"`
+}
+
+func LoadUsers() ([]*UserConfig, error) {
+	b, err := os.ReadFile(longPath(*usersFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserConfig
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, fmt.Errorf("parse %s %w", *usersFile, err)
+	}
+	secrets, err := loadUserSecrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if u.Password != "" {
+			log.Warnf("%s holds the password of %q, it moves to %s when users are next added or removed", *usersFile, u.Name, *usersSecretsFile)
+		}
+		if p, ok := secrets[u.Name]; ok {
+			u.Password = p
+		}
+		if p := os.Getenv(userPasswordEnv(u.Name)); p != "" {
+			u.Password = p
+		}
+		RegisterSecret(u.Password)
+	}
+	return users, nil
+}
+
+// Environment variable overriding a user's password, e.g.
+// PEAKBAGGER_PASSWORD_ALICE.
+func userPasswordEnv(name string) string {
+	return passwordEnv + "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Loads passwords by user name, refusing a secrets file others can read.
+func loadUserSecrets() (map[string]string, error) {
+	secrets := make(map[string]string)
+	b, err := os.ReadFile(longPath(*usersSecretsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrivateFile("users secrets file", *usersSecretsFile); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("parse %s %w", *usersSecretsFile, err)
+	}
+	return secrets, nil
+}
+
+// Saves the users, with their passwords in the secrets file rather than the
+// user list.
+func SaveUsers(users []*UserConfig) error {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	secrets := make(map[string]string)
+	var list []UserConfig
+	for _, u := range users {
+		if u.Password != "" && u.Password != os.Getenv(userPasswordEnv(u.Name)) {
+			secrets[u.Name] = u.Password
+		}
+		c := *u
+		c.Password = ""
+		list = append(list, c)
+	}
+	b, err := json.MarshalIndent(secrets, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(longPath(*usersSecretsFile), b, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	if err := os.Chmod(longPath(*usersSecretsFile), 0600); err != nil {
+		return err
+	}
+	if b, err = json.MarshalIndent(list, "", " "); err != nil {
+		return err
+	}
+	return os.WriteFile(longPath(*usersFile), b, 0644)
+}
+
+// Admin commands: users list, users add and users remove.
+func RunUsersCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: users list|add|remove")
+	}
+	users, err := LoadUsers()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for _, u := range users {
+			schedule := u.Schedule
+			if schedule == "" {
+				schedule = "@hourly"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", u.Name, u.Username, u.Directory, schedule)
+		}
+		return nil
+
+	case "add":
+		fs := flag.NewFlagSet("users add", flag.ContinueOnError)
+		c := &UserConfig{}
+		fs.StringVar(&c.Username, "username", "", "Peakbagger username")
+		fs.StringVar(&c.Directory, "directory", "", "Input directory")
+		fs.StringVar(&c.Schedule, "schedule", "", "Cron schedule for scans (default @hourly)")
+		fs.StringVar(&c.RequestDelay, "request_delay", "", "Minimum time between this user's Peakbagger requests")
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: users add <name> -username <username> -directory <dir> [-schedule <cron>] [-request_delay <duration>] [-- extra flags]")
+		}
+		c.Name = args[1]
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		c.Args = fs.Args()
+		if c.Username == "" || c.Directory == "" {
+			return fmt.Errorf("users add requires -username and -directory")
+		}
+		if c.Schedule != "" {
+			if _, err := parseCron(c.Schedule); err != nil {
+				return fmt.Errorf("schedule %q: %w", c.Schedule, err)
+			}
+		}
+		if c.RequestDelay != "" {
+			if _, err := time.ParseDuration(c.RequestDelay); err != nil {
+				return err
+			}
+		}
+		if c.Directory, err = filepath.Abs(c.Directory); err != nil {
+			return err
+		}
+		if c.Password = os.Getenv(passwordEnv); c.Password == "" {
+			if c.Password, err = askPassword("Peakbagger password for " + c.Username); err != nil {
+				return fmt.Errorf("read password %w", err)
+			}
+		}
+		RegisterSecret(c.Password)
+
+		for i, u := range users {
+			if u.Name == c.Name {
+				users = append(users[:i], users[i+1:]...)
+				log.Infof("Replacing user %q", c.Name)
+				break
+			}
+		}
+		return SaveUsers(append(users, c))
+
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: users remove <name>")
+		}
+		for i, u := range users {
+			if u.Name == args[1] {
+				return SaveUsers(append(users[:i], users[i+1:]...))
+			}
+		}
+		return fmt.Errorf("no user %q", args[1])
+	}
+	return fmt.Errorf("unknown users command %q", args[0])
+}
+
+// Runs every user's scans on their schedules forever. Each scan is a child
+// process so credentials, cookies, history and rate limiting are isolated
+// between users; at most -serve_concurrency scans run at once.
+func Serve() error {
+	if *serveConcurrency < 1 {
+		return fmt.Errorf("-serve_concurrency must be at least 1, got %d", *serveConcurrency)
+	}
+	users, err := LoadUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no users in %s, add some with the users add command", *usersFile)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	slots := make(chan struct{}, *serveConcurrency)
+	var crons []*cronSchedule
+	guards := make([]overlapGuard, len(users))
+	for _, u := range users {
+		spec := u.Schedule
+		if spec == "" {
+			spec = "@hourly"
+		}
+		c, err := parseCron(spec)
+		if err != nil {
+			return fmt.Errorf("user %q schedule %q: %w", u.Name, spec, err)
+		}
+		crons = append(crons, c)
+		log.Infof("Serving %q, scanning %q at %q", u.Name, u.Directory, spec)
+	}
+
+	return runCron(crons, func(i int) {
+		u := users[i]
+		if !guards[i].start() {
+			log.Warnf("Previous scan for %q still running, skipping", u.Name)
+			return
+		}
+		defer guards[i].done()
+
+		slots <- struct{}{}
+		defer func() { <-slots }()
+
+		log.Infof("Scanning for %q", u.Name)
+		if err := runUserScan(exe, u); err != nil {
+			log.Errorf("Scan for %q failed: %v", u.Name, err)
+		}
+	})
+}
+
+// Runs a single scan for a user in a child process, logging to the user's
+// directory.
+func runUserScan(exe string, u *UserConfig) error {
+	args := []string{
+		"-username", u.Username,
+		"-directory", u.Directory,
+		"-report", filepath.Join(u.Directory, "report.json"),
+	}
+	if u.RequestDelay != "" {
+		args = append(args, "-request_delay", u.RequestDelay)
+	}
+	if *maxPerMinute > 0 {
+		// Concurrent scans share one IP, split the budget between them.
+		perScan := *maxPerMinute / *serveConcurrency
+		if perScan < 1 {
+			perScan = 1
+		}
+		args = append(args, "-max_requests_per_minute", fmt.Sprint(perScan))
+	}
+	args = append(args, u.Args...)
+
+	logFile, err := os.OpenFile(longPath(filepath.Join(u.Directory, UploaderLogFilename)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), passwordEnv+"="+u.Password)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	return cmd.Run()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Walks through first-run setup on the terminal and writes the answers to
+// the config file, see -config.
+func RunInit() error {
+	p := *configFile
+	if p == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		p = filepath.Join(dir, "peakbagger-bulk-uploader", "config.json")
+	}
+	if _, err := os.Stat(longPath(p)); err == nil {
+		if !confirm("%s already exists. Overwrite it?", p) {
+			return nil
+		}
+	}
+
+	fmt.Println("Setting up peakbagger-bulk-uploader. Press enter to accept the [default].")
+	fmt.Println()
+	values := make(map[string]interface{})
+
+	fmt.Println("Peakbagger account")
+	if username := ask("  Username", *usernamePB); username != "" {
+		values["username"] = username
+	}
+	fmt.Printf("  The password can be kept in the config file (readable only by you), or set in $%s each run.\n", passwordEnv)
+	if confirm("  Store the password in the config file? (it is shown as you type)") {
+		if password := ask("  Password", ""); password != "" {
+			values["password"] = password
+		}
+	}
+	fmt.Println()
+
+	if path, err := exec.LookPath("gpsbabel"); err == nil {
+		fmt.Printf("Found gpsbabel at %s, all supported formats can be read.\n", path)
+	} else {
+		fmt.Println("gpsbabel was not found on your PATH. GPX, FIT, TCX, GeoJSON and IGC files can still be read,")
+		fmt.Println("install gpsbabel from https://www.gpsbabel.org to read KML, KMZ, GDB and NMEA files.")
+	}
+	fmt.Println()
+
+	for {
+		dir := ask("Directory of track files to upload", *inputDirectory)
+		if dir == "" {
+			break
+		}
+		if fi, err := os.Stat(longPath(dir)); err != nil || !fi.IsDir() {
+			fmt.Printf("  %q is not a directory.\n", dir)
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		values["directory"] = abs
+		break
+	}
+	fmt.Println()
+
+	if choose("Units for trip reports:", []string{"imperial (ft, mi)", "metric (m, km)"}) == 1 {
+		values["units"] = "metric"
+	}
+	fmt.Println()
+
+	switch choose("Trip report template:", []string{"built-in", "my own template file"}) {
+	case 0:
+		for _, o := range []struct{ flag, question string }{
+			{"trip_report_pace", "Include distance and pace?"},
+			{"trip_report_hr", "Include heart rate, when recorded?"},
+			{"trip_report_conditions", "Include inferred snow conditions?"},
+			{"trip_report_daylight", "Include daylight used?"},
+		} {
+			if confirm("  %s", o.question) {
+				values[o.flag] = true
+			}
+		}
+	case 1:
+		if t := ask("  Template file", *tripReportTemplate); t != "" {
+			abs, err := filepath.Abs(t)
+			if err != nil {
+				return err
+			}
+			values["trip_report_template"] = abs
+		}
+	}
+	fmt.Println()
+
+	b, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(p)), 0700); err != nil {
+		return err
+	}
+	// May hold the password.
+	if err := os.WriteFile(longPath(p), b, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s. Check it with: peakbagger-bulk-uploader config check\n", p)
+	fmt.Println("Then preview a run with -dry_run before uploading.")
+	return nil
+}